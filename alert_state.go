@@ -0,0 +1,121 @@
+package cagent
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/securez-one/cagent/pkg/alertstate"
+	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/monitoring"
+	"github.com/securez-one/cagent/pkg/rules"
+)
+
+// scopes of the tracked alerts, alerts of a scope are only cleared when the scope was collected
+const (
+	alertScopeModules = "modules"
+	alertScopeSMART   = "smart"
+)
+
+func (ca *Cagent) initAlertState() {
+	ca.alertState = nil
+	if !ca.Config.AlertState.Enabled {
+		return
+	}
+
+	store, err := alertstate.Open(ca.Config.AlertState.File, secToDuration(ca.Config.AlertState.FlapWindow))
+	if err != nil {
+		log.WithError(err).Error("failed to load the alert state")
+	}
+	ca.alertState = store
+}
+
+// trackAlerts updates the alert state with the alerts of the modules and S.M.A.R.T.
+// and adds the open alerts and the transitions of this run to the measurements
+func (ca *Cagent) trackAlerts(measurements common.MeasurementsMap, modulesCollected bool) {
+	if ca.alertState == nil {
+		return
+	}
+
+	now := time.Now()
+	var transitions []alertstate.Transition
+
+	update := func(scope string, conditions []alertstate.Condition) {
+		t, err := ca.alertState.Update(scope, conditions, now)
+		if err != nil {
+			log.WithError(err).Error("failed to update the alert state")
+		}
+		transitions = append(transitions, t...)
+	}
+
+	if modulesCollected {
+		reports, _ := measurements["modules"].([]*monitoring.ModuleReport)
+		update(alertScopeModules, moduleConditions(reports))
+	}
+
+	if smart, collected := measurements["smartmon"].(common.MeasurementsMap); collected {
+		update(alertScopeSMART, smartConditions(smart))
+	}
+
+	measurements["alerts.open"] = ca.alertState.Alerts()
+	if transitions == nil {
+		transitions = []alertstate.Transition{}
+	}
+	measurements["alerts.transitions"] = transitions
+}
+
+func moduleConditions(reports []*monitoring.ModuleReport) []alertstate.Condition {
+	var conditions []alertstate.Condition
+	for _, r := range reports {
+		if r.Name == rules.ReportName {
+			// the rules keep their state themselves
+			continue
+		}
+		for _, alert := range r.Alerts {
+			conditions = append(conditions, alertstate.Condition{
+				Source:   alertScopeModules,
+				Module:   r.Name,
+				Key:      r.Key(string(alert)),
+				Severity: "alert",
+				Message:  string(alert),
+			})
+		}
+		for _, warning := range r.Warnings {
+			conditions = append(conditions, alertstate.Condition{
+				Source:   alertScopeModules,
+				Module:   r.Name,
+				Key:      r.Key(string(warning)),
+				Severity: "warning",
+				Message:  string(warning),
+			})
+		}
+	}
+
+	return conditions
+}
+
+// smartConditions reports the disks with the failed S.M.A.R.T. status
+func smartConditions(smart common.MeasurementsMap) []alertstate.Condition {
+	disks := make([]string, 0, len(smart))
+	for disk := range smart {
+		disks = append(disks, disk)
+	}
+	sort.Strings(disks)
+
+	var conditions []alertstate.Condition
+	for _, disk := range disks {
+		attrs, isMap := smart[disk].(map[string]interface{})
+		if isMap && attrs["smart_status"] == "FAILED" {
+			conditions = append(conditions, alertstate.Condition{
+				Source:   alertScopeSMART,
+				Key:      disk,
+				Severity: "alert",
+				Message:  fmt.Sprintf("S.M.A.R.T. status of %s is FAILED", disk),
+			})
+		}
+	}
+
+	return conditions
+}
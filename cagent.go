@@ -5,18 +5,26 @@ import (
 	"net/http"
 	"runtime"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
 	"github.com/cloudradar-monitoring/selfupdate"
 
+	"github.com/securez-one/cagent/pkg/alertstate"
+	"github.com/securez-one/cagent/pkg/delta"
+	"github.com/securez-one/cagent/pkg/events"
+	"github.com/securez-one/cagent/pkg/hostid"
 	"github.com/securez-one/cagent/pkg/monitoring/fs"
 	"github.com/securez-one/cagent/pkg/monitoring/networking"
 	"github.com/securez-one/cagent/pkg/monitoring/sensors"
 	"github.com/securez-one/cagent/pkg/monitoring/updates"
 	"github.com/securez-one/cagent/pkg/monitoring/vmstat"
 	"github.com/securez-one/cagent/pkg/monitoring/vmstat/types"
+	"github.com/securez-one/cagent/pkg/notifier"
+	"github.com/securez-one/cagent/pkg/outbox"
+	"github.com/securez-one/cagent/pkg/rules"
 	"github.com/securez-one/cagent/pkg/smart"
 )
 
@@ -36,6 +44,12 @@ type Cagent struct {
 
 	hubClient     *http.Client
 	hubClientOnce sync.Once
+	// hubPrimary is the Hub set with hub_url or hub_urls, hubForwarders deliver to the [[hub_destinations]]
+	hubPrimaryLock sync.Mutex
+	hubPrimary     *hubDestination
+	hubForwarders  []*hubForwarder
+	// rotatingCredentials is 1 while new credentials are fetched from hub_enrollment_url
+	rotatingCredentials int32
 
 	cpuWatcher             *CPUWatcher
 	cpuUtilisationAnalyser *CPUUtilisationAnalyser
@@ -45,8 +59,53 @@ type Cagent struct {
 
 	vmstatLazyInit sync.Once
 	vmWatchers     map[string]types.Provider
-	hwInventory    sync.Once
 	smart          *smart.SMART
+
+	sinks  []configuredSink
+	outbox *outbox.Outbox
+
+	lastResultLock sync.RWMutex
+	lastCollected  *Result
+
+	status agentStatus
+
+	runningCollectorsLock sync.Mutex
+	runningCollectors     map[string]bool
+
+	collectorCacheLock sync.Mutex
+	collectorCache     map[string]collectorCacheEntry
+
+	// held for reading while collecting and taking a delivery, for writing while swapping the config.
+	// The requests to the Hub are sent without holding it
+	reloadLock sync.RWMutex
+	// remoteConfigLock serializes applying of the remote config overlays
+	remoteConfigLock sync.Mutex
+
+	// deltaEncoder is set if delta_encoding is enabled
+	deltaEncoder *delta.Encoder
+
+	// eventsPublisher is set if events are enabled
+	eventsPublisher *events.Publisher
+
+	// hostIdentity is nil if the host UUID could not be generated, hostClone is set if the host was cloned
+	hostIdentity *hostid.Identity
+	hostClone    *hostid.Clone
+
+	// alertState is set if alert_state is enabled
+	alertState *alertstate.Store
+
+	// webhookNotifier is set if webhook_notifier is enabled
+	webhookNotifier *notifier.Webhook
+
+	// rulesEngine is set if any [[rules]] are configured
+	rulesEngine *rules.Engine
+
+	// collectNow wakes up the Run loop on request of the Hub
+	collectNow        chan struct{}
+	hubActionsLock    sync.Mutex
+	hubActionsLastRun map[string]time.Time
+	// hubActionResults are sent with the measurements until the Hub accepted them
+	hubActionResults []HubActionResult
 }
 
 func New(cfg *Config, cfgPath string) (*Cagent, error) {
@@ -54,17 +113,27 @@ func New(cfg *Config, cfgPath string) (*Cagent, error) {
 		Config:         cfg,
 		ConfigLocation: cfgPath,
 		vmWatchers:     make(map[string]types.Provider),
+		status:         agentStatus{startedAt: time.Now()},
+
+		runningCollectors: make(map[string]bool),
+		collectorCache:    make(map[string]collectorCacheEntry),
+		collectNow:        make(chan struct{}, 1),
+		hubActionsLastRun: make(map[string]time.Time),
 	}
 
 	ca.configureLogger()
 
-	if ca.Config.SMARTMonitoring && ca.Config.SMARTCtl != "" {
-		var err error
-		ca.smart, err = smart.New(smart.Executable(ca.Config.SMARTCtl, false))
-		if err != nil {
-			logrus.Error(err.Error())
-		}
-	}
+	ca.initHostIdentity()
+	ca.initSMART()
+	ca.initSinks()
+	ca.initHubDestinations()
+	ca.initOutbox()
+	ca.initDeltaEncoder()
+	ca.initRulesEngine()
+	ca.initAlertState()
+	ca.initEvents()
+	ca.initWebhookNotifier()
+	ca.status.setActiveConfig(ca.Config, ca.outbox)
 
 	err := ca.configureAutomaticSelfUpdates()
 	if err != nil {
@@ -98,6 +167,19 @@ func (ca *Cagent) configureAutomaticSelfUpdates() error {
 	return nil
 }
 
+// setLastResult keeps the latest collected result to be served by the local endpoints
+func (ca *Cagent) setLastResult(result *Result) {
+	ca.lastResultLock.Lock()
+	defer ca.lastResultLock.Unlock()
+	ca.lastCollected = result
+}
+
+func (ca *Cagent) lastResult() *Result {
+	ca.lastResultLock.RLock()
+	defer ca.lastResultLock.RUnlock()
+	return ca.lastCollected
+}
+
 func (ca *Cagent) userAgent() string {
 	if Version == "" {
 		Version = "{undefined}"
@@ -105,7 +187,51 @@ func (ca *Cagent) userAgent() string {
 	return fmt.Sprintf("Cagent v%s %s %s", Version, runtime.GOOS, runtime.GOARCH)
 }
 
+func (ca *Cagent) initSMART() {
+	ca.smart = nil
+	if ca.Config.SMARTMonitoring && ca.Config.SMARTCtl != "" {
+		var err error
+		ca.smart, err = smart.New(smart.Executable(ca.Config.SMARTCtl, false))
+		if err != nil {
+			logrus.Error(err.Error())
+		}
+	}
+}
+
+func (ca *Cagent) initOutbox() {
+	ca.outbox = nil
+	if ca.Config.Outbox.Enabled {
+		ca.outbox = outbox.New(ca.Config.Outbox.DirPath, ca.Config.Outbox.limits(), logrus.StandardLogger())
+	}
+}
+
+func (ca *Cagent) initDeltaEncoder() {
+	ca.deltaEncoder = nil
+	if ca.Config.DeltaEncoding.Enabled {
+		ca.deltaEncoder = delta.NewEncoder(secToDuration(ca.Config.DeltaEncoding.FullInterval))
+	}
+}
+
+// initRulesEngine creates the engine with the rules of the config, the state of the previous rules is dropped
+func (ca *Cagent) initRulesEngine() {
+	ca.rulesEngine = nil
+	if len(ca.Config.Rules) == 0 {
+		return
+	}
+
+	engine, err := rules.NewEngine(ca.Config.Rules)
+	if err != nil {
+		logrus.WithError(err).Error("failed to init the rules")
+		return
+	}
+	ca.rulesEngine = engine
+}
+
 func (ca *Cagent) Shutdown() {
+	defer ca.stopEvents()
+	defer ca.stopHubDestinations()
+	defer ca.stopWebhookNotifier()
+	defer ca.closeSinks()
 	defer sensors.Shutdown()
 	defer updates.Shutdown()
 	defer func() {
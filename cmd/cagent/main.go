@@ -68,7 +68,8 @@ func main() {
 	daemonizeModePtr := flag.Bool("d", false, "daemonize – run the process in background")
 	oneRunOnlyModePtr := flag.Bool("r", false, "one run only – perform checks once and exit. Overwrites output file")
 	serviceUninstallPtr := flag.Bool("u", false, fmt.Sprintf("stop and uninstall the system service(%s)", systemManager.String()))
-	printConfigPtr := flag.Bool("p", false, "print the active config")
+	printConfigPtr := flag.Bool("p", false, "print the active config and the config file or environment variable each value came from")
+	statusPtr := flag.Bool("status", false, "query the status API of the running cagent and print the status and the last collected result")
 	testConfigPtr := flag.Bool("t", false, "test the HUB config")
 	assumeYesPtr := flag.Bool("y", false, "automatic yes to prompts. Assume 'yes' as answer to all prompts and run non-interactively")
 	flagServiceStatusPtr := flag.Bool("service_status", false, "check status of cagent within system service")
@@ -84,6 +85,8 @@ func main() {
 	}
 
 	versionPtr := flag.Bool("version", false, "show the cagent version")
+	enrollTokenPtr := flag.String("enroll", "", "exchange the one-time enrollment token for the credentials of this host and create the config. Requires -hub")
+	enrollHubPtr := flag.String("hub", "", "enrollment URL of the Hub used with -enroll")
 
 	// some OS specific flags
 	if runtime.GOOS == "windows" {
@@ -109,6 +112,9 @@ func main() {
 		}
 	}
 
+	// enrollment creates the config, it must run before the default config is created
+	handleFlagEnroll(*enrollTokenPtr, *enrollHubPtr, *cfgPathPtr, *assumeYesPtr)
+
 	cfg, err := cagent.HandleAllConfigSetup(*cfgPathPtr)
 	if err != nil {
 		log.WithError(err).Fatalln("Failed to handle Cagent configuration")
@@ -120,6 +126,7 @@ func main() {
 	}
 
 	handleFlagPrintConfig(*printConfigPtr, cfg)
+	handleFlagStatus(*statusPtr, cfg)
 	handleFlagSearchUpdates(searchUpdatesPtr)
 	handleFlagUpdate(updatePtr, assumeYesPtr)
 
@@ -165,16 +172,18 @@ func main() {
 	// nothing resulted in os.Exit
 	// so lets use the default continuous run mode and wait for interrupt
 	// setup interrupt handler
+	// SIGHUP reloads the config, see WatchConfig
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc,
-		syscall.SIGHUP,
 		syscall.SIGINT,
 		syscall.SIGTERM)
 	heartbeatInterruptChan := make(chan struct{})
 	interruptChan := make(chan struct{})
+	configWatchInterruptChan := make(chan struct{})
 
 	defer ca.Shutdown()
 
+	go ca.WatchConfig(configWatchInterruptChan)
 	go ca.RunHeartbeat(heartbeatInterruptChan)
 	if ca.Config.OperationMode != cagent.OperationModeHeartbeat {
 		go ca.Run(output, interruptChan)
@@ -187,6 +196,7 @@ func main() {
 		interruptChan <- struct{}{}
 	}
 	heartbeatInterruptChan <- struct{}{}
+	configWatchInterruptChan <- struct{}{}
 }
 
 func handleFlagVersion(versionFlag bool) {
@@ -263,13 +273,69 @@ func handleServiceCommand(ca *cagent.Cagent, check, start, stop, restart bool) {
 	}
 }
 
+func handleFlagEnroll(token, hubURL, configPath string, assumeYes bool) {
+	if token == "" && hubURL == "" {
+		return
+	}
+	if token == "" || hubURL == "" {
+		log.Fatalln("-enroll and -hub must be used together")
+	}
+
+	backupPath := ""
+	if _, err := os.Stat(configPath); err == nil {
+		if !assumeYes && !askForConfirmation(fmt.Sprintf("%s already exists. Replace it? The current config is kept as %s.bak", configPath, configPath)) {
+			os.Exit(1)
+		}
+		backupPath = configPath + ".bak"
+		if err := os.Rename(configPath, backupPath); err != nil {
+			log.WithError(err).Fatalln("Failed to back up the current config")
+		}
+	}
+
+	if err := cagent.Enroll(configPath, hubURL, token); err != nil {
+		fmt.Printf("Enrollment failed: %s\n", err.Error())
+		if backupPath != "" {
+			// the agent keeps running with the previous config
+			if err := os.Rename(backupPath, configPath); err != nil {
+				fmt.Printf("Failed to restore the previous config from %s: %s\n", backupPath, err.Error())
+			}
+		}
+		os.Exit(1)
+	}
+
+	fmt.Printf("Enrolled! The config was written to %s\n", configPath)
+	os.Exit(0)
+}
+
 func handleFlagPrintConfig(printConfig bool, cfg *cagent.Config) {
 	if printConfig {
-		fmt.Println(cfg.DumpToml())
+		fmt.Println(cfg.DumpTomlWithSources())
 		os.Exit(0)
 	}
 }
 
+func handleFlagStatus(status bool, cfg *cagent.Config) {
+	if !status {
+		return
+	}
+
+	body, err := cagent.QueryStatusAPI(cfg.StatusAPI.Listen, "/status")
+	if err != nil {
+		fmt.Printf("Failed to get the status: %s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("Status:\n%s\n", body)
+
+	// there is no result yet right after start or in the heartbeat operation mode
+	body, err = cagent.QueryStatusAPI(cfg.StatusAPI.Listen, "/result")
+	if err != nil {
+		fmt.Printf("Last result is not available: %s\n", err.Error())
+		os.Exit(0)
+	}
+	fmt.Printf("Last result:\n%s\n", body)
+	os.Exit(0)
+}
+
 func handleFlagSettings(settingsUI *bool, ca *cagent.Cagent) {
 	if settingsUI != nil && *settingsUI {
 		windowsShowSettingsUI(ca, false)
@@ -631,19 +697,27 @@ func rerunDetached() error {
 }
 
 type serviceWrapper struct {
-	Cagent                 *cagent.Cagent
-	InterruptChan          chan struct{}
-	HeartbeatInterruptChan chan struct{}
-	WG                     sync.WaitGroup
+	Cagent                   *cagent.Cagent
+	InterruptChan            chan struct{}
+	HeartbeatInterruptChan   chan struct{}
+	ConfigWatchInterruptChan chan struct{}
+	WG                       sync.WaitGroup
 }
 
 func (sw *serviceWrapper) Start(s service.Service) error {
 	sw.InterruptChan = make(chan struct{})
 	sw.WG = sync.WaitGroup{}
 	sw.HeartbeatInterruptChan = make(chan struct{})
+	sw.ConfigWatchInterruptChan = make(chan struct{})
 
 	log.Errorf("cagent v%s starting in service mode...", cagent.Version)
 
+	sw.WG.Add(1)
+	go func() {
+		defer sw.WG.Done()
+		sw.Cagent.WatchConfig(sw.ConfigWatchInterruptChan)
+	}()
+
 	sw.WG.Add(1)
 	go func() {
 		defer sw.WG.Done()
@@ -669,6 +743,7 @@ func (sw *serviceWrapper) Stop(s service.Service) error {
 		sw.InterruptChan <- struct{}{}
 	}
 	sw.HeartbeatInterruptChan <- struct{}{}
+	sw.ConfigWatchInterruptChan <- struct{}{}
 	sw.WG.Wait()
 	return nil
 }
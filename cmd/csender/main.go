@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/securez-one/cagent"
+	"github.com/securez-one/cagent/pkg/common"
 	"github.com/securez-one/cagent/pkg/csender"
 )
 
@@ -74,6 +75,10 @@ func main() {
 	retriesPtr := flag.String("r", "5", "number of retries")
 	maxTimePtr := flag.String("m", "15", "hub connection timeout in seconds")
 	verbosePtr := flag.Bool("v", false, "verbose")
+	tlsClientCertPtr := flag.String("hub_tls_client_cert", "", "PEM file of the client certificate sent to the hub if it requests one")
+	tlsClientKeyPtr := flag.String("hub_tls_client_key", "", "PEM file of the private key of the client certificate")
+	tlsCAFilePtr := flag.String("hub_tls_ca_file", "", "PEM file of the CAs to verify the hub certificate with instead of the system ones")
+	tlsPinnedPtr := flag.String("hub_tls_pinned_sha256", "", "comma-separated base64 or hex encoded SHA-256 hashes of the accepted public keys of the hub or its CAs")
 
 	versionPtr := flag.Bool("version", false, "show the csender version")
 	flag.Usage = func() {
@@ -114,28 +119,23 @@ func main() {
 		Timeout:    15 * time.Second,
 		HubGzip:    true,
 		RetryLimit: 5,
-	}
-
-	var kvParams []string
-	var skipNext bool
-	for _, arg := range os.Args[1:] {
-		if skipNext {
-			skipNext = false
-			continue
-		}
-
-		if strings.HasPrefix("-", arg) {
-			skipNext = true
-			continue
+		TLS: common.HubTLSConfig{
+			ClientCertFile: *tlsClientCertPtr,
+			ClientKeyFile:  *tlsClientKeyPtr,
+			CAFile:         *tlsCAFilePtr,
+		},
+	}
+	for _, pin := range strings.Split(*tlsPinnedPtr, ",") {
+		if pin = strings.TrimSpace(pin); pin != "" {
+			cs.TLS.PinnedSHA256 = append(cs.TLS.PinnedSHA256, pin)
 		}
-
-		if !strings.Contains(arg, "=") {
-			continue
-		}
-
-		kvParams = append(kvParams, arg)
+	}
+	if err := cs.TLS.Validate(); err != nil {
+		fatal(strings.Replace(err.Error(), "hub_tls_", "-hub_tls_", -1))
 	}
 
+	kvParams := keyValueArgs(flag.CommandLine, os.Args[1:])
+
 	err := cs.AddMultipleKeyValue(kvParams)
 	if err != nil {
 		fatal(err.Error())
@@ -191,3 +191,33 @@ func main() {
 func printVersion() {
 	fmt.Printf("csender - tool for sending custom check results to Hub.\nPart of cagent package v%s %s\n", cagent.Version, cagent.LicenseInfo)
 }
+
+// keyValueArgs returns the key=value arguments, skipping the flags of fs and their values
+func keyValueArgs(fs *flag.FlagSet, args []string) []string {
+	var kvParams []string
+	var skipNext bool
+	for _, arg := range args {
+		if skipNext {
+			skipNext = false
+			continue
+		}
+
+		if strings.HasPrefix(arg, "-") {
+			// the value follows the flag unless it's a bool flag or given as -flag=value
+			f := fs.Lookup(strings.TrimLeft(arg, "-"))
+			if f != nil {
+				boolFlag, isBool := f.Value.(interface{ IsBoolFlag() bool })
+				skipNext = !isBool || !boolFlag.IsBoolFlag()
+			}
+			continue
+		}
+
+		if !strings.Contains(arg, "=") {
+			continue
+		}
+
+		kvParams = append(kvParams, arg)
+	}
+
+	return kvParams
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyValueArgs(t *testing.T) {
+	fs := flag.NewFlagSet("csender", flag.ContinueOnError)
+	fs.String("t", "", "")
+	fs.String("a", "", "")
+	fs.Bool("v", false, "")
+
+	args := []string{"-t", "token", "load=1.5", "-v", "disk=ok", "-a=raid degraded", "--a", "x=1", "free=20%", "ignored"}
+	assert.Equal(t, []string{"load=1.5", "disk=ok", "free=20%"}, keyValueArgs(fs, args))
+}
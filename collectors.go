@@ -0,0 +1,448 @@
+package cagent
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/mem"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/hwinfo"
+	"github.com/securez-one/cagent/pkg/jobmon"
+	"github.com/securez-one/cagent/pkg/monitoring/docker"
+	"github.com/securez-one/cagent/pkg/monitoring/networking"
+	"github.com/securez-one/cagent/pkg/monitoring/processes"
+	"github.com/securez-one/cagent/pkg/monitoring/sensors"
+	"github.com/securez-one/cagent/pkg/monitoring/services"
+	"github.com/securez-one/cagent/pkg/monitoring/updates"
+)
+
+// names of the collectors, used in [collector_timeouts] and in cagent.timed_out_collectors
+const (
+	CollectorCPU          = "cpu"
+	CollectorFS           = "fs"
+	CollectorMem          = "mem"
+	CollectorSystem       = "system"
+	CollectorNet          = "net"
+	CollectorProcesses    = "processes"
+	CollectorSwap         = "swap"
+	CollectorVirt         = "virt"
+	CollectorHWInventory  = "hw_inventory"
+	CollectorUpdates      = "updates"
+	CollectorServices     = "services"
+	CollectorDocker       = "docker"
+	CollectorTemperatures = "temperatures"
+	CollectorModules      = "modules"
+	CollectorSMART        = "smart"
+	CollectorJobmon       = "jobmon"
+)
+
+var collectorNames = []string{
+	CollectorCPU,
+	CollectorFS,
+	CollectorMem,
+	CollectorSystem,
+	CollectorNet,
+	CollectorProcesses,
+	CollectorSwap,
+	CollectorVirt,
+	CollectorHWInventory,
+	CollectorUpdates,
+	CollectorServices,
+	CollectorDocker,
+	CollectorTemperatures,
+	CollectorModules,
+	CollectorSMART,
+	CollectorJobmon,
+}
+
+// collector gathers one group of measurements. Keys of the returned map must already contain the prefix, e.g. "fs."
+type collector struct {
+	name string
+	// collect must stop, and kill the processes it started, once ctx is done
+	collect func(ctx context.Context) (common.MeasurementsMap, error)
+	// cleanup is executed after the result was delivered and only if the collector finished in time
+	cleanup func() error
+	// once means the collector runs only once unless [collector_intervals] says otherwise, its result is not re-sent
+	once bool
+}
+
+type collectorResult struct {
+	measurements common.MeasurementsMap
+	err          error
+	timedOut     bool
+	// cached is true if the collector didn't run because its interval has not passed yet
+	cached bool
+	// duration is the wall time of the run, up to the timeout
+	duration time.Duration
+}
+
+type collectorCacheEntry struct {
+	collectedAt  time.Time
+	measurements common.MeasurementsMap
+}
+
+// collectors returns the list of collectors enabled by the config in the order their results are merged.
+// The caller holds reloadLock. The collectors use the watchers taken here, so a collector still running
+// after its timeout doesn't access the ones a config reload replaces
+func (ca *Cagent) collectors(fullMode bool) []collector {
+	cfg := ca.Config
+	var list []collector
+
+	// the utilisation analyser is fed by the CPU watcher, so both are collected together
+	if cfg.CPUMonitoring {
+		cpuWatcher := ca.CPUWatcher()
+		cpuUtilisationAnalyser := ca.CPUUtilisationAnalyser()
+		list = append(list, collector{name: CollectorCPU, collect: func(_ context.Context) (common.MeasurementsMap, error) {
+			var errs common.ErrorCollector
+			measurements := common.MeasurementsMap{}
+
+			cpum, err := cpuWatcher.Results()
+			errs.Add(err)
+			measurements = measurements.AddWithPrefix("cpu.", cpum)
+
+			cpuUtilisationAnalysisResult, cpuUtilisationAnalysisIsActive, err := cpuUtilisationAnalyser.Results()
+			errs.Add(err)
+			measurements = measurements.AddWithPrefix("cpu_utilisation_analysis.", cpuUtilisationAnalysisResult)
+			if cpuUtilisationAnalysisIsActive {
+				measurements = measurements.AddWithPrefix(
+					"cpu_utilisation_analysis.",
+					common.MeasurementsMap{"settings": cfg.CPUUtilisationAnalysis},
+				)
+			}
+
+			return measurements, errs.Combine()
+		}})
+	}
+
+	if cfg.FSMonitoring {
+		fsWatcher := ca.GetFileSystemWatcher()
+		list = append(list, collector{name: CollectorFS, collect: func(_ context.Context) (common.MeasurementsMap, error) {
+			fsResults, err := fsWatcher.Results()
+			return common.MeasurementsMap{}.AddWithPrefix("fs.", fsResults), err
+		}})
+	}
+
+	if cfg.MemMonitoring {
+		list = append(list, collector{name: CollectorMem, collect: func(_ context.Context) (common.MeasurementsMap, error) {
+			memResults, _, err := ca.MemResults()
+			return common.MeasurementsMap{}.AddWithPrefix("mem.", memResults), err
+		}})
+	}
+
+	if !fullMode {
+		return list
+	}
+
+	list = append(list, collector{name: CollectorSystem, collect: func(_ context.Context) (common.MeasurementsMap, error) {
+		var errs common.ErrorCollector
+		measurements := common.MeasurementsMap{}
+
+		info, err := ca.HostInfoResults()
+		errs.Add(err)
+		measurements = measurements.AddWithPrefix("system.", info)
+
+		ipResults, err := networking.IPAddresses()
+		errs.Add(err)
+		measurements = measurements.AddWithPrefix("system.", ipResults)
+
+		return measurements, errs.Combine()
+	}})
+
+	if cfg.NetMonitoring {
+		netWatcher := ca.GetNetworkWatcher()
+		list = append(list, collector{name: CollectorNet, collect: func(_ context.Context) (common.MeasurementsMap, error) {
+			netResults, err := netWatcher.Results()
+			return common.MeasurementsMap{}.AddWithPrefix("net.", netResults), err
+		}})
+	}
+
+	// listening ports are resolved to the processes, so both are collected together
+	list = append(list, collector{name: CollectorProcesses, collect: func(_ context.Context) (common.MeasurementsMap, error) {
+		var errs common.ErrorCollector
+		measurements := common.MeasurementsMap{}
+
+		var memStat *mem.VirtualMemoryStat
+		if cfg.MemMonitoring {
+			var err error
+			memStat, err = mem.VirtualMemory()
+			if err != nil {
+				log.WithError(err).Debug("processes: failed to read the system memory size")
+			}
+		}
+
+		proc, processList, err := processes.GetMeasurements(memStat, &cfg.ProcessMonitoring)
+		errs.Add(err)
+		measurements = measurements.AddWithPrefix("proc.", proc)
+
+		ports, err := ca.PortsResult(processList)
+		errs.Add(err)
+		measurements = measurements.AddWithPrefix("listeningports.", ports)
+
+		return measurements, errs.Combine()
+	}})
+
+	if cfg.MemMonitoring {
+		list = append(list, collector{name: CollectorSwap, collect: func(_ context.Context) (common.MeasurementsMap, error) {
+			swap, err := ca.SwapResults()
+			return common.MeasurementsMap{}.AddWithPrefix("swap.", swap), err
+		}})
+	}
+
+	list = append(list, collector{name: CollectorVirt, collect: func(_ context.Context) (common.MeasurementsMap, error) {
+		var errs common.ErrorCollector
+		measurements := common.MeasurementsMap{}
+		ca.getVMStatMeasurements(func(name string, meas common.MeasurementsMap, err error) {
+			if err == nil {
+				measurements = measurements.AddWithPrefix("virt."+name+".", meas)
+			}
+			errs.Add(err)
+		})
+		return measurements, errs.Combine()
+	}})
+
+	list = append(list, collector{name: CollectorHWInventory, once: true, collect: func(_ context.Context) (common.MeasurementsMap, error) {
+		hwInfo, err := hwinfo.Inventory()
+		return common.MeasurementsMap{}.AddInnerWithPrefix("hw.inventory", hwInfo), err
+	}})
+
+	if cfg.SystemUpdatesChecks.Enabled && cfg.SystemUpdatesChecks.CheckInterval > 0 {
+		list = append(list, collector{name: CollectorUpdates, collect: func(_ context.Context) (common.MeasurementsMap, error) {
+			watcher := updates.GetWatcher(cfg.SystemUpdatesChecks.FetchTimeout, cfg.SystemUpdatesChecks.CheckInterval)
+			u, err := watcher.GetSystemUpdatesInfo()
+			if err == updates.ErrorDisabledOnHost {
+				return nil, nil
+			}
+
+			var prefix string
+			if runtime.GOOS == "windows" {
+				prefix = "windows_update."
+			} else {
+				prefix = "linux_update."
+			}
+			return common.MeasurementsMap{}.AddWithPrefix(prefix, u), err
+		}})
+	}
+
+	list = append(list, collector{name: CollectorServices, collect: func(ctx context.Context) (common.MeasurementsMap, error) {
+		servicesList, err := services.ListServices(ctx, cfg.DiscoverAutostartingServicesOnly)
+		if err == services.ErrorNotImplementedForOS {
+			err = nil
+		}
+		return common.MeasurementsMap{}.AddWithPrefix("services.", servicesList), err
+	}})
+
+	if cfg.DockerMonitoring.Enabled {
+		list = append(list, collector{name: CollectorDocker, collect: func(ctx context.Context) (common.MeasurementsMap, error) {
+			containersList, err := docker.ListContainers(ctx)
+			if err == docker.ErrorNotImplementedForOS || err == docker.ErrorDockerNotAvailable {
+				err = nil
+			}
+			return common.MeasurementsMap{}.AddWithPrefix("docker.", containersList), err
+		}})
+	}
+
+	if cfg.TemperatureMonitoring {
+		list = append(list, collector{name: CollectorTemperatures, collect: func(_ context.Context) (common.MeasurementsMap, error) {
+			temperatures, err := sensors.ReadTemperatureSensors()
+			return common.MeasurementsMap{"temperatures.list": temperatures}, err
+		}})
+	}
+
+	enabledModules := ca.initModules()
+	list = append(list, collector{name: CollectorModules, collect: func(_ context.Context) (common.MeasurementsMap, error) {
+		moduleReports, err := collectModulesMeasurements(enabledModules)
+		return common.MeasurementsMap{"modules": moduleReports}, err
+	}})
+
+	sm := ca.smart
+	list = append(list, collector{name: CollectorSMART, collect: func(ctx context.Context) (common.MeasurementsMap, error) {
+		measurements := common.MeasurementsMap{}
+		smartMeas := getSMARTMeasurements(ctx, sm)
+		if len(smartMeas) > 0 {
+			measurements = measurements.AddInnerWithPrefix("smartmon", smartMeas)
+		}
+		return measurements, nil
+	}})
+
+	spool := jobmon.NewSpoolManager(cfg.JobMonitoring.SpoolDirPath, log.StandardLogger())
+	var finishedJobIDs []string
+	list = append(list, collector{
+		name: CollectorJobmon,
+		collect: func(_ context.Context) (common.MeasurementsMap, error) {
+			ids, jobs, err := spool.GetFinishedJobs()
+			finishedJobIDs = ids
+			emitJobEvents(jobs)
+			return common.MeasurementsMap{"jobmon": jobs}, err
+		},
+		cleanup: func() error {
+			return spool.RemoveJobs(finishedJobIDs)
+		},
+	})
+
+	return list
+}
+
+// runCollectors runs the collectors concurrently and waits for each of them no longer than its timeout.
+// A collector that didn't finish in time gets its context cancelled, its result is dropped
+// and it is not started again until it finishes.
+// Collectors with an interval that has not passed yet are not started, their cached result is used instead
+func (ca *Cagent) runCollectors(collectors []collector) []collectorResult {
+	results := make([]collectorResult, len(collectors))
+
+	var wg sync.WaitGroup
+	for i, c := range collectors {
+		if cached, isCached := ca.cachedCollectorResult(c); isCached {
+			results[i] = cached
+			continue
+		}
+
+		if !ca.startCollector(c.name) {
+			results[i] = collectorResult{
+				timedOut: true,
+				err:      TimeoutError{Origin: "collector " + c.name + " (still running since the previous run)", Timeout: ca.collectorTimeout(c.name)},
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, c collector) {
+			defer wg.Done()
+			results[i] = ca.runCollector(c)
+			if !results[i].timedOut {
+				ca.cacheCollectorResult(c, results[i].measurements)
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (ca *Cagent) runCollector(c collector) collectorResult {
+	started := time.Now()
+	timeout := ca.collectorTimeout(c.name)
+	// cancelling on return kills the commands of a collector that is still running after the timeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan collectorResult, 1)
+	go func() {
+		defer ca.finishCollector(c.name)
+		defer func() {
+			if err := recover(); err != nil {
+				log.Errorf("Unexpected error occurred (collector %s): %s", c.name, err)
+				panic(err)
+			}
+		}()
+
+		m, err := c.collect(ctx)
+		done <- collectorResult{measurements: m, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		r.duration = time.Since(started)
+		return r
+	case <-ctx.Done():
+		log.Warnf("collector %s timed out after %v, sending a partial result", c.name, timeout)
+		return collectorResult{timedOut: true, err: TimeoutError{Origin: "collector " + c.name, Timeout: timeout}, duration: time.Since(started)}
+	}
+}
+
+func (ca *Cagent) collectorTimeout(name string) time.Duration {
+	if t, exists := ca.Config.CollectorTimeouts[name]; exists {
+		return secToDuration(t)
+	}
+	return secToDuration(ca.Config.CollectorTimeout)
+}
+
+// startCollector marks the collector as running, returns false if it is still running
+func (ca *Cagent) startCollector(name string) bool {
+	ca.runningCollectorsLock.Lock()
+	defer ca.runningCollectorsLock.Unlock()
+
+	if ca.runningCollectors[name] {
+		return false
+	}
+	ca.runningCollectors[name] = true
+	return true
+}
+
+func (ca *Cagent) finishCollector(name string) {
+	ca.runningCollectorsLock.Lock()
+	defer ca.runningCollectorsLock.Unlock()
+
+	delete(ca.runningCollectors, name)
+}
+
+// collectorInterval returns how often the collector runs, 0 means on every collection
+func (ca *Cagent) collectorInterval(c collector) time.Duration {
+	if interval, exists := ca.Config.CollectorIntervals[c.name]; exists {
+		return secToDuration(interval)
+	}
+
+	if c.once {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return 0
+}
+
+// cachedCollectorResult returns the last result of the collector if its interval has not passed yet.
+// The measurements are omitted if collector_cache_mode = "omit" or the collector runs only once
+func (ca *Cagent) cachedCollectorResult(c collector) (collectorResult, bool) {
+	interval := ca.collectorInterval(c)
+	if interval <= 0 {
+		return collectorResult{}, false
+	}
+
+	ca.collectorCacheLock.Lock()
+	defer ca.collectorCacheLock.Unlock()
+
+	entry, exists := ca.collectorCache[c.name]
+	if !exists || time.Since(entry.collectedAt) >= interval {
+		return collectorResult{}, false
+	}
+
+	result := collectorResult{cached: true}
+	_, intervalConfigured := ca.Config.CollectorIntervals[c.name]
+	if ca.Config.CollectorCacheMode == CollectorCacheModeResend && (!c.once || intervalConfigured) {
+		result.measurements = entry.measurements
+	}
+	return result, true
+}
+
+func (ca *Cagent) cacheCollectorResult(c collector, measurements common.MeasurementsMap) {
+	if ca.collectorInterval(c) <= 0 {
+		return
+	}
+
+	ca.collectorCacheLock.Lock()
+	defer ca.collectorCacheLock.Unlock()
+
+	ca.collectorCache[c.name] = collectorCacheEntry{
+		collectedAt:  time.Now(),
+		measurements: measurements,
+	}
+}
+
+// expireCollectorCache makes the collector run on the next collection
+func (ca *Cagent) expireCollectorCache(name string) {
+	ca.collectorCacheLock.Lock()
+	defer ca.collectorCacheLock.Unlock()
+
+	delete(ca.collectorCache, name)
+}
+
+// resetCollectorCache makes all collectors run on the next collection
+func (ca *Cagent) resetCollectorCache() {
+	ca.collectorCacheLock.Lock()
+	defer ca.collectorCacheLock.Unlock()
+
+	ca.collectorCache = make(map[string]collectorCacheEntry)
+}
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"net"
 	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,12 +19,19 @@ import (
 	"github.com/troian/toml"
 
 	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/delta"
 	"github.com/securez-one/cagent/pkg/jobmon"
 	"github.com/securez-one/cagent/pkg/monitoring/mysql"
 	"github.com/securez-one/cagent/pkg/monitoring/processes"
+	"github.com/securez-one/cagent/pkg/notifier"
+	"github.com/securez-one/cagent/pkg/outbox"
+	"github.com/securez-one/cagent/pkg/rules"
 )
 
 const (
+	CollectorCacheModeResend = "resend"
+	CollectorCacheModeOmit   = "omit"
+
 	IOModeFile = "file"
 	IOModeHTTP = "http"
 
@@ -41,6 +51,9 @@ const (
 
 var operationModes = []string{OperationModeFull, OperationModeMinimal, OperationModeHeartbeat}
 
+// configDropInDir is the directory next to the main config file with additional *.toml config files
+const configDropInDir = "conf.d"
+
 var DefaultCfgPath string
 var defaultLogPath string
 
@@ -64,10 +77,14 @@ type LogsFilesConfig struct {
 }
 
 type Config struct {
-	OperationMode     string  `toml:"operation_mode" comment:"operation_mode, possible values:\n\"full\": perform all checks unless disabled individually through other config option. Default.\n\"minimal\": perform just the checks for CPU utilization, CPU Load, Memory Usage, and Disk fill levels.\n\"heartbeat\": Just send the heartbeat according to the heartbeat interval.\nApplies only to io_mode = http, ignored on the command line."`
-	Interval          float64 `toml:"interval" comment:"interval to push metrics to the HUB"`
-	HeartbeatInterval float64 `toml:"heartbeat" comment:"send a heartbeat without metrics to the HUB every X seconds"`
-	Sleep             float64 `toml:"sleep" comment:"sleep duration after failed communication with the HUB"`
+	OperationMode       string  `toml:"operation_mode" comment:"operation_mode, possible values:\n\"full\": perform all checks unless disabled individually through other config option. Default.\n\"minimal\": perform just the checks for CPU utilization, CPU Load, Memory Usage, and Disk fill levels.\n\"heartbeat\": Just send the heartbeat according to the heartbeat interval.\nApplies only to io_mode = http, ignored on the command line."`
+	Interval            float64 `toml:"interval" comment:"interval to push metrics to the HUB"`
+	HeartbeatInterval   float64 `toml:"heartbeat" comment:"send a heartbeat without metrics to the HUB every X seconds"`
+	Sleep               float64 `toml:"sleep" comment:"sleep duration after failed communication with the HUB"`
+	RandomStartOffset   bool    `toml:"random_start_offset" comment:"Wait a random part of interval before the first collection, so many hosts started at the same time don't report in lockstep.\nDefault: true"`
+	CollectorTimeout    float64 `toml:"collector_timeout" comment:"Collectors run concurrently, each of them may take up to N seconds.\nMeasurements of a collector that didn't finish in time are missing in the result, it is listed in cagent.timed_out_collectors.\nDefault: 30"`
+	ConfigWatchInterval float64 `toml:"config_watch_interval" comment:"Check the config file and the drop-ins in conf.d for changes every N seconds and reload them. 0 disables the check.\nThe config is also reloaded on SIGHUP. Default: 10"`
+	CollectorCacheMode  string  `toml:"collector_cache_mode" comment:"What to send for a collector whose interval set in [collector_intervals] has not passed yet:\n\"resend\": the last collected measurements. Default.\n\"omit\": nothing"`
 
 	PidFile   string `toml:"pid" comment:"pid file location"`
 	LogFile   string `toml:"log,omitempty" required:"false" comment:"log file location"`
@@ -75,12 +92,23 @@ type Config struct {
 
 	MinValuableConfig
 
+	HubURLs []string `toml:"hub_urls" comment:"Ordered list of Hub URLs replacing hub_url. If a URL fails with a network error or a 5xx response,\nthe next one is tried. The URL that answered is used until it fails"`
+
+	HubDestinations []HubDestinationConfig `toml:"hub_destinations" comment:"Additional Hubs receiving the measurements sent to hub_url, e.g. another tenant during a migration.\nEach destination is delivered to in the background with its own retries, without delta encoding.\nThe undelivered results wait in order in outbox.dir/hub_destinations/<name> within the outbox limits,\nor in memory up to 100 results if the outbox is disabled.\nThe responses are ignored, only the Hub set with hub_url can request actions and send a remote config.\ncagent.self.hub_destinations.<name>.* report the deliveries"`
+
+	HubEnrollmentURL string `toml:"hub_enrollment_url" comment:"Set by cagent -enroll <token> -hub <url> in conf.d/enrollment.toml together with hub_user and hub_password.\nThe credentials are rotated there when the Hub requests it"`
+
 	HubGzip           bool   `toml:"hub_gzip" comment:"enable gzip when sending results to the HUB"`
 	HubRequestTimeout int    `toml:"hub_request_timeout" comment:"time limit in seconds for requests made to Hub.\nThe timeout includes connection time, any redirects, and reading the response body.\nMin: 1, Max: 600. default: 30"`
 	HubProxy          string `toml:"hub_proxy" commented:"true"`
 	HubProxyUser      string `toml:"hub_proxy_user" commented:"true"`
 	HubProxyPassword  string `toml:"hub_proxy_password" commented:"true"`
 
+	HubTLSClientCert   string   `toml:"hub_tls_client_cert" comment:"PEM file of the client certificate sent to the Hub if it requests one, requires hub_tls_client_key"`
+	HubTLSClientKey    string   `toml:"hub_tls_client_key" comment:"PEM file of the private key of hub_tls_client_cert"`
+	HubTLSCAFile       string   `toml:"hub_tls_ca_file" comment:"PEM file of the CAs to verify the Hub certificate with instead of the system ones"`
+	HubTLSPinnedSHA256 []string `toml:"hub_tls_pinned_sha256" comment:"base64 or hex encoded SHA-256 hashes of the accepted public keys of the Hub or its CAs.\nThe connection fails unless a certificate of the Hub's chain matches one of them"`
+
 	CPULoadDataGather []string `toml:"cpu_load_data_gathering_mode" comment:"default ['avg1']"`
 	CPUUtilDataGather []string `toml:"cpu_utilisation_gathering_mode" comment:"default ['avg1']"`
 	CPUUtilTypes      []string `toml:"cpu_utilisation_types" comment:"default ['user','system','idle','iowait']"`
@@ -139,8 +167,47 @@ type Config struct {
 
 	NetMonitoring bool `toml:"net_monitoring" comment:"Turn on/off any network-related monitoring"`
 
+	SelfTelemetry bool `toml:"self_telemetry" comment:"Report the resource usage and the performance of cagent itself in cagent.self.*:\nduration of each collector, external commands, payload size, Hub latency, success and URL, retries,\nthe deliveries to the [[hub_destinations]], RSS, goroutines and CPU time\ndefault true"`
+
 	OnHTTP5xxRetries       int     `toml:"on_http_5xx_retries" comment:"Number of retries if server replies with a 5xx code"`
-	OnHTTP5xxRetryInterval float64 `toml:"on_http_5xx_retry_interval" comment:"Interval in seconds between retries to contact server in case of a 5xx code"`
+	OnHTTP5xxRetryInterval float64 `toml:"on_http_5xx_retry_interval" comment:"Interval in seconds between retries to contact server in case of a 5xx code.\nThe retries wait a random time up to this interval, doubled with each retry. A Retry-After header of the server takes precedence"`
+
+	CollectorIntervals map[string]float64 `toml:"collector_intervals" comment:"Run expensive collectors less often than interval, e.g. services = 600.0 or smart = 3600.0\nCollectors: cpu, fs, mem, system, net, processes, swap, virt, hw_inventory, updates, services, docker, temperatures, modules, smart\nhw_inventory runs only once by default"`
+
+	CollectorTimeouts map[string]float64 `toml:"collector_timeouts" comment:"Override collector_timeout for individual collectors, e.g. smart = 120.0\nCollectors: cpu, fs, mem, system, net, processes, swap, virt, hw_inventory, updates, services, docker, temperatures, modules, smart, jobmon"`
+
+	Sinks SinksConfig `toml:"sinks" comment:"Destinations for the collected measurements. Several sinks can be enabled at the same time.\nIgnored if io_mode = \"file\" or the -o flag is used, in that case the results are written only to the output file.\non_error: \"fail\" reports the delivery error and triggers the retry logic, \"log\" only logs it"`
+
+	PrometheusExporter PrometheusExporterConfig `toml:"prometheus_exporter" comment:"Expose the measurements of the last collection for scraping by Prometheus\nIgnored if operation_mode = \"heartbeat\""`
+
+	Outbox OutboxConfig `toml:"outbox" comment:"Measurements that could not be delivered to the Hub because of a 5xx error or a network failure\nare stored on disk and sent in the original order once the Hub is reachable again"`
+
+	StatusAPI StatusAPIConfig `toml:"status_api" comment:"Read-only HTTP API of the running agent: /status and /result. Used by 'cagent -status'"`
+
+	DeltaEncoding DeltaEncodingConfig `toml:"delta_encoding" comment:"Send large, slow-changing sections to the Hub in full periodically and otherwise only as added, changed and removed entries\nagainst the last snapshot acknowledged by the Hub. cagent.delta describes how each section was encoded.\nThe Hub can request a full resend with {\"full_resend\": true} in the response"`
+
+	HubActions HubActionsConfig `toml:"hub_actions" comment:"Actions the Hub can request in the response to the measurements and the heartbeats: collect, resend_hw_inventory, refresh_updates and upload_log if allowed.\nThe results are sent to the Hub in action_results with the next measurements, or with the next heartbeat in the heartbeat operation mode"`
+
+	RemoteConfig RemoteConfigConfig `toml:"remote_config" comment:"Accept config overlays sent by the Hub in its responses to the measurements and the heartbeats.\nOnly monitoring settings can be changed remotely, credentials, paths and other local settings never.\nThe overlay is applied on top of the config files, CAGENT_* environment variables still override it"`
+
+	HostIdentity HostIdentityConfig `toml:"host_identity" comment:"A host UUID is generated at the first start and sent as cagent.host_uuid and in the X-Cagent-Host-UUID header of the Hub requests.\nIt's cross-checked against the DMI product UUID, the machine ID and the MAC addresses on every start.\nIf they changed in a way that suggests the host was cloned, e.g. from a VM image, a warning is logged,\nthe host gets a new UUID and cagent.host_cloned_from reports the previous one"`
+
+	AlertState AlertStateConfig `toml:"alert_state" comment:"Track the alerts and warnings of the modules, RAID and S.M.A.R.T. across the runs.\nOpen alerts are reported with a stable ID and the first and last time seen in alerts.open,\nalerts.transitions lists the alerts opened or resolved in the run"`
+
+	Events EventsConfig `toml:"events" comment:"Push urgent events right away instead of waiting for the next report: CPU threshold crossings of cpu_utilisation_analysis,\nalerts and warnings of the modules like degraded raids, failed jobs and firing or recovered [[rules]].\nEvents are posted as {\"timestamp\": ..., \"events\": [...]}"`
+
+	WebhookNotifier WebhookNotifierConfig `toml:"webhook_notifier" comment:"Send the alerts and warnings of the modules and [[rules]] and the failed jobmon runs to a webhook,\ne.g. a chat system or a ticketing endpoint. A notification is sent when an alert fires and when it is resolved"`
+
+	Rules []rules.Rule `toml:"rules" comment:"Threshold rules evaluated after each collection, reported as the module \"rules\" with alerts, warnings and recoveries.\nKeys with dots like fs.free_percent./var can be used as they are, put keys with spaces in brackets"`
+
+	// files lists the loaded config files in the order they were applied
+	files []string
+	// sources maps the TOML keys to the file that set the value last
+	sources map[string]string
+	// secretRefs maps the keys of the secrets that were set by a reference to the reference
+	secretRefs map[string]string
+	// remoteConfigVersion is the version of the applied remote config overlay
+	remoteConfigVersion string
 }
 
 type ConfigDeprecated struct {
@@ -207,6 +274,434 @@ type JobMonitoringConfig struct {
 	Severity     jobmon.Severity `toml:"severity" comment:"Failed jobs will be processed as alerts. Possible values alert, warning or none. Default: alert"`
 }
 
+const (
+	SinkOnErrorFail = "fail"
+	SinkOnErrorLog  = "log"
+)
+
+var sinkOnErrorValues = []string{SinkOnErrorFail, SinkOnErrorLog}
+
+type SinksConfig struct {
+	Hub        HubSinkConfig        `toml:"hub" comment:"Send the results to hub_url"`
+	File       FileSinkConfig       `toml:"file" comment:"Append the results as JSON lines to a local file"`
+	Stdout     StdoutSinkConfig     `toml:"stdout" comment:"Print the results as JSON lines to the standard output"`
+	UnixSocket UnixSocketSinkConfig `toml:"unix_socket" comment:"Write the results as JSON lines to a unix socket"`
+	InfluxDB   InfluxDBSinkConfig   `toml:"influxdb" comment:"Write the numeric measurements to InfluxDB using the line protocol over HTTP"`
+	Graphite   GraphiteSinkConfig   `toml:"graphite" comment:"Write the numeric measurements to Graphite (Carbon) using the plaintext protocol over TCP"`
+	OTLP       OTLPSinkConfig       `toml:"otlp" comment:"Export the numeric measurements as OpenTelemetry metrics and module alerts and warnings as log records using OTLP/HTTP (protobuf)"`
+}
+
+type HubSinkConfig struct {
+	Enabled bool   `toml:"enabled"`
+	OnError string `toml:"on_error" comment:"Default: fail"`
+}
+
+type FileSinkConfig struct {
+	Enabled    bool    `toml:"enabled"`
+	OnError    string  `toml:"on_error" comment:"Default: log"`
+	Path       string  `toml:"path" comment:"on windows slash must be escaped"`
+	MaxSizeMB  float64 `toml:"max_size_mb" comment:"Rotate the file once it exceeds N megabytes. 0 disables the rotation. Default: 100.0"`
+	MaxBackups int     `toml:"max_backups" comment:"Number of rotated files to keep. Default: 5"`
+}
+
+type StdoutSinkConfig struct {
+	Enabled bool   `toml:"enabled"`
+	OnError string `toml:"on_error" comment:"Default: log"`
+}
+
+type UnixSocketSinkConfig struct {
+	Enabled bool   `toml:"enabled"`
+	OnError string `toml:"on_error" comment:"Default: log"`
+	Path    string `toml:"path" comment:"Path to the unix socket, the listener must be started by a 3rd party"`
+}
+
+type InfluxDBSinkConfig struct {
+	Enabled  bool   `toml:"enabled"`
+	OnError  string `toml:"on_error" comment:"Default: log"`
+	URL      string `toml:"url" comment:"Write endpoint, e.g. \"http://localhost:8086/write?db=cagent\" for InfluxDB 1.x\nor \"http://localhost:8086/api/v2/write?org=my-org&bucket=cagent\" for InfluxDB 2.x"`
+	Token    string `toml:"token" comment:"API token for InfluxDB 2.x. Takes precedence over user and password"`
+	User     string `toml:"user" comment:"Username for InfluxDB 1.x basic authentication"`
+	Password string `toml:"password"`
+	Timeout  int    `toml:"timeout" comment:"Request timeout in seconds. Default: 30"`
+}
+
+type GraphiteSinkConfig struct {
+	Enabled bool   `toml:"enabled"`
+	OnError string `toml:"on_error" comment:"Default: log"`
+	Address string `toml:"address" comment:"Carbon plaintext receiver, e.g. \"localhost:2003\""`
+	Prefix  string `toml:"prefix" comment:"Prepended to all metric paths: <prefix>.<hostname>.<measurement>[.<tags>].<field>. Default: cagent"`
+}
+
+type OTLPSinkConfig struct {
+	Enabled  bool              `toml:"enabled"`
+	OnError  string            `toml:"on_error" comment:"Default: log"`
+	Endpoint string            `toml:"endpoint" comment:"Base URL of the OTLP/HTTP receiver, /v1/metrics and /v1/logs are appended. Default: http://localhost:4318"`
+	Headers  map[string]string `toml:"headers" comment:"Additional HTTP headers, e.g. for authentication"`
+	Timeout  int               `toml:"timeout" comment:"Request timeout in seconds. Default: 30"`
+}
+
+func (s *SinksConfig) Validate() error {
+	for name, onError := range map[string]string{
+		"hub":         s.Hub.OnError,
+		"file":        s.File.OnError,
+		"stdout":      s.Stdout.OnError,
+		"unix_socket": s.UnixSocket.OnError,
+		"influxdb":    s.InfluxDB.OnError,
+		"graphite":    s.Graphite.OnError,
+		"otlp":        s.OTLP.OnError,
+	} {
+		if !common.StrInSlice(onError, sinkOnErrorValues) {
+			return fmt.Errorf("%s.on_error has invalid value. Must be one of %v", name, sinkOnErrorValues)
+		}
+	}
+
+	if s.File.Enabled {
+		if s.File.Path == "" {
+			return errors.New("file.path is empty")
+		}
+
+		if s.File.MaxSizeMB < 0 {
+			return errors.New("file.max_size_mb must be 0 or greater")
+		}
+
+		if s.File.MaxBackups < 0 {
+			return errors.New("file.max_backups must be 0 or greater")
+		}
+	}
+
+	if s.UnixSocket.Enabled && s.UnixSocket.Path == "" {
+		return errors.New("unix_socket.path is empty")
+	}
+
+	if s.InfluxDB.Enabled {
+		u, err := url.Parse(s.InfluxDB.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("influxdb.url is not a valid http(s) URL: '%s'", s.InfluxDB.URL)
+		}
+
+		if s.InfluxDB.Timeout <= 0 {
+			return errors.New("influxdb.timeout must be greater than 0")
+		}
+	}
+
+	if s.Graphite.Enabled {
+		if _, _, err := net.SplitHostPort(s.Graphite.Address); err != nil {
+			return fmt.Errorf("graphite.address is invalid: %s", err.Error())
+		}
+	}
+
+	if s.OTLP.Enabled {
+		u, err := url.Parse(s.OTLP.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("otlp.endpoint is not a valid http(s) URL: '%s'", s.OTLP.Endpoint)
+		}
+
+		if s.OTLP.Timeout <= 0 {
+			return errors.New("otlp.timeout must be greater than 0")
+		}
+	}
+
+	return nil
+}
+
+type PrometheusExporterConfig struct {
+	Listen string `toml:"listen" comment:"Address to listen on, e.g. \"127.0.0.1:9101\". Empty value disables the exporter"`
+	Path   string `toml:"path" comment:"HTTP path of the metrics endpoint. Default: /metrics"`
+}
+
+func (p *PrometheusExporterConfig) Validate() error {
+	if p.Listen == "" {
+		return nil
+	}
+
+	if _, _, err := net.SplitHostPort(p.Listen); err != nil {
+		return fmt.Errorf("listen has invalid value: %s", err.Error())
+	}
+
+	if !strings.HasPrefix(p.Path, "/") {
+		return errors.New("path must start with /")
+	}
+
+	return nil
+}
+
+type StatusAPIConfig struct {
+	Listen string `toml:"listen" comment:"Loopback address, e.g. \"127.0.0.1:9102\", or a unix socket, e.g. \"unix:/run/cagent/status.sock\".\nEmpty value disables the API"`
+}
+
+type DeltaEncodingConfig struct {
+	Enabled      bool     `toml:"enabled" comment:"Default: false"`
+	FullInterval float64  `toml:"full_interval" comment:"Send the sections in full every N seconds. Default: 3600"`
+	Sections     []string `toml:"sections" comment:"default ['services.list', 'listeningports.list', 'proc.list', 'docker.containers', 'hw.inventory']"`
+}
+
+func (d *DeltaEncodingConfig) Validate() error {
+	if !d.Enabled {
+		return nil
+	}
+
+	if d.FullInterval <= 0 {
+		return errors.New("full_interval must be positive")
+	}
+
+	for _, section := range d.Sections {
+		if _, supported := delta.IDFields[section]; !supported {
+			return fmt.Errorf("sections: unsupported section '%s'", section)
+		}
+	}
+
+	return nil
+}
+
+type HubActionsConfig struct {
+	Enabled     bool    `toml:"enabled" comment:"Set 'false' to ignore all actions requested by the Hub"`
+	MinInterval float64 `toml:"min_interval" comment:"Run each action at most once per N seconds, more frequent requests are rejected. Default: 60"`
+	UploadLog   bool    `toml:"upload_log" comment:"Set 'true' to allow the Hub to request the last lines of the log file with upload_log. Default: false"`
+	MaxLogLines int     `toml:"max_log_lines" comment:"Maximum number of the last log lines the Hub can request with upload_log. Default: 100"`
+}
+
+func (h *HubActionsConfig) Validate() error {
+	if h.MinInterval < 0 {
+		return errors.New("min_interval must be positive")
+	}
+
+	if h.MaxLogLines < 0 || h.MaxLogLines > 10000 {
+		return errors.New("max_log_lines must be between 0 and 10000")
+	}
+
+	return nil
+}
+
+type HostIdentityConfig struct {
+	File string `toml:"file" comment:"The host UUID and the identifiers it was cross-checked against are stored here"`
+}
+
+func (h *HostIdentityConfig) Validate() error {
+	if h.File == "" {
+		return errors.New("file must be set")
+	}
+
+	return nil
+}
+
+type AlertStateConfig struct {
+	Enabled    bool    `toml:"enabled" comment:"Default: true"`
+	File       string  `toml:"file" comment:"The open alerts are stored here to survive restarts"`
+	FlapWindow float64 `toml:"flap_window" comment:"An alert is resolved once its condition stays cleared for N seconds.\nConditions that come back earlier keep the alert open and are counted as flaps. Default: 300"`
+}
+
+func (a *AlertStateConfig) Validate() error {
+	if !a.Enabled {
+		return nil
+	}
+
+	if a.File == "" {
+		return errors.New("file must be set")
+	}
+
+	if a.FlapWindow < 0 {
+		return errors.New("flap_window must be positive")
+	}
+
+	return nil
+}
+
+// HubDestinationConfig is a [[hub_destinations]] entry
+type HubDestinationConfig struct {
+	Name     string   `toml:"name" comment:"Unique name of the destination, used in the logs and the self telemetry"`
+	URL      string   `toml:"url"`
+	URLs     []string `toml:"urls" comment:"Ordered list of URLs to fail over to, replacing url"`
+	User     string   `toml:"user"`
+	Password string   `toml:"password"`
+}
+
+var hubDestinationNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func (d *HubDestinationConfig) urls() []string {
+	if len(d.URLs) > 0 {
+		return d.URLs
+	}
+	if d.URL != "" {
+		return []string{d.URL}
+	}
+	return nil
+}
+
+func (d *HubDestinationConfig) Validate() error {
+	if !hubDestinationNameRegexp.MatchString(d.Name) {
+		return fmt.Errorf("name '%s' must consist of letters, digits, '_' and '-'", d.Name)
+	}
+
+	if len(d.urls()) == 0 {
+		return fmt.Errorf("%s: url or urls must be set", d.Name)
+	}
+	for _, u := range d.urls() {
+		if err := validateHTTPURL(u); err != nil {
+			return fmt.Errorf("%s: %s", d.Name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func validateHTTPURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("wrong scheme '%s' of %s, URL must start with http:// or https://", u.Scheme, value)
+	}
+
+	return nil
+}
+
+type EventsConfig struct {
+	Enabled  bool    `toml:"enabled" comment:"Default: false"`
+	URL      string  `toml:"url" comment:"Endpoint the events are posted to, required if enabled"`
+	User     string  `toml:"user" comment:"HTTP basic auth for url, e.g. hub_user and hub_password if the events are received by the Hub"`
+	Password string  `toml:"password"`
+	Debounce float64 `toml:"debounce" comment:"Send the same event at most once per N seconds. Default: 300"`
+}
+
+func (e *EventsConfig) Validate() error {
+	if e.Debounce < 0 {
+		return errors.New("debounce must be positive")
+	}
+
+	if e.Enabled && e.URL == "" {
+		return errors.New("url is required")
+	}
+
+	if e.URL != "" {
+		if u, err := url.Parse(e.URL); err != nil {
+			return fmt.Errorf("url: %s", err.Error())
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("url: wrong scheme '%s', URL must start with http:// or https://", u.Scheme)
+		}
+	}
+
+	return nil
+}
+
+type WebhookNotifierConfig struct {
+	Enabled       bool              `toml:"enabled" comment:"Default: false"`
+	URL           string            `toml:"url"`
+	Method        string            `toml:"method" comment:"Default: POST"`
+	Headers       map[string]string `toml:"headers" comment:"Additional HTTP headers, e.g. for authentication"`
+	BodyTemplate  string            `toml:"body_template" comment:"Go text/template of the request body. Fields: .Status (firing or resolved), .Severity, .Source, .Message, .Hostname, .Timestamp, .FiredAt\nUse {{json .Message}} to insert a JSON string. Default: {\"status\": {{json .Status}}, \"severity\": {{json .Severity}}, \"source\": {{json .Source}}, \"host\": {{json .Hostname}}, \"message\": {{json .Message}}, \"timestamp\": {{.Timestamp.Unix}}}"`
+	Timeout       float64           `toml:"timeout" comment:"Request timeout in seconds. Default: 10"`
+	Retries       int               `toml:"retries" comment:"Number of retries of a failed notification. Default: 3"`
+	RetryInterval float64           `toml:"retry_interval" comment:"Seconds between the retries. Default: 10"`
+}
+
+func (w *WebhookNotifierConfig) Validate() error {
+	if !w.Enabled {
+		return nil
+	}
+
+	if u, err := url.Parse(w.URL); err != nil {
+		return fmt.Errorf("url: %s", err.Error())
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("url: wrong scheme '%s', URL must start with http:// or https://", u.Scheme)
+	}
+
+	if _, err := notifier.ParseBodyTemplate(w.BodyTemplate); err != nil {
+		return fmt.Errorf("body_template: %s", err.Error())
+	}
+
+	if w.Timeout <= 0 {
+		return errors.New("timeout must be positive")
+	}
+
+	if w.Retries < 0 || w.RetryInterval < 0 {
+		return errors.New("retries and retry_interval must be positive")
+	}
+
+	return nil
+}
+
+type RemoteConfigConfig struct {
+	Enabled   bool   `toml:"enabled" comment:"Default: false"`
+	CacheFile string `toml:"cache_file" comment:"The last accepted overlay is stored here and applied on start"`
+}
+
+func (r *RemoteConfigConfig) Validate() error {
+	if r.Enabled && r.CacheFile == "" {
+		return errors.New("cache_file must be set")
+	}
+
+	return nil
+}
+
+func (s *StatusAPIConfig) Validate() error {
+	if s.Listen == "" {
+		return nil
+	}
+
+	network, address := statusAPIAddress(s.Listen)
+	if network == "unix" {
+		if !filepath.IsAbs(address) {
+			return errors.New("listen: path of the unix socket must be absolute")
+		}
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("listen has invalid value: %s", err.Error())
+	}
+
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return errors.New("listen: only loopback addresses are allowed")
+	}
+
+	return nil
+}
+
+type OutboxConfig struct {
+	Enabled     bool    `toml:"enabled" comment:"Set 'false' to drop undelivered measurements instead of storing them"`
+	DirPath     string  `toml:"dir" comment:"Path to the outbox dir"`
+	MaxEntries  int     `toml:"max_entries" comment:"Maximum number of stored results. The oldest results are dropped first. Default: 1000"`
+	MaxSizeMB   float64 `toml:"max_size_mb" comment:"Maximum disk space in megabytes the outbox may use. Default: 50.0"`
+	MaxAgeHours float64 `toml:"max_age_hours" comment:"Results older than N hours are dropped without sending. Default: 24.0"`
+}
+
+func (o *OutboxConfig) limits() outbox.Limits {
+	return outbox.Limits{
+		MaxEntries: o.MaxEntries,
+		MaxBytes:   int64(o.MaxSizeMB * 1024 * 1024),
+		MaxAge:     time.Duration(o.MaxAgeHours * float64(time.Hour)),
+	}
+}
+
+func (o *OutboxConfig) Validate() error {
+	if !o.Enabled {
+		return nil
+	}
+
+	if len(o.DirPath) == 0 {
+		return errors.New("dir is empty")
+	}
+
+	if !filepath.IsAbs(o.DirPath) {
+		return errors.New("dir path must be absolute")
+	}
+
+	if o.MaxEntries <= 0 {
+		return errors.New("max_entries must be greater than 0")
+	}
+
+	if o.MaxSizeMB <= 0 {
+		return errors.New("max_size_mb must be greater than 0")
+	}
+
+	if o.MaxAgeHours <= 0 {
+		return errors.New("max_age_hours must be greater than 0")
+	}
+
+	return nil
+}
+
 func (j *JobMonitoringConfig) Validate() error {
 	if len(j.SpoolDirPath) == 0 {
 		return errors.New("spool_dir is empty")
@@ -250,6 +745,12 @@ func NewConfig() *Config {
 		Interval:                         90,
 		Sleep:                            0,
 		HeartbeatInterval:                15,
+		RandomStartOffset:                true,
+		CollectorTimeout:                 30,
+		CollectorTimeouts:                map[string]float64{},
+		CollectorIntervals:               map[string]float64{},
+		CollectorCacheMode:               CollectorCacheModeResend,
+		ConfigWatchInterval:              10,
 		HubGzip:                          true,
 		HubRequestTimeout:                30,
 		CPULoadDataGather:                []string{"avg1"},
@@ -305,9 +806,89 @@ func NewConfig() *Config {
 		CPUMonitoring:    true,
 		FSMonitoring:     true,
 		NetMonitoring:    true,
+		SelfTelemetry:    true,
 
 		OnHTTP5xxRetries:       4,
 		OnHTTP5xxRetryInterval: 2.0,
+
+		Sinks: SinksConfig{
+			Hub: HubSinkConfig{
+				Enabled: true,
+				OnError: SinkOnErrorFail,
+			},
+			File: FileSinkConfig{
+				OnError:    SinkOnErrorLog,
+				MaxSizeMB:  100,
+				MaxBackups: 5,
+			},
+			Stdout: StdoutSinkConfig{
+				OnError: SinkOnErrorLog,
+			},
+			UnixSocket: UnixSocketSinkConfig{
+				OnError: SinkOnErrorLog,
+			},
+			InfluxDB: InfluxDBSinkConfig{
+				OnError: SinkOnErrorLog,
+				Timeout: 30,
+			},
+			Graphite: GraphiteSinkConfig{
+				OnError: SinkOnErrorLog,
+				Prefix:  "cagent",
+			},
+			OTLP: OTLPSinkConfig{
+				OnError:  SinkOnErrorLog,
+				Endpoint: "http://localhost:4318",
+				Timeout:  30,
+			},
+		},
+
+		PrometheusExporter: PrometheusExporterConfig{
+			Path: "/metrics",
+		},
+
+		Outbox: OutboxConfig{
+			Enabled:     true,
+			DirPath:     "/var/lib/cagent/outbox",
+			MaxEntries:  1000,
+			MaxSizeMB:   50,
+			MaxAgeHours: 24,
+		},
+
+		DeltaEncoding: DeltaEncodingConfig{
+			FullInterval: 3600,
+			Sections:     []string{"services.list", "listeningports.list", "proc.list", "docker.containers", "hw.inventory"},
+		},
+
+		HubActions: HubActionsConfig{
+			Enabled:     true,
+			MinInterval: 60,
+			MaxLogLines: 100,
+		},
+
+		HostIdentity: HostIdentityConfig{
+			File: "/var/lib/cagent/host_identity.json",
+		},
+
+		AlertState: AlertStateConfig{
+			Enabled:    true,
+			File:       "/var/lib/cagent/alert_state.json",
+			FlapWindow: 300,
+		},
+
+		Events: EventsConfig{
+			Debounce: 300,
+		},
+
+		WebhookNotifier: WebhookNotifierConfig{
+			Method:        "POST",
+			Timeout:       10,
+			Retries:       3,
+			RetryInterval: 10,
+		},
+
+		RemoteConfig: RemoteConfigConfig{
+			CacheFile: "/var/lib/cagent/remote_config.json",
+		},
 	}
 
 	cfg.MinValuableConfig = *(defaultMinValuableConfig())
@@ -319,10 +900,18 @@ func NewConfig() *Config {
 		cfg.CPUUtilTypes = []string{"user", "system", "idle"}
 		cfg.VirtualMachinesStat = []string{"hyper-v"}
 		cfg.JobMonitoring.SpoolDirPath = "C:\\ProgramData\\cagent\\jobmon"
+		cfg.Outbox.DirPath = "C:\\ProgramData\\cagent\\outbox"
+		cfg.RemoteConfig.CacheFile = "C:\\ProgramData\\cagent\\remote_config.json"
+		cfg.AlertState.File = "C:\\ProgramData\\cagent\\alert_state.json"
+		cfg.HostIdentity.File = "C:\\ProgramData\\cagent\\host_identity.json"
 		cfg.Updates.Enabled = true
 		cfg.Updates.URL = SelfUpdatesFeedURL
 	case "darwin":
 		cfg.JobMonitoring.SpoolDirPath = "/usr/local/var/lib/cagent/jobmon"
+		cfg.Outbox.DirPath = "/usr/local/var/lib/cagent/outbox"
+		cfg.RemoteConfig.CacheFile = "/usr/local/var/lib/cagent/remote_config.json"
+		cfg.AlertState.File = "/usr/local/var/lib/cagent/alert_state.json"
+		cfg.HostIdentity.File = "/usr/local/var/lib/cagent/host_identity.json"
 	default:
 		cfg.FSMetrics = append(cfg.FSMetrics, "inodes_used_percent")
 	}
@@ -370,10 +959,11 @@ func (mvc *MinValuableConfig) applyEnv(force bool) {
 	}
 }
 
+// DumpToml returns the config in TOML format. Secrets are masked, secrets set by a reference are shown as the reference
 func (cfg *Config) DumpToml() string {
 	buff := &bytes.Buffer{}
 
-	err := toml.NewEncoder(buff).Encode(cfg)
+	err := toml.NewEncoder(buff).Encode(cfg.masked())
 	if err != nil {
 		log.Errorf("DumpToml error: %s", err.Error())
 		return ""
@@ -383,19 +973,63 @@ func (cfg *Config) DumpToml() string {
 }
 
 // TryUpdateConfigFromFile applies values from file in configFilePath to cfg if given file exists.
-// it rewrites all cfg keys that present in the file
+// The *.toml files of the conf.d directory next to it are applied afterwards in lexical order,
+// each of them overrides the values set by the main file and the drop-ins before it.
+// Tables are merged key by key, arrays are replaced as a whole.
+// Secret references like hub_password = "file:/run/secrets/hub" or "env:HUB_PASSWORD" are resolved at the end
 func TryUpdateConfigFromFile(cfg *Config, configFilePath string) error {
 	_, err := os.Stat(configFilePath)
 	if err != nil {
 		return err
 	}
 
+	err = cfg.updateFromFile(configFilePath)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.updateFromDropIns(configFilePath)
+	if err != nil {
+		return err
+	}
+
+	return cfg.resolveSecrets()
+}
+
+// ConfigDropInFiles returns the *.toml files of the conf.d directory next to configFilePath in lexical order
+func ConfigDropInFiles(configFilePath string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(configFilePath), configDropInDir, "*.toml"))
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+func (cfg *Config) updateFromDropIns(configFilePath string) error {
+	files, err := ConfigDropInFiles(configFilePath)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err = cfg.updateFromFile(f); err != nil {
+			return fmt.Errorf("%s: %s", f, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (cfg *Config) updateFromFile(configFilePath string) error {
 	cfgFile, err := os.Open(configFilePath)
 	if err != nil {
 		return err
 	}
+	defer cfgFile.Close()
 
-	_, err = toml.DecodeReader(cfgFile, cfg)
+	meta, err := toml.DecodeReader(cfgFile, cfg)
 	if err != nil {
 		return err
 	}
@@ -406,16 +1040,79 @@ func TryUpdateConfigFromFile(cfg *Config, configFilePath string) error {
 	}
 
 	var deprecatedCfg ConfigDeprecated
-	meta, err := toml.DecodeReader(cfgFile, &deprecatedCfg)
+	deprecatedMeta, err := toml.DecodeReader(cfgFile, &deprecatedCfg)
 	if err != nil {
 		return err
 	}
 
-	cfg.migrate(&deprecatedCfg, meta)
+	cfg.migrate(&deprecatedCfg, deprecatedMeta)
+	cfg.setSources(configFilePath, meta)
+	cfg.warnAboutInlineSecrets(configFilePath, meta)
 
 	return nil
 }
 
+// setSources records configFilePath as the source of all values defined in it.
+// Arrays of tables are recorded by the name of the array as they are replaced as a whole
+func (cfg *Config) setSources(configFilePath string, meta toml.MetaData) {
+	if cfg.sources == nil {
+		cfg.sources = map[string]string{}
+	}
+	cfg.files = append(cfg.files, configFilePath)
+
+	arrays := map[string]bool{}
+	for _, key := range meta.Keys() {
+		switch meta.Type(key...) {
+		case "Hash":
+			continue
+		case "ArrayHash":
+			arrays[key.String()] = true
+		}
+
+		if isInsideArrayOfTables(key, arrays) {
+			continue
+		}
+		cfg.sources[key.String()] = configFilePath
+	}
+}
+
+func isInsideArrayOfTables(key toml.Key, arrays map[string]bool) bool {
+	for i := 1; i < len(key); i++ {
+		if arrays[key[:i].String()] {
+			return true
+		}
+	}
+	return false
+}
+
+// ValueSources returns the file every value set by the config files came from, keyed by the TOML key e.g. "sinks.file.path".
+// Values that are not listed have their defaults
+func (cfg *Config) ValueSources() map[string]string {
+	return cfg.sources
+}
+
+// DumpTomlWithSources returns the active config followed by comments listing the loaded files and the origin of each value
+func (cfg *Config) DumpTomlWithSources() string {
+	buff := bytes.NewBufferString(cfg.DumpToml())
+
+	buff.WriteString("\n# Loaded config files, later ones override earlier ones, CAGENT_* environment variables override all of them:\n")
+	for _, f := range cfg.files {
+		fmt.Fprintf(buff, "#   %s\n", f)
+	}
+
+	buff.WriteString("# Origin of the values, all other values have their defaults:\n")
+	keys := make([]string, 0, len(cfg.sources))
+	for k := range cfg.sources {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(buff, "#   %s = %s\n", k, cfg.sources[k])
+	}
+
+	return buff.String()
+}
+
 func SaveConfigFile(cfg interface{}, configFilePath string) error {
 	var f *os.File
 	var err error
@@ -479,6 +1176,27 @@ func GenerateDefaultConfigFile(mvc *MinValuableConfig, configFilePath string) er
 	return err
 }
 
+// hubURLs returns hub_urls or hub_url if hub_urls is not set
+func (cfg *Config) hubURLs() []string {
+	if len(cfg.HubURLs) > 0 {
+		return cfg.HubURLs
+	}
+	if cfg.HubURL != "" {
+		return []string{cfg.HubURL}
+	}
+	return nil
+}
+
+// HubTLS returns the TLS options of the Hub connection
+func (cfg *Config) HubTLS() common.HubTLSConfig {
+	return common.HubTLSConfig{
+		ClientCertFile: cfg.HubTLSClientCert,
+		ClientKeyFile:  cfg.HubTLSClientKey,
+		CAFile:         cfg.HubTLSCAFile,
+		PinnedSHA256:   cfg.HubTLSPinnedSHA256,
+	}
+}
+
 func (cfg *Config) GetParsedNetInterfaceMaxSpeed() (uint64, error) {
 	v := cfg.NetInterfaceMaxSpeed
 	if v == "" {
@@ -520,6 +1238,22 @@ func (cfg *Config) validate() error {
 		}
 	}
 
+	if err := cfg.HubTLS().Validate(); err != nil {
+		return err
+	}
+
+	for _, u := range cfg.HubURLs {
+		if err := validateHTTPURL(u); err != nil {
+			return fmt.Errorf("hub_urls: %s", err.Error())
+		}
+	}
+
+	if cfg.HubEnrollmentURL != "" {
+		if err := validateHTTPURL(cfg.HubEnrollmentURL); err != nil {
+			return fmt.Errorf("hub_enrollment_url: %s", err.Error())
+		}
+	}
+
 	if cfg.Interval < minIntervalValue {
 		return fmt.Errorf("interval value must be >= %.1f", minIntervalValue)
 	}
@@ -561,6 +1295,113 @@ func (cfg *Config) validate() error {
 		return fmt.Errorf("invalid [updates] config: %s", err.Error())
 	}
 
+	if cfg.ConfigWatchInterval < 0 {
+		return errors.New("config_watch_interval must be 0 or greater")
+	}
+
+	if cfg.CollectorTimeout <= 0 {
+		return errors.New("collector_timeout must be greater than 0")
+	}
+
+	for name, timeout := range cfg.CollectorTimeouts {
+		if !common.StrInSlice(name, collectorNames) {
+			return fmt.Errorf("invalid [collector_timeouts] config: unknown collector '%s'. Must be one of %v", name, collectorNames)
+		}
+
+		if timeout <= 0 {
+			return fmt.Errorf("invalid [collector_timeouts] config: %s must be greater than 0", name)
+		}
+	}
+
+	for name, interval := range cfg.CollectorIntervals {
+		if name == CollectorJobmon {
+			return errors.New("invalid [collector_intervals] config: jobmon reports each finished job only once and can't have an interval")
+		}
+
+		if !common.StrInSlice(name, collectorNames) {
+			return fmt.Errorf("invalid [collector_intervals] config: unknown collector '%s'. Must be one of %v", name, collectorNames)
+		}
+
+		if interval < 0 {
+			return fmt.Errorf("invalid [collector_intervals] config: %s must be 0 or greater", name)
+		}
+	}
+
+	if cfg.CollectorCacheMode != CollectorCacheModeResend && cfg.CollectorCacheMode != CollectorCacheModeOmit {
+		return fmt.Errorf("collector_cache_mode has invalid value '%s'. Must be one of %v", cfg.CollectorCacheMode, []string{CollectorCacheModeResend, CollectorCacheModeOmit})
+	}
+
+	err = cfg.Sinks.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid [sinks] config: %s", err.Error())
+	}
+
+	err = cfg.PrometheusExporter.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid [prometheus_exporter] config: %s", err.Error())
+	}
+
+	err = cfg.Outbox.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid [outbox] config: %s", err.Error())
+	}
+
+	err = cfg.StatusAPI.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid [status_api] config: %s", err.Error())
+	}
+
+	err = cfg.DeltaEncoding.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid [delta_encoding] config: %s", err.Error())
+	}
+
+	err = cfg.HubActions.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid [hub_actions] config: %s", err.Error())
+	}
+
+	err = cfg.RemoteConfig.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid [remote_config] config: %s", err.Error())
+	}
+
+	err = cfg.HostIdentity.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid [host_identity] config: %s", err.Error())
+	}
+
+	err = cfg.AlertState.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid [alert_state] config: %s", err.Error())
+	}
+
+	err = cfg.Events.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid [events] config: %s", err.Error())
+	}
+
+	err = cfg.WebhookNotifier.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid [webhook_notifier] config: %s", err.Error())
+	}
+
+	names := make(map[string]bool, len(cfg.HubDestinations))
+	for i := range cfg.HubDestinations {
+		if err = cfg.HubDestinations[i].Validate(); err != nil {
+			return fmt.Errorf("invalid [[hub_destinations]] config: %s", err.Error())
+		}
+		if names[cfg.HubDestinations[i].Name] {
+			return fmt.Errorf("invalid [[hub_destinations]] config: name %s is not unique", cfg.HubDestinations[i].Name)
+		}
+		names[cfg.HubDestinations[i].Name] = true
+	}
+
+	err = rules.ValidateAll(cfg.Rules)
+	if err != nil {
+		return fmt.Errorf("invalid [[rules]] config: %s", err.Error())
+	}
+
 	if cfg.OnHTTP5xxRetries < 0 || cfg.OnHTTP5xxRetries > 5 {
 		cfg.OnHTTP5xxRetries = 5
 		log.Warn("on_http_5xx_retries value out of range (0-5). was reset to 5")
@@ -577,6 +1418,19 @@ func (cfg *Config) validate() error {
 // HandleAllConfigSetup prepares Config for Cagent with parameters specified in file
 // if Config file does not exist default one is created in form of MinValuableConfig
 func HandleAllConfigSetup(configFilePath string) (*Config, error) {
+	cfg, err := loadConfig(configFilePath, nil)
+	if remoteErr, isRemote := err.(remoteConfigError); isRemote {
+		// the cached overlay may not fit the changed local config anymore
+		log.WithError(remoteErr.err).Error("the cached remote config is ignored")
+		cfg, err = loadConfig(configFilePath, &RemoteConfigOverlay{})
+	}
+
+	return cfg, err
+}
+
+// loadConfig applies the config files, the remote config overlay and the environment variables to the defaults.
+// If overlay is nil, the cached overlay is used. The overlay is ignored if [remote_config] is disabled
+func loadConfig(configFilePath string, overlay *RemoteConfigOverlay) (*Config, error) {
 	cfg := NewConfig()
 
 	err := TryUpdateConfigFromFile(cfg, configFilePath)
@@ -588,14 +1442,33 @@ func HandleAllConfigSetup(configFilePath string) (*Config, error) {
 		}
 
 		cfg.MinValuableConfig = *mvc
-	} else if err != nil {
+		err = cfg.updateFromDropIns(configFilePath)
+		if err == nil {
+			err = cfg.resolveSecrets()
+		}
+	}
+
+	if err != nil {
 		if strings.Contains(err.Error(), "cannot load TOML value of type int64 into a Go float") {
 			return nil, fmt.Errorf("Config load error: please use numbers with a decimal point for numerical values")
 		}
 		return nil, fmt.Errorf("Config load error: %s", err.Error())
 	}
 
+	if cfg.RemoteConfig.Enabled {
+		if err = cfg.applyRemoteConfig(overlay); err != nil {
+			return nil, remoteConfigError{err}
+		}
+	}
+
+	if err = cfg.applyEnvOverrides(); err != nil {
+		return nil, fmt.Errorf("Config load error: %s", err.Error())
+	}
+
 	if err = cfg.validate(); err != nil {
+		if cfg.remoteConfigVersion != "" {
+			return nil, remoteConfigError{err}
+		}
 		return nil, err
 	}
 	return cfg, nil
@@ -0,0 +1,204 @@
+package cagent
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	envOverridePrefix = "CAGENT_"
+	// envSectionSeparator separates the names of nested sections, e.g. CAGENT_MYSQL_MONITORING__PASSWORD
+	envSectionSeparator = "__"
+)
+
+// envField is a config value that can be overridden by an environment variable
+type envField struct {
+	key   string
+	value reflect.Value
+	// isMap is set for maps, the rest of the variable name after the map name is used as the map key
+	isMap bool
+}
+
+// applyEnvOverrides sets config values from CAGENT_* environment variables.
+// The name of the variable is the upper-cased TOML key, the keys of nested sections are joined by "__":
+//
+//	CAGENT_FS_TYPE_INCLUDE=ext4,xfs sets fs_type_include
+//	CAGENT_MYSQL_MONITORING__PASSWORD=secret sets password in [mysql_monitoring]
+//	CAGENT_COLLECTOR_INTERVALS__SERVICES=600 sets services in [collector_intervals]
+//
+// Lists are comma-separated, secrets may be set by a reference like in the config file. Environment variables that don't match any config key are ignored
+func (cfg *Config) applyEnvOverrides() error {
+	fields := map[string]envField{}
+	collectEnvFields(reflect.ValueOf(cfg).Elem(), nil, fields)
+
+	// sort to apply the variables and report the errors in a stable order
+	var names []string
+	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, envOverridePrefix) {
+			names = append(names, strings.SplitN(env, "=", 2)[0])
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key, field, found := lookupEnvField(fields, name)
+		if !found {
+			continue
+		}
+
+		if err := setEnvValue(field, key, os.Getenv(name)); err != nil {
+			return fmt.Errorf("environment variable %s: %s", name, err.Error())
+		}
+
+		// the value may be a secret reference itself, e.g. CAGENT_HUB_PASSWORD=file:/run/secrets/hub
+		if err := cfg.resolveSecretByKey(key); err != nil {
+			return fmt.Errorf("environment variable %s: %s", name, err.Error())
+		}
+
+		if cfg.sources == nil {
+			cfg.sources = map[string]string{}
+		}
+		cfg.sources[key] = "env " + name
+	}
+
+	return nil
+}
+
+func lookupEnvField(fields map[string]envField, name string) (string, envField, bool) {
+	if field, exists := fields[name]; exists && !field.isMap {
+		return field.key, field, true
+	}
+
+	// CAGENT_COLLECTOR_INTERVALS__SERVICES addresses the key "services" of the map CAGENT_COLLECTOR_INTERVALS
+	if i := strings.LastIndex(name, envSectionSeparator); i > 0 {
+		if field, exists := fields[name[:i]]; exists && field.isMap {
+			mapKey := strings.ToLower(name[i+len(envSectionSeparator):])
+			return field.key + "." + mapKey, field, mapKey != ""
+		}
+	}
+
+	return "", envField{}, false
+}
+
+func collectEnvFields(v reflect.Value, keys []string, fields map[string]envField) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			collectEnvFields(v.Field(i), keys, fields)
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("toml"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		fieldKeys := append(append([]string{}, keys...), name)
+		fieldValue := v.Field(i)
+		switch f.Type.Kind() {
+		case reflect.Struct:
+			collectEnvFields(fieldValue, fieldKeys, fields)
+		case reflect.Map:
+			if f.Type.Key().Kind() == reflect.String && isEnvScalar(f.Type.Elem()) {
+				fields[envName(fieldKeys)] = envField{key: strings.Join(fieldKeys, "."), value: fieldValue, isMap: true}
+			}
+		case reflect.Slice:
+			if isEnvScalar(f.Type.Elem()) {
+				fields[envName(fieldKeys)] = envField{key: strings.Join(fieldKeys, "."), value: fieldValue}
+			}
+		default:
+			if isEnvScalar(f.Type) {
+				fields[envName(fieldKeys)] = envField{key: strings.Join(fieldKeys, "."), value: fieldValue}
+			}
+		}
+	}
+}
+
+func envName(keys []string) string {
+	return envOverridePrefix + strings.ToUpper(strings.Join(keys, envSectionSeparator))
+}
+
+func isEnvScalar(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func setEnvValue(field envField, key, raw string) error {
+	switch {
+	case field.isMap:
+		elem := reflect.New(field.value.Type().Elem()).Elem()
+		if err := parseEnvScalar(elem, raw); err != nil {
+			return err
+		}
+		if field.value.IsNil() {
+			field.value.Set(reflect.MakeMap(field.value.Type()))
+		}
+		mapKey := key[strings.LastIndex(key, ".")+1:]
+		field.value.SetMapIndex(reflect.ValueOf(mapKey).Convert(field.value.Type().Key()), elem)
+	case field.value.Kind() == reflect.Slice:
+		list := reflect.MakeSlice(field.value.Type(), 0, 0)
+		if strings.TrimSpace(raw) != "" {
+			for _, item := range strings.Split(raw, ",") {
+				elem := reflect.New(field.value.Type().Elem()).Elem()
+				if err := parseEnvScalar(elem, strings.TrimSpace(item)); err != nil {
+					return err
+				}
+				list = reflect.Append(list, elem)
+			}
+		}
+		field.value.Set(list)
+	default:
+		return parseEnvScalar(field.value, raw)
+	}
+
+	return nil
+}
+
+func parseEnvScalar(v reflect.Value, raw string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a boolean", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("'%s' is not an integer", raw)
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("'%s' is not a positive integer", raw)
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("'%s' is not a number", raw)
+		}
+		v.SetFloat(f)
+	}
+
+	return nil
+}
@@ -0,0 +1,222 @@
+package cagent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/troian/toml"
+)
+
+const (
+	secretRefFilePrefix = "file:"
+	secretRefEnvPrefix  = "env:"
+
+	maskedSecret = "********"
+)
+
+// secretField is a config value holding a password or a token.
+// Such values may contain a reference like "file:/run/secrets/hub" or "env:HUB_PASSWORD" instead of the secret itself
+type secretField struct {
+	key string
+	get func() string
+	set func(string)
+}
+
+func (cfg *Config) secretFields() []secretField {
+	fields := []secretField{
+		stringSecretField("hub_password", &cfg.HubPassword),
+		stringSecretField("hub_proxy_password", &cfg.HubProxyPassword),
+		stringSecretField("mysql_monitoring.password", &cfg.MysqlMonitoring.Password),
+		stringSecretField("sinks.influxdb.token", &cfg.Sinks.InfluxDB.Token),
+		stringSecretField("sinks.influxdb.password", &cfg.Sinks.InfluxDB.Password),
+		stringSecretField("events.password", &cfg.Events.Password),
+	}
+
+	for i := range cfg.HubDestinations {
+		d := &cfg.HubDestinations[i]
+		fields = append(fields, stringSecretField("hub_destinations."+d.Name+".password", &d.Password))
+	}
+
+	// headers usually carry API keys
+	fields = append(fields, headerSecretFields("sinks.otlp.headers", cfg.Sinks.OTLP.Headers)...)
+	fields = append(fields, headerSecretFields("webhook_notifier.headers", cfg.WebhookNotifier.Headers)...)
+
+	return fields
+}
+
+func headerSecretFields(key string, headers map[string]string) []secretField {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make([]secretField, 0, len(names))
+	for _, name := range names {
+		name := name
+		fields = append(fields, secretField{
+			key: key + "." + name,
+			get: func() string { return headers[name] },
+			set: func(v string) { headers[name] = v },
+		})
+	}
+
+	return fields
+}
+
+func stringSecretField(key string, value *string) secretField {
+	return secretField{
+		key: key,
+		get: func() string { return *value },
+		set: func(v string) { *value = v },
+	}
+}
+
+func isSecretRef(value string) bool {
+	return strings.HasPrefix(value, secretRefFilePrefix) || strings.HasPrefix(value, secretRefEnvPrefix)
+}
+
+// resolveSecretRef returns the secret the reference points to or the value itself if it's not a reference.
+// The trailing newline of a secret file is removed
+func resolveSecretRef(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretRefFilePrefix):
+		path := strings.TrimPrefix(value, secretRefFilePrefix)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the secret file: %s", err.Error())
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(value, secretRefEnvPrefix):
+		name := strings.TrimPrefix(value, secretRefEnvPrefix)
+		secret, exists := os.LookupEnv(name)
+		if !exists {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return secret, nil
+	}
+
+	return value, nil
+}
+
+// resolveSecrets replaces the secret references of the config with the secrets.
+// The references are kept to show them instead of the secrets in DumpToml
+func (cfg *Config) resolveSecrets() error {
+	for _, f := range cfg.secretFields() {
+		if err := cfg.resolveSecret(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (cfg *Config) resolveSecret(f secretField) error {
+	ref := f.get()
+	if !isSecretRef(ref) {
+		return nil
+	}
+
+	secret, err := resolveSecretRef(ref)
+	if err != nil {
+		return fmt.Errorf("%s: %s", f.key, err.Error())
+	}
+
+	if cfg.secretRefs == nil {
+		cfg.secretRefs = map[string]string{}
+	}
+	cfg.secretRefs[f.key] = ref
+	f.set(secret)
+
+	return nil
+}
+
+// resolveSecretByKey resolves the secret reference if key belongs to a secret field
+func (cfg *Config) resolveSecretByKey(key string) error {
+	for _, f := range cfg.secretFields() {
+		if f.key == key {
+			delete(cfg.secretRefs, key)
+			return cfg.resolveSecret(f)
+		}
+	}
+
+	return nil
+}
+
+// masked returns a copy of the config with the secrets replaced by their references or a mask
+func (cfg *Config) masked() *Config {
+	masked := *cfg
+	masked.Sinks.OTLP.Headers = copyHeaders(cfg.Sinks.OTLP.Headers)
+	masked.WebhookNotifier.Headers = copyHeaders(cfg.WebhookNotifier.Headers)
+	masked.HubDestinations = append([]HubDestinationConfig(nil), cfg.HubDestinations...)
+
+	for _, f := range masked.secretFields() {
+		if ref, isRef := cfg.secretRefs[f.key]; isRef {
+			f.set(ref)
+		} else if f.get() != "" {
+			f.set(maskedSecret)
+		}
+	}
+
+	return &masked
+}
+
+func copyHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return headers
+	}
+
+	copied := make(map[string]string, len(headers))
+	for name, value := range headers {
+		copied[name] = value
+	}
+	return copied
+}
+
+// warnAboutInlineSecrets logs a warning if the config file contains secrets in plain text
+// and can be read by other users than the owner
+func (cfg *Config) warnAboutInlineSecrets(configFilePath string, meta toml.MetaData) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	info, err := os.Stat(configFilePath)
+	if err != nil || info.Mode().Perm()&0077 == 0 {
+		return
+	}
+
+	if inline := cfg.inlineSecrets(meta); len(inline) > 0 {
+		log.Warnf(
+			"%s contains secrets in plain text (%s) and is accessible by other users (mode %s). "+
+				"Restrict the permissions with chmod 600 or use references like \"file:/path\" or \"env:VAR\"",
+			configFilePath, strings.Join(inline, ", "), info.Mode().Perm(),
+		)
+	}
+}
+
+// inlineSecrets returns the keys of the secrets the file defines in plain text
+func (cfg *Config) inlineSecrets(meta toml.MetaData) []string {
+	var inline []string
+	for _, f := range cfg.secretFields() {
+		if secretDefinedIn(meta, f.key) && f.get() != "" && !isSecretRef(f.get()) {
+			inline = append(inline, f.key)
+		}
+	}
+
+	return inline
+}
+
+// secretDefinedIn checks if the file defines the secret. The entries of [[hub_destinations]] can't be looked up by name,
+// the array is replaced as a whole, so all entries come from the file defining it
+func secretDefinedIn(meta toml.MetaData, key string) bool {
+	if strings.HasPrefix(key, "hub_destinations.") {
+		return meta.IsDefined("hub_destinations")
+	}
+
+	return meta.IsDefined(strings.Split(key, ".")...)
+}
@@ -3,6 +3,7 @@ package cagent
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"testing"
 
@@ -267,3 +268,259 @@ func TestGetParsedNetworkInterfaceMaxSpeed(t *testing.T) {
 		assert.Equal(t, expected.val, val, "for input %s: %d vs %d", strVal, expected.val, val)
 	}
 }
+
+func TestReloadConfig(t *testing.T) {
+	tmpFile, err := ioutil.TempFile("", "")
+	assert.Nil(t, err)
+	defer os.Remove(tmpFile.Name())
+
+	err = ioutil.WriteFile(tmpFile.Name(), []byte("interval = 60.0\nfs_metrics = ['free_B']\n"), 0600)
+	assert.Nil(t, err)
+
+	cfg, err := HandleAllConfigSetup(tmpFile.Name())
+	assert.Nil(t, err)
+	cfg.HostIdentity.File = tmpFile.Name() + ".host_identity.json"
+	defer os.Remove(cfg.HostIdentity.File)
+	ca, err := New(cfg, tmpFile.Name())
+	assert.Nil(t, err)
+	defer ca.Shutdown()
+
+	fsWatcher := ca.GetFileSystemWatcher()
+	netWatcher := ca.GetNetworkWatcher()
+
+	err = ioutil.WriteFile(tmpFile.Name(), []byte("interval = 30.0\nfs_metrics = ['free_B', 'total_B']\n"), 0600)
+	assert.Nil(t, err)
+
+	err = ca.ReloadConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, 30.0, ca.Config.Interval)
+	assert.Equal(t, []string{"free_B", "total_B"}, ca.Config.FSMetrics)
+	assert.True(t, fsWatcher != ca.GetFileSystemWatcher(), "fs watcher must be rebuilt")
+	assert.True(t, netWatcher == ca.GetNetworkWatcher(), "net watcher must keep its state")
+
+	err = ioutil.WriteFile(tmpFile.Name(), []byte("interval = 15.0\nio_mode = \"invalid\"\n"), 0600)
+	assert.Nil(t, err)
+
+	err = ca.ReloadConfig()
+	assert.NotNil(t, err)
+	assert.Equal(t, 30.0, ca.Config.Interval, "invalid config must not be applied")
+}
+
+func TestTryUpdateConfigFromFileWithDropIns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	mainFile := filepath.Join(dir, "cagent.conf")
+	dropInDir := filepath.Join(dir, "conf.d")
+	assert.Nil(t, os.Mkdir(dropInDir, 0700))
+
+	files := map[string]string{
+		mainFile: `
+interval = 60.0
+hub_url = "https://hub.example.com"
+fs_metrics = ['free_B', 'total_B']
+
+[mysql_monitoring]
+  enabled = false
+  connect = "tcp://localhost:3306"
+`,
+		filepath.Join(dropInDir, "20-mysql.toml"): `
+[mysql_monitoring]
+  enabled = true
+`,
+		filepath.Join(dropInDir, "10-hub.toml"): `
+hub_url = "https://other.example.com"
+fs_metrics = ['free_percent']
+`,
+		filepath.Join(dropInDir, "30-hub.toml"): `
+hub_url = "https://last.example.com"
+`,
+		filepath.Join(dropInDir, "ignored.conf"): `
+interval = 1.0
+`,
+	}
+	for path, content := range files {
+		assert.Nil(t, ioutil.WriteFile(path, []byte(content), 0600))
+	}
+
+	cfg := NewConfig()
+	err = TryUpdateConfigFromFile(cfg, mainFile)
+	assert.Nil(t, err)
+
+	assert.Equal(t, 60.0, cfg.Interval)
+	assert.Equal(t, "https://last.example.com", cfg.HubURL)
+	assert.Equal(t, []string{"free_percent"}, cfg.FSMetrics)
+	assert.True(t, cfg.MysqlMonitoring.Enabled)
+	assert.Equal(t, "tcp://localhost:3306", cfg.MysqlMonitoring.Connect)
+
+	sources := cfg.ValueSources()
+	assert.Equal(t, mainFile, sources["interval"])
+	assert.Equal(t, filepath.Join(dropInDir, "30-hub.toml"), sources["hub_url"])
+	assert.Equal(t, filepath.Join(dropInDir, "10-hub.toml"), sources["fs_metrics"])
+	assert.Equal(t, filepath.Join(dropInDir, "20-mysql.toml"), sources["mysql_monitoring.enabled"])
+	assert.Equal(t, mainFile, sources["mysql_monitoring.connect"])
+	assert.NotContains(t, sources, "mysql_monitoring")
+
+	dump := cfg.DumpTomlWithSources()
+	assert.Contains(t, dump, "#   hub_url = "+filepath.Join(dropInDir, "30-hub.toml"))
+
+	// the output of -p must stay a valid config
+	_, err = toml.Decode(dump, NewConfig())
+	assert.Nil(t, err)
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	env := map[string]string{
+		"CAGENT_HUB_URL":                               "https://env.example.com",
+		"CAGENT_INTERVAL":                              "30",
+		"CAGENT_FS_TYPE_INCLUDE":                       "ext4, xfs",
+		"CAGENT_NET_INTERFACE_EXCLUDE_LOOPBACK":        "false",
+		"CAGENT_MYSQL_MONITORING__PASSWORD":            "secret",
+		"CAGENT_SYSTEM_UPDATES_CHECKS__CHECK_INTERVAL": "600",
+		"CAGENT_COLLECTOR_INTERVALS__SERVICES":         "600",
+		"CAGENT_FORK":                                  "1",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	cfg := NewConfig()
+	err := cfg.applyEnvOverrides()
+	assert.Nil(t, err)
+
+	assert.Equal(t, "https://env.example.com", cfg.HubURL)
+	assert.Equal(t, 30.0, cfg.Interval)
+	assert.Equal(t, []string{"ext4", "xfs"}, cfg.FSTypeInclude)
+	assert.False(t, cfg.NetInterfaceExcludeLoopback)
+	assert.Equal(t, "secret", cfg.MysqlMonitoring.Password)
+	assert.Equal(t, uint32(600), cfg.SystemUpdatesChecks.CheckInterval)
+	assert.Equal(t, 600.0, cfg.CollectorIntervals["services"])
+
+	sources := cfg.ValueSources()
+	assert.Equal(t, "env CAGENT_HUB_URL", sources["hub_url"])
+	assert.Equal(t, "env CAGENT_MYSQL_MONITORING__PASSWORD", sources["mysql_monitoring.password"])
+	assert.Equal(t, "env CAGENT_COLLECTOR_INTERVALS__SERVICES", sources["collector_intervals.services"])
+
+	os.Setenv("CAGENT_INTERVAL", "often")
+	err = NewConfig().applyEnvOverrides()
+	assert.EqualError(t, err, "environment variable CAGENT_INTERVAL: 'often' is not a number")
+}
+
+func TestSecretReferences(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	secretFile := filepath.Join(dir, "hub_password")
+	assert.Nil(t, ioutil.WriteFile(secretFile, []byte("hub-secret\n"), 0600))
+
+	os.Setenv("TEST_MYSQL_PASSWORD", "mysql-secret")
+	defer os.Unsetenv("TEST_MYSQL_PASSWORD")
+
+	configFile := filepath.Join(dir, "cagent.conf")
+	assert.Nil(t, ioutil.WriteFile(configFile, []byte(`
+hub_password = "file:`+secretFile+`"
+hub_proxy_password = "inline-secret"
+
+[mysql_monitoring]
+  password = "env:TEST_MYSQL_PASSWORD"
+`), 0600))
+
+	cfg := NewConfig()
+	err = TryUpdateConfigFromFile(cfg, configFile)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "hub-secret", cfg.HubPassword)
+	assert.Equal(t, "inline-secret", cfg.HubProxyPassword)
+	assert.Equal(t, "mysql-secret", cfg.MysqlMonitoring.Password)
+
+	dump := cfg.DumpToml()
+	assert.NotContains(t, dump, "secret\"")
+	assert.Contains(t, dump, `hub_password = "file:`+secretFile+`"`)
+	assert.Contains(t, dump, `hub_proxy_password = "`+maskedSecret+`"`)
+	assert.Contains(t, dump, `password = "env:TEST_MYSQL_PASSWORD"`)
+	// masking must not change the active config
+	assert.Equal(t, "inline-secret", cfg.HubProxyPassword)
+
+	assert.Nil(t, ioutil.WriteFile(configFile, []byte(`hub_password = "env:TEST_MISSING_PASSWORD"`), 0600))
+	err = TryUpdateConfigFromFile(NewConfig(), configFile)
+	assert.EqualError(t, err, "hub_password: environment variable TEST_MISSING_PASSWORD is not set")
+}
+
+func TestInlineSecrets(t *testing.T) {
+	cfg := NewConfig()
+	meta, err := toml.Decode(`
+hub_password = "inline-secret"
+hub_proxy_password = "env:TEST_PROXY_PASSWORD"
+
+[[hub_destinations]]
+  name = "backup"
+  url = "https://backup.example.com"
+  password = "inline-secret"
+
+[[hub_destinations]]
+  name = "dr"
+  url = "https://dr.example.com"
+  password = "file:/run/secrets/dr"
+`, cfg)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"hub_password", "hub_destinations.backup.password"}, cfg.inlineSecrets(meta))
+}
+
+func TestRemoteConfigOverlay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cacheFile := filepath.Join(dir, "remote_config.json")
+	configFile := filepath.Join(dir, "cagent.conf")
+	assert.Nil(t, ioutil.WriteFile(configFile, []byte(`
+pid = "/run/cagent.pid"
+fs_path_exclude = ['/local']
+
+[remote_config]
+  enabled = true
+  cache_file = "`+cacheFile+`"
+`), 0600))
+
+	overlay := &RemoteConfigOverlay{Version: "2", TOML: `
+fs_path_exclude = ['/mnt']
+
+[docker_monitoring]
+  enabled = false
+`}
+	cfg, err := loadConfig(configFile, overlay)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/mnt"}, cfg.FSPathExclude)
+	assert.False(t, cfg.DockerMonitoring.Enabled)
+	assert.Equal(t, "2", cfg.RemoteConfigVersion())
+	assert.Equal(t, "remote config version 2", cfg.ValueSources()["fs_path_exclude"])
+
+	for _, denied := range []string{
+		`pid = "/tmp/cagent.pid"`,
+		`hub_password = "x"`,
+		"[mysql_monitoring]\npassword = \"x\"",
+		"[remote_config]\nenabled = false",
+	} {
+		_, err = loadConfig(configFile, &RemoteConfigOverlay{Version: "3", TOML: denied})
+		assert.IsType(t, remoteConfigError{}, err, denied)
+	}
+
+	_, err = loadConfig(configFile, &RemoteConfigOverlay{Version: "4", TOML: `collector_cache_mode = "never"`})
+	assert.IsType(t, remoteConfigError{}, err)
+
+	// the cached overlay is applied on start and ignored if the config is invalid with it
+	assert.Nil(t, writeRemoteConfigCache(cacheFile, overlay))
+	cfg, err = HandleAllConfigSetup(configFile)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/mnt"}, cfg.FSPathExclude)
+
+	assert.Nil(t, writeRemoteConfigCache(cacheFile, &RemoteConfigOverlay{Version: "4", TOML: `collector_cache_mode = "never"`}))
+	cfg, err = HandleAllConfigSetup(configFile)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/local"}, cfg.FSPathExclude)
+	assert.Equal(t, "", cfg.RemoteConfigVersion())
+}
@@ -60,6 +60,8 @@ type CPUWatcher struct {
 	UtilTypes []string
 
 	ThresholdNotifiers []thresholdNotifier
+
+	interrupt chan struct{}
 }
 
 var utilisationMetricsByOSMap = make(map[string]map[string]struct{})
@@ -188,7 +190,7 @@ func (ca *Cagent) CPUWatcher() *CPUWatcher {
 		return ca.cpuWatcher
 	}
 
-	cw := CPUWatcher{}
+	cw := CPUWatcher{interrupt: make(chan struct{})}
 	cw.UtilAvg.mu.Lock()
 
 	if len(ca.Config.CPULoadDataGather) > 0 {
@@ -338,12 +340,24 @@ func (cw *CPUWatcher) Run() {
 		spent := time.Since(start)
 
 		// Sleep if we spent less than measureInterval on measurement
+		var sleep time.Duration
 		if spent < measureInterval {
-			time.Sleep(measureInterval - spent)
+			sleep = measureInterval - spent
+		}
+
+		select {
+		case <-cw.interrupt:
+			return
+		case <-time.After(sleep):
 		}
 	}
 }
 
+// Shutdown stops the continuous measurement started by Run
+func (cw *CPUWatcher) Shutdown() {
+	close(cw.interrupt)
+}
+
 func (cw *CPUWatcher) Results() (common.MeasurementsMap, error) {
 	var errs []string
 	util, err := cw.UtilAvg.Percentage()
@@ -1,14 +1,13 @@
 package cagent
 
 import (
-	"os"
-	"os/signal"
-	"syscall"
+	"fmt"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/events"
 	"github.com/securez-one/cagent/pkg/monitoring/top"
 )
 
@@ -18,6 +17,8 @@ type CPUUtilisationAnalyser struct {
 	top                 *top.Top
 	topIsRunning        bool
 	hasUnclaimedResults bool
+
+	interrupt chan struct{}
 }
 
 func (ca *Cagent) CPUUtilisationAnalyser() *CPUUtilisationAnalyser {
@@ -30,13 +31,7 @@ func (ca *Cagent) CPUUtilisationAnalyser() *CPUUtilisationAnalyser {
 		return &CPUUtilisationAnalyser{}
 	}
 
-	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc,
-		syscall.SIGHUP,
-		syscall.SIGINT,
-		syscall.SIGTERM)
-
-	cuan := CPUUtilisationAnalyser{NumberOfProcesses: cfg.ReportProcesses}
+	cuan := CPUUtilisationAnalyser{NumberOfProcesses: cfg.ReportProcesses, interrupt: make(chan struct{})}
 	ca.cpuUtilisationAnalyser = &cuan
 	cuan.top = top.New()
 
@@ -52,6 +47,13 @@ func (ca *Cagent) CPUUtilisationAnalyser() *CPUUtilisationAnalyser {
 			select {
 			case x := <-thresholdChan:
 				log.Debugf("[CPU_ANALYSIS] CPU threshold signal(%.2f) received from chan", x)
+				events.Emit(events.Event{
+					Source:   "cpu",
+					Key:      cfg.Metric + "." + cfg.GatheringMode,
+					Severity: events.SeverityWarning,
+					Message:  fmt.Sprintf("CPU utilisation %s %s is %.2f%%, threshold %s %.2f%%", cfg.Metric, cfg.GatheringMode, x, cfg.Function, cfg.Threshold),
+					Value:    x,
+				})
 				if !cuan.topIsRunning {
 					go cuan.top.Run()
 					cuan.topIsRunning = true
@@ -65,8 +67,12 @@ func (ca *Cagent) CPUUtilisationAnalyser() *CPUUtilisationAnalyser {
 					cuan.top.Stop()
 				}
 				break
-			case <-sigc:
+			case <-cuan.interrupt:
 				log.Debugf("[CPU_ANALYSIS] got interrupt signal")
+				if cuan.topIsRunning {
+					cuan.top.Stop()
+					cuan.topIsRunning = false
+				}
 				return
 			}
 		}
@@ -85,3 +91,10 @@ func (cuan *CPUUtilisationAnalyser) Results() (common.MeasurementsMap, bool, err
 
 	return common.MeasurementsMap{"top": topProcs}, true, nil
 }
+
+// Shutdown stops the analysis, the CPU watcher must be stopped first as it feeds the analyser
+func (cuan *CPUUtilisationAnalyser) Shutdown() {
+	if cuan.interrupt != nil {
+		close(cuan.interrupt)
+	}
+}
@@ -0,0 +1,43 @@
+package cagent
+
+import (
+	"net/http"
+
+	"github.com/securez-one/cagent/pkg/delta"
+	"github.com/securez-one/cagent/pkg/outbox"
+)
+
+// delivery holds the config and the parts of the agent rebuilt by a config reload that the results and the heartbeats are delivered with.
+// It's taken under reloadLock and used without holding it, so a reload doesn't wait for the requests to the Hub and their retries.
+// The parts replaced by a reload in the meantime are used until the delivery is finished
+type delivery struct {
+	cfg          *Config
+	client       *http.Client
+	hub          *hubDestination
+	sinks        []configuredSink
+	outbox       *outbox.Outbox
+	deltaEncoder *delta.Encoder
+	forwarders   []*hubForwarder
+}
+
+// currentDelivery returns the delivery of the active config, the caller holds reloadLock
+func (ca *Cagent) currentDelivery() *delivery {
+	ca.initHubClientOnce()
+	return &delivery{
+		cfg:          ca.Config,
+		client:       ca.hubClient,
+		hub:          ca.hub(),
+		sinks:        ca.sinks,
+		outbox:       ca.outbox,
+		deltaEncoder: ca.deltaEncoder,
+		forwarders:   ca.hubForwarders,
+	}
+}
+
+// deliverySnapshot takes the delivery of the active config. It must not be called with reloadLock held
+func (ca *Cagent) deliverySnapshot() *delivery {
+	ca.reloadLock.RLock()
+	defer ca.reloadLock.RUnlock()
+
+	return ca.currentDelivery()
+}
@@ -0,0 +1,229 @@
+package cagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/troian/toml"
+
+	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/hwinfo"
+)
+
+// enrollmentDropIn holds the credentials received from the Hub, it's written to conf.d and readable by the owner only
+const enrollmentDropIn = "enrollment.toml"
+
+var enrollmentDropInHeadline = []byte("# Written by cagent -enroll. The credentials are replaced when the Hub rotates them, don't edit this file\n")
+
+// enrollmentRequest is sent to the Hub to exchange a one-time token for the credentials of the host.
+// A credential rotation sends it without the token, authenticated with the current credentials
+type enrollmentRequest struct {
+	Token        string                 `json:"token,omitempty"`
+	Rotate       bool                   `json:"rotate,omitempty"`
+	AgentVersion string                 `json:"agent_version"`
+	HostUUID     string                 `json:"host_uuid,omitempty"`
+	HostInfo     common.MeasurementsMap `json:"host_info"`
+	HWInventory  map[string]interface{} `json:"hw_inventory,omitempty"`
+}
+
+type enrollmentResponse struct {
+	// HubURL is where the host reports to, the enrollment URL if empty
+	HubURL      string `json:"hub_url"`
+	HubUser     string `json:"hub_user"`
+	HubPassword string `json:"hub_password"`
+}
+
+// enrollmentCredentials is the content of the enrollment drop-in
+type enrollmentCredentials struct {
+	HubUser          string `toml:"hub_user"`
+	HubPassword      string `toml:"hub_password"`
+	HubEnrollmentURL string `toml:"hub_enrollment_url"`
+}
+
+// Enroll exchanges the one-time enrollment token for the credentials of this host and creates the config at configFilePath.
+// The credentials are stored in conf.d/enrollment.toml next to it, which only the owner can read
+func Enroll(configFilePath, enrollmentURL, token string) error {
+	return enroll(NewConfig(), configFilePath, enrollmentURL, token)
+}
+
+// enroll uses cfg for the enrollment request, the host identity is kept in the file of cfg
+func enroll(cfg *Config, configFilePath, enrollmentURL, token string) error {
+	if err := validateHTTPURL(enrollmentURL); err != nil {
+		return fmt.Errorf("-hub: %s", err.Error())
+	}
+	if token == "" {
+		return fmt.Errorf("-enroll: the enrollment token is empty")
+	}
+	if _, err := os.Stat(configFilePath); err == nil {
+		return fmt.Errorf("config file already exists at path: %s", configFilePath)
+	}
+
+	cfg.HubURL = enrollmentURL
+	ca := &Cagent{Config: cfg, ConfigLocation: configFilePath}
+	// the Hub gets the same host UUID with the enrollment and the measurements later
+	ca.initHostIdentity()
+
+	resp, err := ca.requestCredentials(enrollmentURL, "", "", enrollmentRequest{Token: token})
+	if err != nil {
+		return err
+	}
+
+	err = writeEnrollmentDropIn(configFilePath, enrollmentCredentials{
+		HubUser:          resp.HubUser,
+		HubPassword:      resp.HubPassword,
+		HubEnrollmentURL: enrollmentURL,
+	})
+	if err != nil {
+		return err
+	}
+
+	mvc := defaultMinValuableConfig()
+	mvc.IOMode = IOModeHTTP
+	mvc.HubURL = resp.HubURL
+	if mvc.HubURL == "" {
+		mvc.HubURL = enrollmentURL
+	}
+
+	return GenerateDefaultConfigFile(mvc, configFilePath)
+}
+
+// requestCredentials posts the host info and the hardware inventory to the enrollment URL and returns the credentials of the Hub
+func (ca *Cagent) requestCredentials(enrollmentURL, user, password string, req enrollmentRequest) (*enrollmentResponse, error) {
+	// the request is sent without holding reloadLock, a config reload doesn't wait for it
+	ca.reloadLock.RLock()
+	ca.initHubClientOnce()
+	cfg, client := ca.Config, ca.hubClient
+	req.AgentVersion = Version
+	req.HostUUID = ca.hostUUID()
+	// errors are logged by HostInfoResults and Inventory, partial results still help to identify the host
+	req.HostInfo, _ = ca.HostInfoResults()
+	ca.reloadLock.RUnlock()
+	req.HWInventory, _ = hwinfo.Inventory()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to serialize the enrollment request")
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), time.Duration(cfg.HubRequestTimeout)*time.Second)
+	defer cancelFn()
+
+	httpReq, err := http.NewRequest("POST", enrollmentURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Add("User-Agent", ca.userAgent())
+	if req.HostUUID != "" {
+		httpReq.Header.Set(hostUUIDHeader, req.HostUUID)
+	}
+	if user != "" {
+		httpReq.SetBasicAuth(user, password)
+	}
+
+	resp, err := client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		if tlsErr := hubTLSError(cfg, err); tlsErr != nil {
+			return nil, tlsErr
+		}
+		return nil, errors.Wrap(err, "enrollment request failed")
+	}
+	respBody := readHubResponseBody(resp)
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, errors.Errorf("the Hub refused the enrollment (HTTP %d), the token may be expired or used already. %s", resp.StatusCode, respBody)
+	case resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest:
+		return nil, errors.Errorf("got unexpected response from the Hub (HTTP %d). %s", resp.StatusCode, respBody)
+	}
+
+	var credentials enrollmentResponse
+	if err := json.Unmarshal(respBody, &credentials); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the enrollment response")
+	}
+	if credentials.HubUser == "" || credentials.HubPassword == "" {
+		return nil, errors.New("the enrollment response contains no credentials")
+	}
+	if credentials.HubURL != "" {
+		if err := validateHTTPURL(credentials.HubURL); err != nil {
+			return nil, errors.Wrap(err, "the enrollment response contains an invalid hub_url")
+		}
+	}
+
+	return &credentials, nil
+}
+
+// writeEnrollmentDropIn replaces the enrollment drop-in, a reader never sees a partially written file
+func writeEnrollmentDropIn(configFilePath string, credentials enrollmentCredentials) error {
+	dir := filepath.Join(filepath.Dir(configFilePath), configDropInDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create the config drop-in directory '%s': %s", dir, err.Error())
+	}
+
+	buf := bytes.NewBuffer(enrollmentDropInHeadline)
+	if err := toml.NewEncoder(buf).Encode(credentials); err != nil {
+		return errors.Wrap(err, "failed to encode the credentials")
+	}
+
+	path := filepath.Join(dir, enrollmentDropIn)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write the credentials to '%s': %s", tmp, err.Error())
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write the credentials to '%s': %s", path, err.Error())
+	}
+
+	return nil
+}
+
+// rotateHubCredentials is requested by the Hub. It fetches new credentials in the background,
+// stores them in the enrollment drop-in and reloads the config
+func (ca *Cagent) rotateHubCredentials() {
+	if ca.Config.HubEnrollmentURL == "" {
+		log.Warn("the Hub requested a credential rotation, but hub_enrollment_url is not set")
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&ca.rotatingCredentials, 0, 1) {
+		return
+	}
+
+	// the caller holds the reload lock, the settings are taken before it's released
+	enrollmentURL, user, password := ca.Config.HubEnrollmentURL, ca.Config.HubUser, ca.Config.HubPassword
+	go func() {
+		defer atomic.StoreInt32(&ca.rotatingCredentials, 0)
+
+		resp, err := ca.requestCredentials(enrollmentURL, user, password, enrollmentRequest{Rotate: true})
+		if err != nil {
+			log.WithError(err).Error("failed to rotate the Hub credentials")
+			return
+		}
+
+		err = writeEnrollmentDropIn(ca.ConfigLocation, enrollmentCredentials{
+			HubUser:          resp.HubUser,
+			HubPassword:      resp.HubPassword,
+			HubEnrollmentURL: enrollmentURL,
+		})
+		if err != nil {
+			log.WithError(err).Error("failed to store the rotated Hub credentials")
+			return
+		}
+
+		if err := ca.ReloadConfig(); err != nil {
+			log.WithError(err).Error("failed to apply the rotated Hub credentials")
+			return
+		}
+		log.Info("the Hub credentials were rotated")
+	}()
+}
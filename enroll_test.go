@@ -0,0 +1,101 @@
+package cagent
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnroll(t *testing.T) {
+	var rotations int32
+	hostUUIDs := make(chan string, 10)
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req enrollmentRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, req.HostUUID, r.Header.Get(hostUUIDHeader))
+		hostUUIDs <- req.HostUUID
+
+		if req.Rotate {
+			user, password, _ := r.BasicAuth()
+			if user != "host-1" || password != "secret-1" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			atomic.AddInt32(&rotations, 1)
+			_ = json.NewEncoder(w).Encode(enrollmentResponse{HubUser: "host-1", HubPassword: "secret-2"})
+			return
+		}
+
+		if req.Token != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		assert.NotEmpty(t, req.HostInfo)
+		_ = json.NewEncoder(w).Encode(enrollmentResponse{HubURL: "https://hub.example.com/", HubUser: "host-1", HubPassword: "secret-1"})
+	}))
+	defer hub.Close()
+
+	dir, err := ioutil.TempDir("", "enroll")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	configPath := filepath.Join(dir, "cagent.conf")
+	identityPath := filepath.Join(dir, "host_identity.json")
+	newEnrollmentConfig := func() *Config {
+		cfg := NewConfig()
+		cfg.HostIdentity.File = identityPath
+		return cfg
+	}
+
+	assert.Error(t, enroll(newEnrollmentConfig(), configPath, hub.URL, "wrong"))
+	_, err = os.Stat(configPath)
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, enroll(newEnrollmentConfig(), configPath, hub.URL, "token"))
+	assert.Error(t, enroll(newEnrollmentConfig(), configPath, hub.URL, "token"), "an existing config is not replaced")
+
+	enrolledUUID := <-hostUUIDs
+	assert.NotEmpty(t, enrolledUUID)
+	assert.Equal(t, enrolledUUID, <-hostUUIDs)
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(filepath.Join(dir, configDropInDir, enrollmentDropIn))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	cfg, err := HandleAllConfigSetup(configPath)
+	require.NoError(t, err)
+	assert.Equal(t, "https://hub.example.com/", cfg.HubURL)
+	assert.Equal(t, "host-1", cfg.HubUser)
+	assert.Equal(t, "secret-1", cfg.HubPassword)
+	assert.Equal(t, hub.URL, cfg.HubEnrollmentURL)
+
+	cfg.HostIdentity.File = identityPath
+	ca, err := New(cfg, configPath)
+	require.NoError(t, err)
+	defer ca.Shutdown()
+	assert.Equal(t, enrolledUUID, ca.hostUUID(), "the host keeps the UUID it enrolled with")
+
+	ca.handleHubResponse([]byte(`{"rotate_credentials": true}`))
+	for i := 0; i < 100 && ca.currentHubPassword() != "secret-2"; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	assert.Equal(t, "secret-2", ca.currentHubPassword())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&rotations))
+}
+
+func (ca *Cagent) currentHubPassword() string {
+	ca.reloadLock.RLock()
+	defer ca.reloadLock.RUnlock()
+	return ca.Config.HubPassword
+}
@@ -0,0 +1,145 @@
+package cagent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/securez-one/cagent/pkg/events"
+	"github.com/securez-one/cagent/pkg/jobmon"
+	"github.com/securez-one/cagent/pkg/monitoring"
+)
+
+// eventsEndpoint is where the events are sent. It's taken from the config the publisher was started with,
+// the publisher doesn't hold the reload lock
+type eventsEndpoint struct {
+	dest      *hubDestination
+	client    *http.Client
+	userAgent string
+	timeout   time.Duration
+}
+
+type eventsPayload struct {
+	Timestamp int64          `json:"timestamp"`
+	Events    []events.Event `json:"events"`
+}
+
+// initEvents starts the publisher of the urgent events if they are enabled and makes it the default one for the watchers and modules
+func (ca *Cagent) initEvents() {
+	ca.stopEvents()
+	if !ca.Config.Events.Enabled {
+		return
+	}
+
+	ca.initHubClientOnce()
+	endpoint := eventsEndpoint{
+		dest:      newHubDestination("events", []string{ca.Config.Events.URL}, ca.Config.Events.User, ca.Config.Events.Password, ca.hostUUID()),
+		client:    ca.hubClient,
+		userAgent: ca.userAgent(),
+		timeout:   time.Duration(ca.Config.HubRequestTimeout) * time.Second,
+	}
+
+	ca.eventsPublisher = events.NewPublisher(endpoint.post, secToDuration(ca.Config.Events.Debounce), log.StandardLogger())
+	events.SetDefault(ca.eventsPublisher)
+}
+
+func (ca *Cagent) stopEvents() {
+	if ca.eventsPublisher == nil {
+		return
+	}
+
+	events.SetDefault(nil)
+	ca.eventsPublisher.Shutdown()
+	ca.eventsPublisher = nil
+}
+
+// post sends the events to events.url with the credentials of events.user and events.password
+func (endpoint eventsEndpoint) post(list []events.Event) error {
+	b, err := json.Marshal(eventsPayload{Timestamp: time.Now().Unix(), Events: list})
+	if err != nil {
+		return errors.Wrap(err, "failed to serialize the events")
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), endpoint.timeout)
+	defer cancelFn()
+
+	var respURL string
+	resp, err := endpoint.dest.do(endpoint.client, func(url string) (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Add("User-Agent", endpoint.userAgent)
+		return req.WithContext(ctx), nil
+	}, func(url string, _ time.Time, _ *http.Response, _ error) {
+		respURL = url
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %s", respURL, resp.Status)
+	}
+
+	return nil
+}
+
+// emitModuleEvents emits the alerts and warnings of the module reports as events
+func emitModuleEvents(reports []*monitoring.ModuleReport) {
+	for _, r := range reports {
+		for _, alert := range r.Alerts {
+			events.Emit(events.Event{Source: r.Name, Key: r.Key(string(alert)), Severity: events.SeverityAlert, Message: string(alert)})
+		}
+		for _, warning := range r.Warnings {
+			events.Emit(events.Event{Source: r.Name, Key: r.Key(string(warning)), Severity: events.SeverityWarning, Message: string(warning)})
+		}
+	}
+}
+
+// emitJobEvents emits the failed jobs reported by the jobmon wrapper unless their severity is none
+func emitJobEvents(jobs []*jobmon.JobRun) {
+	for _, job := range jobs {
+		if !job.Failed() || job.Severity == jobmon.SeverityNone {
+			continue
+		}
+
+		msg := jobFailureMessage(job)
+		severity := events.SeverityAlert
+		if job.Severity == jobmon.SeverityWarning {
+			severity = events.SeverityWarning
+		}
+
+		e := events.Event{
+			Source:   "jobmon",
+			Key:      fmt.Sprintf("%s|%d", job.ID, time.Time(job.StartedAt).Unix()),
+			Severity: severity,
+			Message:  msg,
+			Value:    job.ExitCode,
+		}
+		if job.EndedAt != nil {
+			e.Timestamp = time.Time(*job.EndedAt).Unix()
+		}
+		events.Emit(e)
+	}
+}
+
+func jobFailureMessage(job *jobmon.JobRun) string {
+	msg := fmt.Sprintf("job %s failed", job.ID)
+	if job.ExitCode != nil {
+		msg += fmt.Sprintf(" with exit code %d", *job.ExitCode)
+	}
+	if len(job.Errors) > 0 {
+		msg += ": " + strings.Join(job.Errors, "; ")
+	}
+	return msg
+}
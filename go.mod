@@ -27,7 +27,8 @@ require (
 	github.com/troian/toml v0.4.2
 	github.com/vcraescu/go-xrandr v0.0.0-20190102070802-135ba5f1bc04
 	golang.org/x/sys v0.0.0-20191024073052-e66fe6eb8e0c
-	gopkg.in/Knetic/govaluate.v3 v3.0.0 // indirect
+	google.golang.org/protobuf v1.27.1
+	gopkg.in/Knetic/govaluate.v3 v3.0.0
 	gopkg.in/toast.v1 v1.0.0-20180812000517-0a84660828b2
 	howett.net/plist v0.0.0-20201203080718-1454fab16a06
 )
@@ -1,27 +1,21 @@
 package cagent
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"net"
 	"net/http"
 	"os"
-	"runtime"
 	"time"
 
 	"github.com/cloudradar-monitoring/selfupdate"
 	"github.com/pkg/errors"
-	"github.com/shirou/gopsutil/mem"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/securez-one/cagent/pkg/backoff"
 	"github.com/securez-one/cagent/pkg/common"
-	"github.com/securez-one/cagent/pkg/hwinfo"
-	"github.com/securez-one/cagent/pkg/jobmon"
-	"github.com/securez-one/cagent/pkg/monitoring/docker"
-	"github.com/securez-one/cagent/pkg/monitoring/networking"
-	"github.com/securez-one/cagent/pkg/monitoring/processes"
-	"github.com/securez-one/cagent/pkg/monitoring/sensors"
-	"github.com/securez-one/cagent/pkg/monitoring/services"
-	"github.com/securez-one/cagent/pkg/monitoring/updates"
+	"github.com/securez-one/cagent/pkg/monitoring"
 )
 
 type Cleaner interface {
@@ -64,60 +58,78 @@ func (ca *Cagent) Run(outputFile *os.File, interrupt chan struct{}) {
 		}
 	}()
 
-	retries := 0
-	retryIn := secToDuration(ca.Config.Interval)
-	var firstRetry time.Time
-	var measurements common.MeasurementsMap
+	stopPrometheusExporter := ca.startPrometheusExporter()
+	defer stopPrometheusExporter()
+
+	if outputFile == nil && ca.Config.RandomStartOffset {
+		// spread the reports of hosts started at the same time, e.g. after a power outage or a mass deployment
+		offset := backoff.StartOffset(secToDuration(ca.Config.Interval))
+		log.Debugf("Run: first collection in %v", offset)
+		select {
+		case <-interrupt:
+			return
+		case <-ca.collectNow:
+		case <-time.After(offset):
+		}
+	}
+
+	var retry hubRetry
+	var result *Result
 	var cleaner Cleaner
 
 	for {
-		if retries == 0 {
+		ca.reloadLock.RLock()
+		if retry.retries == 0 {
 			log.Debug("Run: collectMeasurements")
+			var measurements common.MeasurementsMap
 			measurements, cleaner = ca.collectMeasurements(ca.Config.OperationMode == OperationModeFull)
+			result = newResult(measurements)
+			ca.setLastResult(result)
 		}
-		err := ca.reportMeasurements(measurements, outputFile)
+		// a config reload doesn't wait for the delivery and the retries
+		d := ca.currentDelivery()
+		ca.reloadLock.RUnlock()
+
+		// the interval may be changed by a config reload
+		interval := secToDuration(d.cfg.Interval)
+		if outputFile == nil {
+			// the stored results are older, they are replayed first to keep the order
+			ca.flushOutbox(d)
+		}
+		err := ca.reportResult(d, result, outputFile)
 		if err == nil {
 			err = cleaner.Cleanup()
 		}
 
+		retryIn, gaveUp := retry.next(err, d.cfg, interval)
 		if err != nil {
-			if err == ErrHubTooManyRequests {
-				// for error code 429, wait 10 seconds and try again
-				retryIn = 10 * time.Second
+			switch errors.Cause(err) {
+			case ErrHubTooManyRequests:
 				log.Infof("Run: HTTP 429, too many requests, retrying in %v", retryIn)
-			} else if err == ErrHubUnauthorized {
-				// increase sleep time by 30 seconds until it is 1 hour
-				if ca.Config.Sleep < 60*60 {
-					ca.Config.Sleep += 30
-				}
-				retries = 0
-				retryIn = time.Duration(ca.Config.Sleep) * time.Second
-				log.Infof("Run: failed to send measurements to hub. unable to authorize with provided Hub credentials (HTTP 401). waiting %v seconds until next attempt", ca.Config.Sleep)
-			} else if err == ErrHubServerError {
-				// for error codes 5xx, wait for configured amount of time and try again
-				retryIn = time.Duration(ca.Config.OnHTTP5xxRetryInterval) * time.Second
-				if retries == 0 {
-					firstRetry = time.Now()
-				}
-				retries++
-				if retries > ca.Config.OnHTTP5xxRetries {
-					retries = 0
-					retryIn = secToDuration(ca.Config.Interval) - time.Since(firstRetry)
-					if retryIn < 0 {
-						retryIn = 0
-					}
-					log.Errorf("Run: hub connection error, next run in %v s (out of %v s)", retryIn, secToDuration(ca.Config.Interval))
+			case ErrHubUnauthorized:
+				log.Infof("Run: failed to send measurements to hub. unable to authorize with provided Hub credentials (HTTP 401). waiting %v until next attempt", retryIn)
+			case ErrHubServerError:
+				if gaveUp {
+					log.Errorf("Run: hub connection error, next run in %v (out of %v)", retryIn, interval)
+					ca.storeInOutbox(d, result, cleaner)
 				} else {
-					log.Infof("Run: hub connection error %d/%d, retrying in %v s", retries, ca.Config.OnHTTP5xxRetries, ca.Config.OnHTTP5xxRetryInterval)
+					log.Infof("Run: hub connection error %d/%d, retrying in %v", retry.retries, d.cfg.OnHTTP5xxRetries, retryIn)
 				}
-			} else {
+			default:
 				log.Error(err)
+				if outputFile == nil && isHubUnreachable(err) {
+					ca.storeInOutbox(d, result, cleaner)
+				}
 			}
 		}
+		ca.status.setRetry(retry.retries, retryIn, err)
 
 		select {
 		case <-interrupt:
 			return
+		case <-ca.collectNow:
+			// requested by the Hub, a pending retry is done right away
+			continue
 		case <-time.After(retryIn):
 			continue
 		}
@@ -126,150 +138,147 @@ func (ca *Cagent) Run(outputFile *os.File, interrupt chan struct{}) {
 
 func (ca *Cagent) RunOnce(outputFile *os.File, fullMode bool) error {
 	measurements, cleaner := ca.collectMeasurements(fullMode)
-	err := ca.reportMeasurements(measurements, outputFile)
+	result := newResult(measurements)
+	ca.setLastResult(result)
+	err := ca.reportResult(ca.deliverySnapshot(), result, outputFile)
 	if err == nil {
 		err = cleaner.Cleanup()
 	}
 	return err
 }
 
-func (ca *Cagent) collectMeasurements(fullMode bool) (common.MeasurementsMap, Cleaner) {
-	var errCollector = common.ErrorCollector{}
-	var cleanupCommand = &cleanupCommand{}
-	var measurements = make(common.MeasurementsMap)
-	var cfg = ca.Config
-
-	if ca.Config.CPUMonitoring {
-		cpum, err := ca.CPUWatcher().Results()
-		errCollector.Add(err)
-		measurements = measurements.AddWithPrefix("cpu.", cpum)
+// storeInOutbox keeps the undelivered result on disk to send it later.
+// Cleanup steps are executed right away, because the result is persisted from now on
+func (ca *Cagent) storeInOutbox(d *delivery, result *Result, cleaner Cleaner) {
+	if d.outbox == nil || result == nil {
+		return
 	}
 
-	if ca.Config.FSMonitoring {
-		fsResults, err := ca.GetFileSystemWatcher().Results()
-		errCollector.Add(err)
-		measurements = measurements.AddWithPrefix("fs.", fsResults)
+	err := d.outbox.Put(result.Timestamp, result)
+	if err != nil {
+		log.WithError(err).Error("Run: failed to store measurements in the outbox")
+		return
 	}
+	log.Infof("Run: measurements stored in the outbox to be sent later")
 
-	var memStat *mem.VirtualMemoryStat
-	if ca.Config.MemMonitoring {
-		var mem common.MeasurementsMap
-		var err error
-		mem, memStat, err = ca.MemResults()
-		errCollector.Add(err)
-		measurements = measurements.AddWithPrefix("mem.", mem)
+	if err := cleaner.Cleanup(); err != nil {
+		log.WithError(err).Error("Run: cleanup failed")
 	}
+}
 
-	if ca.Config.CPUMonitoring {
-		cpuUtilisationAnalysisResult, cpuUtilisationAnalysisIsActive, err := ca.CPUUtilisationAnalyser().Results()
-		errCollector.Add(err)
-		measurements = measurements.AddWithPrefix("cpu_utilisation_analysis.", cpuUtilisationAnalysisResult)
-		if cpuUtilisationAnalysisIsActive {
-			measurements = measurements.AddWithPrefix(
-				"cpu_utilisation_analysis.",
-				common.MeasurementsMap{"settings": cfg.CPUUtilisationAnalysis},
-			)
-		}
+// flushOutbox replays the results stored in the outbox, oldest first.
+// It stops on the first delivery error, leaving the remaining entries for the next attempt
+func (ca *Cagent) flushOutbox(d *delivery) {
+	if d.outbox == nil {
+		return
 	}
 
-	if fullMode {
-		info, err := ca.HostInfoResults()
-		errCollector.Add(err)
-		measurements = measurements.AddWithPrefix("system.", info)
+	entries, err := d.outbox.Entries()
+	if err != nil {
+		log.WithError(err).Error("Run: failed to list outbox entries")
+		return
+	}
 
-		ipResults, err := networking.IPAddresses()
-		errCollector.Add(err)
-		measurements = measurements.AddWithPrefix("system.", ipResults)
+	if len(entries) > 0 {
+		log.Infof("Run: sending %d results stored in the outbox", len(entries))
+	}
 
-		if ca.Config.NetMonitoring {
-			netResults, err := ca.GetNetworkWatcher().Results()
-			errCollector.Add(err)
-			measurements = measurements.AddWithPrefix("net.", netResults)
+	for _, e := range entries {
+		var result Result
+		err = d.outbox.Read(e, &result)
+		if err != nil {
+			log.WithError(err).Error("Run: dropping unreadable outbox entry")
+			_ = d.outbox.Remove(e)
+			continue
 		}
 
-		proc, processList, err := processes.GetMeasurements(memStat, &ca.Config.ProcessMonitoring)
-		errCollector.Add(err)
-		measurements = measurements.AddWithPrefix("proc.", proc)
-
-		ports, err := ca.PortsResult(processList)
-		errCollector.Add(err)
-		measurements = measurements.AddWithPrefix("listeningports.", ports)
-
-		if ca.Config.MemMonitoring {
-			swap, err := ca.SwapResults()
-			errCollector.Add(err)
-			measurements = measurements.AddWithPrefix("swap.", swap)
+		ctx, cancelFn := context.WithTimeout(context.Background(), time.Duration(d.cfg.HubRequestTimeout)*time.Second)
+		err = ca.replayResultToHub(ctx, d, &result)
+		cancelFn()
+		if cause := errors.Cause(err); err != nil && (cause == ErrHubTooManyRequests || cause == ErrHubUnauthorized || isHubUnreachable(err)) {
+			log.WithError(err).Infof("Run: outbox delivery interrupted, %d results left", d.outbox.Len())
+			return
 		}
 
-		ca.getVMStatMeasurements(func(name string, meas common.MeasurementsMap, err error) {
-			if err == nil {
-				measurements = measurements.AddWithPrefix("virt."+name+".", meas)
-			}
-			errCollector.Add(err)
-		})
-
-		ca.hwInventory.Do(func() {
-			hwInfo, err := hwinfo.Inventory()
-			errCollector.Add(err)
-			if hwInfo != nil {
-				measurements = measurements.AddInnerWithPrefix("hw.inventory", hwInfo)
-			}
-		})
-
-		if cfg.SystemUpdatesChecks.Enabled && cfg.SystemUpdatesChecks.CheckInterval > 0 {
-			watcher := updates.GetWatcher(cfg.SystemUpdatesChecks.FetchTimeout, cfg.SystemUpdatesChecks.CheckInterval)
-			u, err := watcher.GetSystemUpdatesInfo()
-			if err != updates.ErrorDisabledOnHost {
-				errCollector.Add(err)
-				var prefix string
-				if runtime.GOOS == "windows" {
-					prefix = "windows_update."
-				} else {
-					prefix = "linux_update."
-				}
-				measurements = measurements.AddWithPrefix(prefix, u)
-			}
+		if err != nil {
+			// the Hub refused the payload itself, retrying it won't help
+			log.WithError(err).Errorf("Run: dropping outbox entry from %s", time.Unix(result.Timestamp, 0))
 		}
 
-		servicesList, err := services.ListServices(cfg.DiscoverAutostartingServicesOnly)
-		if err != services.ErrorNotImplementedForOS {
-			errCollector.Add(err)
+		err = d.outbox.Remove(e)
+		if err != nil {
+			log.WithError(err).Error("Run: failed to remove delivered outbox entry")
+			return
 		}
-		measurements = measurements.AddWithPrefix("services.", servicesList)
+	}
+}
 
-		if cfg.DockerMonitoring.Enabled {
-			containersList, err := docker.ListContainers()
-			if err != docker.ErrorNotImplementedForOS && err != docker.ErrorDockerNotAvailable {
-				errCollector.Add(err)
-			}
-			measurements = measurements.AddWithPrefix("docker.", containersList)
+// isHubUnreachable returns true if err means the result did not reach the Hub
+// because of a server-side or network failure
+func isHubUnreachable(err error) bool {
+	cause := errors.Cause(err)
+	if cause == ErrHubServerError || cause == errHubConnectionTimeout {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func (ca *Cagent) collectMeasurements(fullMode bool) (common.MeasurementsMap, Cleaner) {
+	var started = time.Now()
+	var errCollector = common.ErrorCollector{}
+	var cleanupCommand = &cleanupCommand{}
+	var measurements = make(common.MeasurementsMap)
+	var timedOut []string
+	var collectorDurations = make(map[string]time.Duration)
+
+	collectors := ca.collectors(fullMode)
+	for i, r := range ca.runCollectors(collectors) {
+		errCollector.Add(r.err)
+		if !r.cached && r.duration > 0 {
+			collectorDurations[collectors[i].name] = r.duration
+		}
+		if r.timedOut {
+			timedOut = append(timedOut, collectors[i].name)
+			continue
 		}
 
-		if cfg.TemperatureMonitoring {
-			temperatures, err := sensors.ReadTemperatureSensors()
-			errCollector.Add(err)
-			measurements = measurements.AddWithPrefix("temperatures.", common.MeasurementsMap{"list": temperatures})
+		measurements = measurements.AddWithPrefix("", r.measurements)
+		if collectors[i].cleanup != nil && !r.cached {
+			cleanupCommand.AddStep(collectors[i].cleanup)
 		}
+	}
 
-		moduleReports, err := ca.collectModulesMeasurements()
-		errCollector.Add(err)
-		measurements = measurements.AddWithPrefix("", common.MeasurementsMap{"modules": moduleReports})
+	measurements["operation_mode"] = ca.Config.OperationMode
+	measurements = measurements.AddWithPrefix("", ca.hostIdentityMeasurements())
 
-		smartMeas := ca.getSMARTMeasurements()
-		if len(smartMeas) > 0 {
-			measurements = measurements.AddInnerWithPrefix("smartmon", smartMeas)
-		}
+	if version := ca.Config.RemoteConfigVersion(); version != "" {
+		measurements["cagent.remote_config_version"] = version
+	}
+	if rejectedVersion, reason := ca.status.remoteConfigRejected(); rejectedVersion != "" {
+		measurements["cagent.remote_config_error"] = "version " + rejectedVersion + " rejected: " + reason
+	}
+
+	if ca.Config.SelfTelemetry {
+		measurements = measurements.AddWithPrefix("", ca.selfTelemetry(collectorDurations))
+	}
 
-		spool := jobmon.NewSpoolManager(cfg.JobMonitoring.SpoolDirPath, log.StandardLogger())
-		ids, jobs, err := spool.GetFinishedJobs()
+	_, modulesCollected := measurements["modules"]
+	ca.trackAlerts(measurements, modulesCollected)
+	if ca.rulesEngine != nil {
+		report, err := ca.rulesEngine.Evaluate(measurements, time.Now())
 		errCollector.Add(err)
-		measurements = measurements.AddWithPrefix("", common.MeasurementsMap{"jobmon": jobs})
-		cleanupCommand.AddStep(func() error {
-			return spool.RemoveJobs(ids)
-		})
+		measurements["modules"] = appendModuleReport(measurements["modules"], report)
 	}
+	ca.notifyWebhook(measurements, modulesCollected)
 
-	measurements["operation_mode"] = cfg.OperationMode
+	if len(timedOut) > 0 {
+		// the result is partial: measurements of these collectors are missing
+		measurements["cagent.timed_out_collectors"] = timedOut
+	}
+
+	ca.status.setCollection(started, errCollector.Errors())
 
 	if errCollector.HasErrors() {
 		measurements["message"] = errCollector.Combine()
@@ -281,11 +290,20 @@ func (ca *Cagent) collectMeasurements(fullMode bool) (common.MeasurementsMap, Cl
 	return measurements, cleanupCommand
 }
 
-func (ca *Cagent) reportMeasurements(measurements common.MeasurementsMap, outputFile *os.File) error {
-	result := &Result{
+// appendModuleReport adds the report to the reports of the modules without modifying them, they may be cached
+func appendModuleReport(modules interface{}, report *monitoring.ModuleReport) []*monitoring.ModuleReport {
+	reports, _ := modules.([]*monitoring.ModuleReport)
+	return append(reports[:len(reports):len(reports)], report)
+}
+
+func newResult(measurements common.MeasurementsMap) *Result {
+	return &Result{
 		Timestamp:    time.Now().Unix(),
 		Measurements: measurements,
 	}
+}
+
+func (ca *Cagent) reportResult(d *delivery, result *Result, outputFile *os.File) error {
 	if outputFile != nil {
 		err := json.NewEncoder(outputFile).Encode(result)
 		if err != nil {
@@ -294,22 +312,7 @@ func (ca *Cagent) reportMeasurements(measurements common.MeasurementsMap, output
 		return nil
 	}
 
-	if ca.Config.Logs.HubFile != "" {
-		ca.prettyPrintMeasurementsToFile(measurements, ca.Config.Logs.HubFile)
-	}
-
-	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancelFn()
-
-	err := ca.PostResultToHub(ctx, result)
-	if err != nil {
-		if err == ErrHubTooManyRequests || err == ErrHubServerError || err == ErrHubUnauthorized {
-			return err
-		}
-		err = errors.Wrap(err, "failed to POST measurement result to Hub")
-	}
-
-	return err
+	return ca.writeToSinks(d, result)
 }
 
 func (ca *Cagent) RunHeartbeat(interrupt chan struct{}) {
@@ -317,43 +320,29 @@ func (ca *Cagent) RunHeartbeat(interrupt chan struct{}) {
 		ca.selfUpdater = selfupdate.StartChecking()
 	}
 
-	var firstRetry time.Time
-	retries := 0
-	retryIn := secToDuration(ca.Config.HeartbeatInterval)
+	stopStatusAPI := ca.startStatusAPI()
+	defer stopStatusAPI()
+
+	var retry hubRetry
 
 	for {
-		err := ca.sendHeartbeat()
+		d := ca.deliverySnapshot()
+		interval := secToDuration(d.cfg.HeartbeatInterval)
+		err := ca.sendHeartbeat(d)
+		retryIn, gaveUp := retry.next(err, d.cfg, interval)
 		if err != nil {
-			if err == ErrHubTooManyRequests {
-				// for error code 429, wait 10 seconds and try again
-				retryIn = 10 * time.Second
+			switch errors.Cause(err) {
+			case ErrHubTooManyRequests:
 				log.Infof("RunHeartbeat: HTTP 429, too many requests, retrying in %v", retryIn)
-			} else if err == ErrHubUnauthorized {
-				// increase sleep time by 30 seconds until it is 1 hour
-				if ca.Config.Sleep < 60*60 {
-					ca.Config.Sleep += 30
-				}
-				retries = 0
-				retryIn = time.Duration(ca.Config.Sleep) * time.Second
-				log.Infof("Run: failed to send heartbeat to hub. unable to authorize with provided Hub credentials (HTTP 401). waiting %v seconds until next attempt", ca.Config.Sleep)
-			} else if err == ErrHubServerError {
-				// for error codes 5xx, wait for configured amount of time and try again
-				retryIn = time.Duration(ca.Config.OnHTTP5xxRetryInterval) * time.Second
-				if retries == 0 {
-					firstRetry = time.Now()
-				}
-				retries++
-				if retries > ca.Config.OnHTTP5xxRetries {
-					retries = 0
-					retryIn = secToDuration(ca.Config.HeartbeatInterval) - time.Since(firstRetry)
-					if retryIn < 0 {
-						retryIn = 0
-					}
-					log.Errorf("RunHeartbeat: hub connection error, next run in %v s (out of %v s)", retryIn, secToDuration(ca.Config.HeartbeatInterval))
+			case ErrHubUnauthorized:
+				log.Infof("RunHeartbeat: failed to send heartbeat to hub. unable to authorize with provided Hub credentials (HTTP 401). waiting %v until next attempt", retryIn)
+			case ErrHubServerError:
+				if gaveUp {
+					log.Errorf("RunHeartbeat: hub connection error, next run in %v (out of %v)", retryIn, interval)
 				} else {
-					log.Infof("RunHeartbeat: hub connection error %d/%d, retrying in %v s", retries, ca.Config.OnHTTP5xxRetries, ca.Config.OnHTTP5xxRetryInterval)
+					log.Infof("RunHeartbeat: hub connection error %d/%d, retrying in %v", retry.retries, d.cfg.OnHTTP5xxRetries, retryIn)
 				}
-			} else {
+			default:
 				log.WithError(err).Error("failed to send heartbeat to Hub")
 			}
 		}
@@ -367,42 +356,60 @@ func (ca *Cagent) RunHeartbeat(interrupt chan struct{}) {
 	}
 }
 
-func (ca *Cagent) sendHeartbeat() error {
-	ca.initHubClientOnce()
-	err := ca.validateHubURL("hub_url")
+// heartbeatPayload is posted with the heartbeat if there are results of the actions requested by the Hub
+type heartbeatPayload struct {
+	ActionResults []HubActionResult `json:"action_results"`
+}
+
+// sendHeartbeat sends a GET request to the Hub, or a POST request with the pending results of the Hub actions
+func (ca *Cagent) sendHeartbeat(d *delivery) error {
+	err := d.cfg.validateHubURL("hub_url")
 	if err != nil {
 		return err
 	}
 
+	actionResults := ca.pendingHubActionResults()
+	var payload []byte
+	if len(actionResults) > 0 {
+		payload, err = json.Marshal(heartbeatPayload{ActionResults: actionResults})
+		if err != nil {
+			return errors.Wrap(err, "failed to serialize the action results")
+		}
+	}
+
 	// no need to wait more than heartbeat interval
-	ctx, cancelFn := context.WithTimeout(context.Background(), secToDuration(ca.Config.HeartbeatInterval))
+	ctx, cancelFn := context.WithTimeout(context.Background(), secToDuration(d.cfg.HeartbeatInterval))
 	defer cancelFn()
 
-	req, err := http.NewRequest("GET", ca.Config.HubURL, nil)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	req.Header.Add("User-Agent", ca.userAgent())
-	if len(ca.Config.HubUser) > 0 {
-		req.SetBasicAuth(ca.Config.HubUser, ca.Config.HubPassword)
-	}
-	req = req.WithContext(ctx)
-	resp, err := ca.hubClient.Do(req)
-	if resp != nil {
-		if resp.StatusCode == http.StatusTooManyRequests {
-			return ErrHubTooManyRequests
+	resp, err := d.hub.do(d.client, func(url string) (*http.Request, error) {
+		var req *http.Request
+		var err error
+		if payload != nil {
+			req, err = http.NewRequest("POST", url, bytes.NewReader(payload))
+		} else {
+			req, err = http.NewRequest("GET", url, nil)
+		}
+		if err != nil {
+			return nil, err
 		}
-		if resp.StatusCode == http.StatusUnauthorized {
-			return ErrHubUnauthorized
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
 		}
-		if resp.StatusCode >= 500 && resp.StatusCode <= 599 {
-			return ErrHubServerError
+		req.Header.Add("User-Agent", ca.userAgent())
+		return req.WithContext(ctx), nil
+	}, ca.recordHubRequest)
+	body := readHubResponseBody(resp)
+	if resp != nil {
+		if err := hubResultStatusError(resp); err != nil {
+			return err
 		}
 	}
-	if err = ca.checkClientError(resp, err, "hub_user", "hub_password"); err != nil {
+	if err = checkClientError(d.cfg, resp, err, "hub_user", "hub_password"); err != nil {
 		return errors.WithStack(err)
 	}
 	log.Debugf("Heartbeat sent. Status: %d", resp.StatusCode)
+	ca.acknowledgeHubActionResults(len(actionResults))
+	ca.handleHubResponse(body)
 	return err
 }
 
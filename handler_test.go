@@ -1,11 +1,21 @@
 package cagent
 
 import (
+	"context"
+	"encoding/json"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/delta"
+	"github.com/securez-one/cagent/pkg/monitoring"
 )
 
 func helperCreateCagent(t *testing.T) *Cagent {
@@ -19,6 +29,8 @@ func helperCreateCagent(t *testing.T) *Cagent {
 
 	cfg, err := HandleAllConfigSetup(tmpFilePath)
 	assert.NoError(t, err)
+	cfg.HostIdentity.File = tmpFilePath + ".host_identity.json"
+	defer os.Remove(cfg.HostIdentity.File)
 
 	ca, err := New(cfg, tmpFilePath)
 	assert.NoError(t, err)
@@ -37,3 +49,436 @@ func TestCagentCollectMeasurements(t *testing.T) {
 	}
 	assert.Equal(t, 1, m["cagent.success"], "msg %s", errorMsg)
 }
+
+func TestCagentRunCollectorsTimeout(t *testing.T) {
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+
+	ca.Config.CollectorTimeout = 0.05
+	release := make(chan struct{})
+	defer close(release)
+
+	collectors := []collector{
+		{name: "fast", collect: func(_ context.Context) (common.MeasurementsMap, error) {
+			return common.MeasurementsMap{"fast.value": 1}, nil
+		}},
+		{name: "hung", collect: func(_ context.Context) (common.MeasurementsMap, error) {
+			<-release
+			return common.MeasurementsMap{"hung.value": 1}, nil
+		}},
+	}
+
+	results := ca.runCollectors(collectors)
+	assert.False(t, results[0].timedOut)
+	assert.Equal(t, common.MeasurementsMap{"fast.value": 1}, results[0].measurements)
+	assert.True(t, results[1].timedOut)
+	assert.IsType(t, TimeoutError{}, results[1].err)
+
+	// the hung collector is not started again while it is still running
+	results = ca.runCollectors(collectors)
+	assert.False(t, results[0].timedOut)
+	assert.True(t, results[1].timedOut)
+	assert.Contains(t, results[1].err.Error(), "still running")
+}
+
+func TestCagentRunCollectorsCancelsTimedOut(t *testing.T) {
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+
+	ca.Config.CollectorTimeout = 0.05
+	finished := make(chan error, 1)
+
+	collectors := []collector{
+		{name: "docker", collect: func(ctx context.Context) (common.MeasurementsMap, error) {
+			_, err := common.RunCommandWithTimeoutContext(ctx, time.Minute, "sleep", "30")
+			finished <- err
+			return nil, err
+		}},
+	}
+
+	results := ca.runCollectors(collectors)
+	assert.True(t, results[0].timedOut)
+
+	// the command is killed once the collector times out, so the collector can run again
+	select {
+	case err := <-finished:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the command of the timed out collector was not killed")
+	}
+
+	for i := 0; i < 100; i++ {
+		ca.runningCollectorsLock.Lock()
+		running := ca.runningCollectors["docker"]
+		ca.runningCollectorsLock.Unlock()
+		if !running {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("the timed out collector is still marked as running")
+}
+
+func TestCagentRunCollectorsInterval(t *testing.T) {
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+
+	calls := 0
+	collectors := []collector{
+		{name: "services", collect: func(_ context.Context) (common.MeasurementsMap, error) {
+			calls++
+			return common.MeasurementsMap{"services.list": calls}, nil
+		}},
+	}
+
+	ca.Config.CollectorIntervals = map[string]float64{"services": 600}
+	ca.runCollectors(collectors)
+	results := ca.runCollectors(collectors)
+	assert.Equal(t, 1, calls)
+	assert.True(t, results[0].cached)
+	assert.Equal(t, common.MeasurementsMap{"services.list": 1}, results[0].measurements)
+
+	ca.Config.CollectorCacheMode = CollectorCacheModeOmit
+	results = ca.runCollectors(collectors)
+	assert.Equal(t, 1, calls)
+	assert.Nil(t, results[0].measurements)
+
+	ca.resetCollectorCache()
+	results = ca.runCollectors(collectors)
+	assert.Equal(t, 2, calls)
+	assert.False(t, results[0].cached)
+}
+
+func TestCagentSelfTelemetry(t *testing.T) {
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+
+	_, err := common.RunCommandWithTimeout(time.Second, "go", "version")
+	assert.NoError(t, err)
+	ca.status.setPayloadSize(2048, 512)
+	ca.status.setRetry(1, time.Second, ErrHubServerError)
+
+	m := ca.selfTelemetry(map[string]time.Duration{"cpu": 1500 * time.Millisecond})
+	assert.Equal(t, int64(1500), m["cagent.self.collectors.cpu.duration_ms"])
+	assert.True(t, m["cagent.self.commands.count"].(int64) >= 1)
+	assert.Equal(t, 2048, m["cagent.self.payload.size_B"])
+	assert.Equal(t, 512, m["cagent.self.payload.gzip_size_B"])
+	assert.Equal(t, 1, m["cagent.self.retries.current"])
+	assert.Equal(t, 1, m["cagent.self.retries.total"])
+	assert.True(t, m["cagent.self.goroutines"].(int) > 0)
+	assert.True(t, m["cagent.self.mem.rss_B"].(uint64) > 0)
+	assert.Contains(t, m, "cagent.self.cpu.time_s")
+
+	ca.Config.SelfTelemetry = false
+	measurements, _ := ca.collectMeasurements(false)
+	for key := range measurements {
+		assert.NotContains(t, key, "cagent.self.")
+	}
+}
+
+func TestCagentHandleHubResponseRemoteConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configFile := filepath.Join(dir, "cagent.conf")
+	assert.NoError(t, ioutil.WriteFile(configFile, []byte(`
+[remote_config]
+  enabled = true
+  cache_file = "`+filepath.Join(dir, "remote_config.json")+`"
+`), 0600))
+
+	cfg, err := HandleAllConfigSetup(configFile)
+	assert.NoError(t, err)
+	cfg.HostIdentity.File = filepath.Join(dir, "host_identity.json")
+	ca, err := New(cfg, configFile)
+	assert.NoError(t, err)
+	defer ca.Shutdown()
+
+	ca.applyRemoteConfigOverlay(&RemoteConfigOverlay{Version: "1", TOML: `fs_path_exclude = ['/mnt']`})
+	assert.Equal(t, "1", ca.Config.RemoteConfigVersion())
+	assert.Equal(t, []string{"/mnt"}, ca.Config.FSPathExclude)
+
+	ca.applyRemoteConfigOverlay(&RemoteConfigOverlay{Version: "2", TOML: `hub_url = "https://evil.example.com"`})
+	assert.Equal(t, "1", ca.Config.RemoteConfigVersion())
+	rejectedVersion, reason := ca.status.remoteConfigRejected()
+	assert.Equal(t, "2", rejectedVersion)
+	assert.Contains(t, reason, "hub_url")
+
+	// the rejected version is not tried again
+	ca.handleHubResponse([]byte(`{"remote_config": {"version": "2", "toml": "hub_url = 'x'"}}`))
+	assert.Equal(t, "1", ca.Config.RemoteConfigVersion())
+}
+
+func TestCagentHubActions(t *testing.T) {
+	var posted Result
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		posted = Result{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&posted))
+	}))
+	defer hub.Close()
+
+	logFile, err := ioutil.TempFile("", "")
+	assert.NoError(t, err)
+	defer os.Remove(logFile.Name())
+	_, err = logFile.WriteString("line 1\nline 2\nline 3\n")
+	assert.NoError(t, err)
+	assert.NoError(t, logFile.Close())
+
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+	ca.Config.HubURL = hub.URL
+	ca.Config.LogFile = logFile.Name()
+	ca.Config.HubActions.UploadLog = true
+	ca.Config.HubActions.MaxLogLines = 2
+	ca.Config.HubGzip = false
+	ca.Config.OperationMode = OperationModeFull
+
+	ca.handleHubResponse([]byte(`{"actions": [
+		{"id": "1", "action": "collect"},
+		{"id": "2", "action": "collect"},
+		{"id": "3", "action": "upload_log", "lines": 10},
+		{"id": "4", "action": "reboot"}
+	]}`))
+	assert.Len(t, ca.collectNow, 1)
+
+	// the results are sent with the next measurements
+	assert.NoError(t, ca.PostResultToHub(context.Background(), newResult(common.MeasurementsMap{"key": 1})))
+	assert.Equal(t, []HubActionResult{
+		{ID: "1", Action: HubActionCollect, Status: HubActionStatusOK},
+		{ID: "2", Action: HubActionCollect, Status: HubActionStatusRateLimited, Message: "runs at most once per 60s"},
+		{ID: "3", Action: HubActionUploadLog, Status: HubActionStatusOK, Output: []string{"line 2", "line 3"}},
+		{ID: "4", Action: "reboot", Status: HubActionStatusUnknown},
+	}, posted.ActionResults)
+
+	// and only until the Hub accepted them
+	assert.NoError(t, ca.PostResultToHub(context.Background(), newResult(common.MeasurementsMap{"key": 1})))
+	assert.Nil(t, posted.ActionResults)
+
+	// nothing is executed if the actions are disabled
+	ca.Config.HubActions.Enabled = false
+	ca.handleHubResponse([]byte(`{"actions": [{"id": "5", "action": "upload_log"}]}`))
+	assert.Empty(t, ca.pendingHubActionResults())
+}
+
+func TestCagentHubActionsHeartbeat(t *testing.T) {
+	var methods []string
+	var posted heartbeatPayload
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.Method == "POST" {
+			posted = heartbeatPayload{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&posted))
+		}
+		if len(methods) == 1 {
+			_, _ = w.Write([]byte(`{"actions": [{"id": "1", "action": "collect"}, {"id": "2", "action": "upload_log"}]}`))
+		}
+	}))
+	defer hub.Close()
+
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+	ca.Config.HubURL = hub.URL
+	ca.Config.OperationMode = OperationModeHeartbeat
+
+	// the results are sent with the next heartbeat and only until the Hub accepted them
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, ca.sendHeartbeat(ca.deliverySnapshot()))
+	}
+	assert.Equal(t, []string{"GET", "POST", "GET"}, methods)
+	assert.Equal(t, []HubActionResult{
+		{ID: "1", Action: HubActionCollect, Status: HubActionStatusFailed, Message: "no measurements are collected in the heartbeat operation mode"},
+		{ID: "2", Action: HubActionUploadLog, Status: HubActionStatusFailed, Message: "upload_log is disabled"},
+	}, posted.ActionResults)
+	assert.Empty(t, ca.pendingHubActionResults())
+}
+
+func TestCagentHubActionResendHWInventory(t *testing.T) {
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+	ca.Config.OperationMode = OperationModeFull
+	ca.Config.HardwareInventory = true
+	ca.Config.DeltaEncoding.Sections = []string{"hw.inventory"}
+	ca.deltaEncoder = delta.NewEncoder(time.Hour)
+
+	result := newResult(common.MeasurementsMap{"hw.inventory": common.MeasurementsMap{"cpu": "x86"}})
+	_, acknowledge := ca.deltaEncodeResult(ca.deliverySnapshot(), result)
+	acknowledge()
+
+	ca.handleHubResponse([]byte(`{"actions": [{"id": "1", "action": "resend_hw_inventory"}]}`))
+	assert.Equal(t, HubActionStatusOK, ca.pendingHubActionResults()[0].Status)
+
+	// the inventory is sent in full although it didn't change
+	payload, _ := ca.deltaEncodeResult(ca.deliverySnapshot(), result)
+	assert.Contains(t, payload.Measurements, "hw.inventory")
+	assert.True(t, payload.Measurements[deltaMeasurementsKey].(map[string]*delta.Section)["hw.inventory"].Full)
+}
+
+func TestCagentEvents(t *testing.T) {
+	received := make(chan eventsPayload, 1)
+	var authorization string
+	// the Hub refuses requests without its credentials
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		if user, password, ok := r.BasicAuth(); !ok || user != "hub-user" || password != "hub-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var payload eventsPayload
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		received <- payload
+	}))
+	defer endpoint.Close()
+
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+	ca.Config.Events.Enabled = true
+	ca.Config.Events.URL = endpoint.URL
+	ca.Config.Events.User = "hub-user"
+	ca.Config.Events.Password = "hub-secret"
+	ca.initEvents()
+
+	report := monitoring.NewReport("raid", time.Now(), "")
+	report.AddAlert("Raid md0 degraded. Missing 1 devices.")
+	emitModuleEvents([]*monitoring.ModuleReport{&report})
+
+	select {
+	case payload := <-received:
+		assert.Len(t, payload.Events, 1)
+		assert.Equal(t, "raid", payload.Events[0].Source)
+		assert.Equal(t, "alert", payload.Events[0].Severity)
+		assert.Equal(t, "Raid md0 degraded. Missing 1 devices.", payload.Events[0].Message)
+		assert.Equal(t, "Basic aHViLXVzZXI6aHViLXNlY3JldA==", authorization)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event was not posted")
+	}
+}
+
+func TestCagentRunReplaysOutboxFirst(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	timestamps := make(chan int64, 10)
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result Result
+		_ = json.NewDecoder(r.Body).Decode(&result)
+		timestamps <- result.Timestamp
+	}))
+	defer hub.Close()
+
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+	ca.Config.HubURL = hub.URL
+	ca.Config.HubGzip = false
+	ca.Config.RandomStartOffset = false
+	ca.Config.Outbox.Enabled = true
+	ca.Config.Outbox.DirPath = dir
+	ca.initOutbox()
+
+	stored := newResult(common.MeasurementsMap{"key": 1})
+	stored.Timestamp -= 60
+	assert.NoError(t, ca.outbox.Put(stored.Timestamp, stored))
+
+	interrupt := make(chan struct{})
+	go ca.Run(nil, interrupt)
+	defer func() { interrupt <- struct{}{} }()
+
+	for _, expected := range []func(int64) bool{
+		func(ts int64) bool { return ts == stored.Timestamp },
+		func(ts int64) bool { return ts > stored.Timestamp },
+	} {
+		select {
+		case ts := <-timestamps:
+			assert.True(t, expected(ts), "unexpected timestamp %d", ts)
+		case <-time.After(30 * time.Second):
+			t.Fatal("no result received")
+		}
+	}
+	assert.Equal(t, 0, ca.outbox.Len())
+}
+
+func TestCagentReloadDuringHubRequest(t *testing.T) {
+	received := make(chan struct{}, 1)
+	release := make(chan struct{})
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+		<-release
+	}))
+	defer hub.Close()
+
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+	ca.Config.HubURL = hub.URL
+	ca.Config.RandomStartOffset = false
+
+	interrupt := make(chan struct{})
+	go ca.Run(nil, interrupt)
+	defer func() { interrupt <- struct{}{} }()
+	defer close(release)
+
+	select {
+	case <-received:
+	case <-time.After(30 * time.Second):
+		t.Fatal("no result received")
+	}
+
+	// the Hub hasn't responded yet, the reload doesn't wait for it
+	newCfg := *ca.deliverySnapshot().cfg
+	newCfg.Interval = 30
+	swapped := make(chan struct{})
+	go func() {
+		ca.swapConfig(&newCfg)
+		close(swapped)
+	}()
+
+	select {
+	case <-swapped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the config reload waits for the Hub request")
+	}
+	assert.Equal(t, 30.0, ca.deliverySnapshot().cfg.Interval)
+}
+
+func TestCagentFlushOutboxIgnoresHubResponse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outbox")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var posted []Result
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result Result
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&result))
+		posted = append(posted, result)
+		_, _ = w.Write([]byte(`{"actions": [{"id": "1", "action": "collect"}], "remote_config": {"version": "1", "toml": "fs_path_exclude = ['/mnt']"}}`))
+	}))
+	defer hub.Close()
+
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+	ca.Config.HubURL = hub.URL
+	ca.Config.HubGzip = false
+	ca.Config.OperationMode = OperationModeFull
+	ca.Config.Outbox.Enabled = true
+	ca.Config.Outbox.DirPath = dir
+	ca.initOutbox()
+	ca.hubActionResults = []HubActionResult{{ID: "0", Action: HubActionCollect, Status: HubActionStatusOK}}
+
+	for i := int64(0); i < 2; i++ {
+		stored := newResult(common.MeasurementsMap{"key": i})
+		stored.Timestamp -= 60 - i
+		assert.NoError(t, ca.outbox.Put(stored.Timestamp, stored))
+	}
+
+	ca.flushOutbox(ca.deliverySnapshot())
+
+	assert.Len(t, posted, 2)
+	assert.Equal(t, 0, ca.outbox.Len())
+	for _, result := range posted {
+		assert.Nil(t, result.ActionResults, "the pending action results are sent with the live result")
+	}
+	assert.Len(t, ca.pendingHubActionResults(), 1, "the actions are not executed for the replayed results")
+	assert.Len(t, ca.collectNow, 0)
+	assert.Equal(t, "", ca.Config.RemoteConfigVersion(), "the remote config is not applied for the replayed results")
+}
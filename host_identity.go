@@ -0,0 +1,58 @@
+package cagent
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/hostid"
+)
+
+// hostUUIDHeader carries the host UUID in the requests to the Hub, including the heartbeat
+const hostUUIDHeader = "X-Cagent-Host-UUID"
+
+func (ca *Cagent) initHostIdentity() {
+	identity, clone, err := hostid.Load(ca.Config.HostIdentity.File, hostid.Current())
+	if err != nil {
+		log.WithError(err).Error("failed to load the host identity")
+	}
+	switch {
+	case clone == nil:
+	case clone.KeptUUID:
+		log.Warnf("all MAC addresses of this host changed since the last start. If it was cloned from host %s with the machine ID, "+
+			"delete %s and restart to get a new host UUID", clone.Of, ca.Config.HostIdentity.File)
+		// the host keeps its UUID, there is no clone to report
+		clone = nil
+	default:
+		log.Warnf("the %s of this host changed since the last start, it was probably cloned from host %s. It reports as host %s from now on",
+			strings.Join(clone.Changed, " and "), clone.Of, identity.UUID)
+	}
+
+	ca.hostIdentity = identity
+	ca.hostClone = clone
+}
+
+// hostUUID returns the UUID of the host, empty if it could not be generated
+func (ca *Cagent) hostUUID() string {
+	if ca.hostIdentity == nil {
+		return ""
+	}
+	return ca.hostIdentity.UUID
+}
+
+// hostIdentityMeasurements reports the host UUID and a clone detected at the start
+func (ca *Cagent) hostIdentityMeasurements() common.MeasurementsMap {
+	measurements := common.MeasurementsMap{}
+	if ca.hostIdentity == nil {
+		return measurements
+	}
+
+	measurements["cagent.host_uuid"] = ca.hostIdentity.UUID
+	if ca.hostClone != nil {
+		measurements["cagent.host_cloned_from"] = ca.hostClone.Of
+		measurements["cagent.host_changed_identifiers"] = ca.hostClone.Changed
+	}
+
+	return measurements
+}
@@ -0,0 +1,28 @@
+package cagent
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCagentHostIdentity(t *testing.T) {
+	var header string
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(hostUUIDHeader)
+	}))
+	defer hub.Close()
+
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+	ca.Config.HubURL = hub.URL
+
+	uuid := ca.hostUUID()
+	assert.Len(t, uuid, 36)
+	assert.Equal(t, uuid, ca.hostIdentityMeasurements()["cagent.host_uuid"])
+
+	assert.NoError(t, ca.sendHeartbeat(ca.deliverySnapshot()))
+	assert.Equal(t, uuid, header)
+}
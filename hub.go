@@ -2,10 +2,11 @@ package cagent
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -21,13 +22,13 @@ import (
 
 func (ca *Cagent) initHubClientOnce() {
 	ca.hubClientOnce.Do(func() {
-		// copy the default transport struct
-		transport := *(http.DefaultTransport.(*http.Transport))
+		// copy the default transport, a plain struct copy would share the connection pool state with it
+		transport := http.DefaultTransport.(*http.Transport).Clone()
 		transport.ResponseHeaderTimeout = 15 * time.Second
 
 		rootCAs, err := common.CustomRootCertPool()
 		if err != nil {
-			if err != common.ErrorCustomRootCertPoolNotImplementedForOS {
+			if err != common.ErrorCustomRootCertPoolNotImplementedForOS && ca.Config.HubTLSCAFile == "" {
 				logrus.Errorf("failed to add root certs: %s", err.Error())
 			}
 		} else if rootCAs != nil {
@@ -59,23 +60,43 @@ func (ca *Cagent) initHubClientOnce() {
 				}
 			}
 		}
+
+		var roundTripper http.RoundTripper = transport
+		if hubTLS := ca.Config.HubTLS(); !hubTLS.IsEmpty() {
+			roundTripper, err = hubTLS.Transport(transport, rootCAs)
+			if err != nil {
+				// validated with the config already
+				logrus.WithError(err).Error("failed to set up TLS for the Hub connection")
+				roundTripper = transport
+			}
+		}
+
 		ca.hubClient = &http.Client{
 			Timeout:   time.Duration(ca.Config.HubRequestTimeout) * time.Second,
-			Transport: &transport,
+			Transport: roundTripper,
 		}
 	})
 }
 
 // validateHubURL performs Hub URL validation, that reference field name as in source config.
-func (ca *Cagent) validateHubURL(fieldHubURL string) error {
-	if len(ca.Config.HubURL) == 0 {
+// The URLs of hub_urls are checked instead if it's set.
+func (cfg *Config) validateHubURL(fieldHubURL string) error {
+	if len(cfg.HubURLs) > 0 {
+		fieldHubURL = "hub_urls"
+	}
+
+	hubURLs := cfg.hubURLs()
+	if len(hubURLs) == 0 {
 		return newEmptyFieldError(fieldHubURL)
-	} else if u, err := url.Parse(ca.Config.HubURL); err != nil {
-		err = errors.WithStack(err)
-		return newFieldError(fieldHubURL, err)
-	} else if u.Scheme != "http" && u.Scheme != "https" {
-		err := errors.Errorf("wrong scheme '%s', URL must start with http:// or https://", u.Scheme)
-		return newFieldError(fieldHubURL, err)
+	}
+	for _, hubURL := range hubURLs {
+		if u, err := url.Parse(hubURL); err != nil {
+			err = errors.WithStack(err)
+			return newFieldError(fieldHubURL, err)
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			err := errors.Errorf("wrong scheme '%s', URL must start with http:// or https://", u.Scheme)
+			return newFieldError(fieldHubURL, err)
+		}
 	}
 	return nil
 }
@@ -88,34 +109,40 @@ func (ca *Cagent) validateHubURL(fieldHubURL string) error {
 // * for TOML: CheckHubCredentials(ctx, "hub_url", "hub_user", "hub_password")
 // * for WinUI: CheckHubCredentials(ctx, "URL", "User", "Password")
 func (ca *Cagent) CheckHubCredentials(ctx context.Context, fieldHubURL, fieldHubUser, fieldHubPassword string) error {
-	ca.initHubClientOnce()
-	err := ca.validateHubURL(fieldHubURL)
+	d := ca.deliverySnapshot()
+	err := d.cfg.validateHubURL(fieldHubURL)
 	if err != nil {
 		return err
 	}
 
-	req, _ := http.NewRequest("HEAD", ca.Config.HubURL, nil)
-	req.Header.Add("User-Agent", ca.userAgent())
-	if len(ca.Config.HubUser) > 0 {
-		req.SetBasicAuth(ca.Config.HubUser, ca.Config.HubPassword)
-	}
-
 	ctx, cancelFn := context.WithTimeout(ctx, time.Minute)
-	req = req.WithContext(ctx)
-	resp, err := ca.hubClient.Do(req)
+	resp, err := d.hub.do(d.client, func(url string) (*http.Request, error) {
+		req, err := http.NewRequest("HEAD", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("User-Agent", ca.userAgent())
+		return req.WithContext(ctx), nil
+	}, ca.recordHubRequest)
 	cancelFn()
-	if err = ca.checkClientError(resp, err, fieldHubUser, fieldHubPassword); err != nil {
+	if err = checkClientError(d.cfg, resp, err, fieldHubUser, fieldHubPassword); err != nil {
 		return errors.WithStack(err)
 	}
 
 	return nil
 }
 
-func (ca *Cagent) checkClientError(resp *http.Response, err error, fieldHubUser, fieldHubPassword string) error {
+const maxHubResponseBodySize = 10 * 1024 * 1024
+
+var errHubConnectionTimeout = errors.New("connection timeout, please check your proxy or firewall settings")
+
+func checkClientError(cfg *Config, resp *http.Response, err error, fieldHubUser, fieldHubPassword string) error {
 	if err != nil {
 		if errors.Cause(err) == context.DeadlineExceeded {
-			err = errors.New("connection timeout, please check your proxy or firewall settings")
-			return err
+			return errHubConnectionTimeout
+		}
+		if tlsErr := hubTLSError(cfg, err); tlsErr != nil {
+			return tlsErr
 		}
 		return err
 	}
@@ -128,9 +155,9 @@ func (ca *Cagent) checkClientError(resp *http.Response, err error, fieldHubUser,
 
 	_ = resp.Body.Close()
 	if resp.StatusCode == http.StatusUnauthorized {
-		if len(ca.Config.HubUser) == 0 {
+		if len(cfg.HubUser) == 0 {
 			return newEmptyFieldError(fieldHubUser)
-		} else if len(ca.Config.HubPassword) == 0 {
+		} else if len(cfg.HubPassword) == 0 {
 			return newEmptyFieldError(fieldHubPassword)
 		}
 		return errors.Errorf("unable to authorize with provided Hub credentials (HTTP %d). %s", resp.StatusCode, responseBody)
@@ -140,6 +167,47 @@ func (ca *Cagent) checkClientError(resp *http.Response, err error, fieldHubUser,
 	return nil
 }
 
+// hubTLSError explains why the TLS handshake with the Hub failed and which options to check, nil if err is not a TLS failure
+func hubTLSError(cfg *Config, err error) error {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	var recordHeader tls.RecordHeaderError
+
+	var hint string
+	switch {
+	case errors.As(err, &unknownAuthority):
+		hint = "the Hub certificate is signed by an unknown authority"
+		if cfg.HubTLSCAFile != "" {
+			hint += ", check that hub_tls_ca_file contains the CA of the Hub"
+		} else {
+			hint += ", set hub_tls_ca_file to the CA of the Hub"
+		}
+	case errors.As(err, &hostnameErr):
+		hint = "the Hub certificate is not valid for the host of hub_url"
+	case errors.As(err, &invalidCert):
+		hint = "the Hub certificate is invalid, e.g. expired"
+	case errors.As(err, &recordHeader):
+		hint = "the Hub didn't respond with TLS, check the scheme and the port of hub_url"
+	case strings.Contains(err.Error(), "hub_tls_"):
+		// failures of the TLS options already name the option
+		hint = ""
+	case strings.Contains(err.Error(), "remote error: tls:"):
+		if cfg.HubTLSClientCert != "" {
+			hint = "the Hub rejected the handshake, check that it accepts hub_tls_client_cert"
+		} else {
+			hint = "the Hub rejected the handshake, it may require a client certificate set in hub_tls_client_cert and hub_tls_client_key"
+		}
+	default:
+		return nil
+	}
+
+	if hint == "" {
+		return errors.Errorf("TLS connection to the Hub failed: %s", err.Error())
+	}
+	return errors.Errorf("TLS connection to the Hub failed: %s: %s", hint, err.Error())
+}
+
 func newEmptyFieldError(name string) error {
 	err := errors.Errorf("unexpected empty field %s", name)
 	return errors.Wrap(err, "the field must be filled with details of your Cloudradar account")
@@ -150,62 +218,144 @@ func newFieldError(name string, err error) error {
 }
 
 func (ca *Cagent) PostResultToHub(ctx context.Context, result *Result) error {
-	ca.initHubClientOnce()
-	err := ca.validateHubURL("hub_url")
+	return ca.postResultToHub(ctx, ca.deliverySnapshot(), result)
+}
+
+func (ca *Cagent) postResultToHub(ctx context.Context, d *delivery, result *Result) error {
+	err := d.cfg.validateHubURL("hub_url")
 	if err != nil {
 		return err
 	}
 
-	b, err := json.Marshal(result)
+	// the additional destinations don't depend on the delivery to the Hub
+	d.forwardResult(result)
+
+	payload, acknowledge := ca.deltaEncodeResult(d, result)
+	actionResults := ca.pendingHubActionResults()
+	if len(actionResults) > 0 {
+		withActionResults := *payload
+		withActionResults.ActionResults = actionResults
+		payload = &withActionResults
+	}
+
+	respBody, err := ca.postPayloadToHub(ctx, d, payload)
 	if err != nil {
-		err = errors.Wrap(err, "failed to serialize result")
 		return err
 	}
 
-	var req *http.Request
-	if ca.Config.HubGzip {
-		buf := new(bytes.Buffer)
-		gzipped := gzip.NewWriter(buf)
-		if _, err := gzipped.Write(b); err != nil {
-			err = errors.Wrap(err, "failed to write into gzipped buffer")
-			return err
-		}
-		if err := gzipped.Close(); err != nil {
-			err = errors.Wrap(err, "failed to finalize gzipped buffer")
-			return err
-		}
-		req, err = http.NewRequest("POST", ca.Config.HubURL, buf)
-		if req != nil {
-			req.Header.Set("Content-Encoding", "gzip")
-		}
-	} else {
-		req, err = http.NewRequest("POST", ca.Config.HubURL, bytes.NewBuffer(b))
-	}
+	acknowledge()
+	ca.acknowledgeHubActionResults(len(actionResults))
+	ca.handleHubResponse(respBody)
+	return nil
+}
+
+// replayResultToHub sends a result stored in the outbox. It's sent in full and as is,
+// the response was meant for the current state and is left to the delivery of the live result
+func (ca *Cagent) replayResultToHub(ctx context.Context, d *delivery, result *Result) error {
+	err := d.cfg.validateHubURL("hub_url")
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Add("User-Agent", ca.userAgent())
-	if len(ca.Config.HubUser) > 0 {
-		req.SetBasicAuth(ca.Config.HubUser, ca.Config.HubPassword)
+
+	_, err = ca.postPayloadToHub(ctx, d, result)
+	return err
+}
+
+// postPayloadToHub posts the payload to the Hub and returns the response body
+func (ca *Cagent) postPayloadToHub(ctx context.Context, d *delivery, payload *Result) ([]byte, error) {
+	body, size, gzippedSize, err := encodeHubPayload(payload, d.cfg.HubGzip)
+	if err != nil {
+		return nil, err
 	}
-	req = req.WithContext(ctx)
-	resp, err := ca.hubClient.Do(req)
 
-	if resp != nil {
-		if resp.StatusCode == http.StatusTooManyRequests {
-			return ErrHubTooManyRequests
+	ca.status.setPayloadSize(size, gzippedSize)
+	resp, err := d.hub.do(d.client, func(url string) (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
 		}
-		if resp.StatusCode == http.StatusUnauthorized {
-			return ErrHubUnauthorized
+		if d.cfg.HubGzip {
+			req.Header.Set("Content-Encoding", "gzip")
 		}
-		if resp.StatusCode >= 500 && resp.StatusCode <= 599 {
-			return ErrHubServerError
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Add("User-Agent", ca.userAgent())
+		return req.WithContext(ctx), nil
+	}, ca.recordHubRequest)
+	respBody := readHubResponseBody(resp)
+
+	if resp != nil {
+		if err := hubResultStatusError(resp); err != nil {
+			return nil, err
 		}
 	}
-	if err = ca.checkClientError(resp, err, "hub_user", "hub_password"); err != nil {
-		return errors.WithStack(err)
+	if err = checkClientError(d.cfg, resp, err, "hub_user", "hub_password"); err != nil {
+		return nil, errors.WithStack(err)
 	}
 
-	return nil
+	return respBody, nil
+}
+
+// recordHubRequest updates the status with a request to the Hub set with hub_url or hub_urls
+func (ca *Cagent) recordHubRequest(_ string, started time.Time, resp *http.Response, err error) {
+	ca.status.setHubRequest(started, resp, err)
+}
+
+// hubResponse is the optional JSON body of the Hub responses to the measurements and the heartbeats
+type hubResponse struct {
+	RemoteConfig *RemoteConfigOverlay `json:"remote_config"`
+	Actions      []HubAction          `json:"actions"`
+	// FullResend is set by the Hub if it misses the snapshot a delta encoded section refers to
+	FullResend bool `json:"full_resend"`
+	// RotateCredentials is set by the Hub to replace the credentials received with -enroll
+	RotateCredentials bool `json:"rotate_credentials"`
+}
+
+// handleHubResponse looks for a remote config overlay and requested actions in the response body of the Hub.
+// It must not be called with reloadLock held, the response is handled with the active config
+func (ca *Cagent) handleHubResponse(body []byte) {
+	if len(body) == 0 {
+		return
+	}
+
+	ca.reloadLock.RLock()
+	defer ca.reloadLock.RUnlock()
+
+	var resp hubResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		// the Hub doesn't always respond with JSON
+		return
+	}
+
+	if resp.FullResend && ca.deltaEncoder != nil {
+		logrus.Info("the Hub requested a full resend of the delta encoded sections")
+		ca.deltaEncoder.Reset()
+	}
+
+	if resp.RotateCredentials {
+		ca.rotateHubCredentials()
+	}
+
+	if resp.RemoteConfig != nil {
+		ca.handleRemoteConfig(resp.RemoteConfig)
+	}
+
+	if len(resp.Actions) > 0 {
+		ca.handleHubActions(resp.Actions)
+	}
+}
+
+// readHubResponseBody reads the response body and puts it back to be read again by checkClientError
+func readHubResponseBody(resp *http.Response) []byte {
+	if resp == nil || resp.Body == nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHubResponseBodySize))
+	_ = resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+
+	return body
 }
@@ -0,0 +1,219 @@
+package cagent
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/securez-one/cagent/pkg/monitoring/updates"
+)
+
+// Actions the Hub can request in the response body
+const (
+	HubActionCollect           = "collect"
+	HubActionResendHWInventory = "resend_hw_inventory"
+	HubActionRefreshUpdates    = "refresh_updates"
+	HubActionUploadLog         = "upload_log"
+)
+
+// Statuses of the executed actions reported back to the Hub
+const (
+	HubActionStatusOK          = "ok"
+	HubActionStatusFailed      = "failed"
+	HubActionStatusRateLimited = "rate_limited"
+	HubActionStatusUnknown     = "unknown"
+)
+
+// HubAction is an action requested by the Hub, e.g.
+//
+//	{"actions": [{"id": "a1", "action": "collect"}, {"id": "a2", "action": "upload_log", "lines": 50}]}
+type HubAction struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	// Lines is the number of the last log lines to upload, limited by hub_actions.max_log_lines
+	Lines int `json:"lines,omitempty"`
+}
+
+// HubActionResult acknowledges an action to the Hub
+type HubActionResult struct {
+	ID      string   `json:"id"`
+	Action  string   `json:"action"`
+	Status  string   `json:"status"`
+	Message string   `json:"message,omitempty"`
+	Output  []string `json:"output,omitempty"`
+}
+
+// handleHubActions executes the actions. The results are sent to the Hub with the next measurements or heartbeat
+func (ca *Cagent) handleHubActions(actions []HubAction) {
+	if !ca.Config.HubActions.Enabled {
+		log.Debugf("ignoring %d actions requested by the Hub, hub_actions are disabled", len(actions))
+		return
+	}
+
+	for _, action := range actions {
+		result := ca.runHubAction(action)
+		log.Infof("action %s (%s) requested by the Hub: %s %s", action.Action, action.ID, result.Status, result.Message)
+
+		ca.hubActionsLock.Lock()
+		ca.hubActionResults = append(ca.hubActionResults, result)
+		ca.hubActionsLock.Unlock()
+	}
+}
+
+// pendingHubActionResults returns the results not acknowledged by the Hub yet
+func (ca *Cagent) pendingHubActionResults() []HubActionResult {
+	ca.hubActionsLock.Lock()
+	defer ca.hubActionsLock.Unlock()
+
+	return ca.hubActionResults[:len(ca.hubActionResults):len(ca.hubActionResults)]
+}
+
+// acknowledgeHubActionResults drops the first n results, the Hub accepted them.
+// Results of actions executed in the meantime are kept
+func (ca *Cagent) acknowledgeHubActionResults(n int) {
+	ca.hubActionsLock.Lock()
+	defer ca.hubActionsLock.Unlock()
+
+	ca.hubActionResults = append([]HubActionResult(nil), ca.hubActionResults[n:]...)
+}
+
+func (ca *Cagent) runHubAction(action HubAction) HubActionResult {
+	result := HubActionResult{ID: action.ID, Action: action.Action, Status: HubActionStatusOK}
+
+	var err error
+	switch action.Action {
+	case HubActionCollect, HubActionResendHWInventory, HubActionRefreshUpdates, HubActionUploadLog:
+	default:
+		result.Status = HubActionStatusUnknown
+		return result
+	}
+
+	if ca.Config.OperationMode == OperationModeHeartbeat && (action.Action == HubActionCollect || action.Action == HubActionResendHWInventory) {
+		result.Status = HubActionStatusFailed
+		result.Message = "no measurements are collected in the heartbeat operation mode"
+		return result
+	}
+
+	if !ca.allowHubAction(action.Action) {
+		result.Status = HubActionStatusRateLimited
+		result.Message = fmt.Sprintf("runs at most once per %.0fs", ca.Config.HubActions.MinInterval)
+		return result
+	}
+
+	switch action.Action {
+	case HubActionCollect:
+		ca.triggerCollection()
+	case HubActionResendHWInventory:
+		if !ca.Config.HardwareInventory {
+			err = errors.New("hardware_inventory is disabled")
+			break
+		}
+		ca.expireCollectorCache(CollectorHWInventory)
+		if ca.deltaEncoder != nil {
+			// otherwise the unchanged inventory would be sent as an empty delta
+			ca.deltaEncoder.ResetSection("hw.inventory")
+		}
+		ca.triggerCollection()
+	case HubActionRefreshUpdates:
+		if !ca.Config.SystemUpdatesChecks.Enabled {
+			err = errors.New("system_updates_checks are disabled")
+		} else if !updates.Refresh() {
+			err = errors.New("the updates check is not running yet")
+		}
+	case HubActionUploadLog:
+		if !ca.Config.HubActions.UploadLog {
+			err = errors.New("upload_log is disabled")
+			break
+		}
+		result.Output, err = ca.tailLog(action.Lines)
+	}
+
+	if err != nil {
+		result.Status = HubActionStatusFailed
+		result.Message = err.Error()
+	}
+
+	return result
+}
+
+// allowHubAction applies the rate limit of hub_actions.min_interval per action
+func (ca *Cagent) allowHubAction(action string) bool {
+	ca.hubActionsLock.Lock()
+	defer ca.hubActionsLock.Unlock()
+
+	if last, exists := ca.hubActionsLastRun[action]; exists && time.Since(last) < secToDuration(ca.Config.HubActions.MinInterval) {
+		return false
+	}
+
+	ca.hubActionsLastRun[action] = time.Now()
+	return true
+}
+
+// triggerCollection wakes up the Run loop to collect and send the measurements immediately
+func (ca *Cagent) triggerCollection() {
+	select {
+	case ca.collectNow <- struct{}{}:
+	default:
+		// a collection is already pending
+	}
+}
+
+// tailLog returns the last lines of the log file, at most hub_actions.max_log_lines
+func (ca *Cagent) tailLog(lines int) ([]string, error) {
+	if ca.Config.LogFile == "" {
+		return nil, errors.New("logging to a file is disabled")
+	}
+
+	if lines <= 0 || lines > ca.Config.HubActions.MaxLogLines {
+		lines = ca.Config.HubActions.MaxLogLines
+	}
+
+	f, err := os.Open(ca.Config.LogFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return tailLines(f, lines)
+}
+
+// tailLines reads the file backwards in blocks until it has found the requested number of lines
+func tailLines(f *os.File, lines int) ([]string, error) {
+	const blockSize = 4096
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	offset := info.Size()
+	for offset > 0 && bytes.Count(data, []byte("\n")) <= lines {
+		size := int64(blockSize)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+
+		block := make([]byte, size)
+		if _, err = f.ReadAt(block, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		data = append(block, data...)
+	}
+
+	all := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	if len(all) == 1 && all[0] == "" {
+		return []string{}, nil
+	}
+	return all, nil
+}
@@ -0,0 +1,61 @@
+package cagent
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/delta"
+)
+
+const deltaMeasurementsKey = "cagent.delta"
+
+// deltaEncodeResult returns the result to send to the Hub with the sections of delta_encoding replaced by their changes,
+// and the function to call when the Hub accepted it. The original result is not modified, the other sinks get it as is
+func (ca *Cagent) deltaEncodeResult(d *delivery, result *Result) (*Result, func()) {
+	encoder := d.deltaEncoder
+	if encoder == nil {
+		return result, func() {}
+	}
+
+	measurements := make(common.MeasurementsMap, len(result.Measurements)+1)
+	for k, v := range result.Measurements {
+		measurements[k] = v
+	}
+
+	now := time.Now()
+	sections := map[string]*delta.Section{}
+	var acknowledges []func()
+	for _, name := range d.cfg.DeltaEncoding.Sections {
+		value, exists := measurements[name]
+		if !exists {
+			continue
+		}
+
+		section, acknowledge, err := encoder.Encode(name, value, now)
+		if err != nil {
+			logrus.WithError(err).Warnf("failed to delta encode %s, sending it in full", name)
+			continue
+		}
+
+		if !section.Full {
+			delete(measurements, name)
+		}
+		sections[name] = section
+		acknowledges = append(acknowledges, acknowledge)
+	}
+
+	if len(sections) == 0 {
+		return result, func() {}
+	}
+	measurements[deltaMeasurementsKey] = sections
+
+	encoded := *result
+	encoded.Measurements = measurements
+	return &encoded, func() {
+		for _, acknowledge := range acknowledges {
+			acknowledge()
+		}
+	}
+}
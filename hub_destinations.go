@@ -0,0 +1,541 @@
+package cagent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/securez-one/cagent/pkg/backoff"
+	"github.com/securez-one/cagent/pkg/outbox"
+)
+
+// hubDestinationName is the name of the Hub set with hub_url or hub_urls in the logs
+const hubDestinationName = "hub"
+
+// hubDestinationsOutboxDir is the subdirectory of the outbox the results wait in for the [[hub_destinations]]
+const hubDestinationsOutboxDir = "hub_destinations"
+
+// hubDestination is a Hub the requests are sent to. If it has several URLs, the next one is tried when a URL fails
+type hubDestination struct {
+	name     string
+	urls     []string
+	user     string
+	password string
+	// hostUUID is sent with every request to tell cloned hosts sharing the credentials apart
+	hostUUID string
+
+	mu sync.Mutex
+	// active is the index of the URL that answered last, it is tried first
+	active int
+}
+
+func newHubDestination(name string, urls []string, user, password, hostUUID string) *hubDestination {
+	return &hubDestination{name: name, urls: urls, user: user, password: password, hostUUID: hostUUID}
+}
+
+// activeURL returns the URL the next request is sent to first
+func (d *hubDestination) activeURL() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.urls) == 0 {
+		return ""
+	}
+	return d.urls[d.active]
+}
+
+// do sends the request created by newRequest for the active URL. On network errors and 5xx responses
+// the request is sent to the next URLs in order, the first one that answers stays active for the next requests.
+// record is called after each attempt
+func (d *hubDestination) do(client *http.Client, newRequest func(url string) (*http.Request, error), record func(url string, started time.Time, resp *http.Response, err error)) (*http.Response, error) {
+	d.mu.Lock()
+	first := d.active
+	d.mu.Unlock()
+
+	if len(d.urls) == 0 {
+		return nil, fmt.Errorf("%s: no URL set", d.name)
+	}
+
+	for i := 0; ; i++ {
+		index := (first + i) % len(d.urls)
+		req, err := newRequest(d.urls[index])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if len(d.user) > 0 {
+			req.SetBasicAuth(d.user, d.password)
+		}
+		if d.hostUUID != "" {
+			req.Header.Set(hostUUIDHeader, d.hostUUID)
+		}
+
+		started := time.Now()
+		resp, err := client.Do(req)
+		if record != nil {
+			record(d.urls[index], started, resp, err)
+		}
+
+		failed := req.Context().Err() == nil && (err != nil || resp.StatusCode >= 500)
+		if !failed || i == len(d.urls)-1 {
+			if !failed {
+				d.mu.Lock()
+				d.active = index
+				d.mu.Unlock()
+			}
+			return resp, err
+		}
+
+		next := d.urls[(index+1)%len(d.urls)]
+		if err != nil {
+			log.WithError(err).Warnf("%s: %s failed, failing over to %s", d.name, d.urls[index], next)
+		} else {
+			log.Warnf("%s: %s responded with %s, failing over to %s", d.name, d.urls[index], resp.Status, next)
+			resp.Body.Close()
+		}
+	}
+}
+
+// encodeHubPayload serializes the payload and compresses it if hub_gzip is enabled, gzippedSize is 0 otherwise
+func encodeHubPayload(payload interface{}, gzipped bool) (body []byte, size int, gzippedSize int, err error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, 0, 0, errors.Wrap(err, "failed to serialize result")
+	}
+
+	if !gzipped {
+		return b, len(b), 0, nil
+	}
+
+	buf := new(bytes.Buffer)
+	zw := gzip.NewWriter(buf)
+	if _, err := zw.Write(b); err != nil {
+		return nil, 0, 0, errors.Wrap(err, "failed to write into gzipped buffer")
+	}
+	if err := zw.Close(); err != nil {
+		return nil, 0, 0, errors.Wrap(err, "failed to finalize gzipped buffer")
+	}
+
+	return buf.Bytes(), len(b), buf.Len(), nil
+}
+
+// hubResultStatusError maps the status codes the delivery loops react to onto the ErrHub* errors.
+// The Retry-After header of the response is kept with the error, use errors.Cause to compare it
+func hubResultStatusError(resp *http.Response) error {
+	var err error
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		err = ErrHubTooManyRequests
+	case resp.StatusCode == http.StatusUnauthorized:
+		err = ErrHubUnauthorized
+	case resp.StatusCode >= 500 && resp.StatusCode <= 599:
+		err = ErrHubServerError
+	}
+
+	return backoff.WithRetryAfter(err, resp)
+}
+
+// matches returns true if the destination was set up with the URLs and the credentials
+func (d *hubDestination) matches(urls []string, user, password string) bool {
+	if len(d.urls) != len(urls) || d.user != user || d.password != password {
+		return false
+	}
+	for i := range urls {
+		if d.urls[i] != urls[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hub returns the Hub set with hub_url or hub_urls. It's set up again if the settings were changed, e.g. in the settings UI
+func (ca *Cagent) hub() *hubDestination {
+	ca.hubPrimaryLock.Lock()
+	defer ca.hubPrimaryLock.Unlock()
+
+	urls := ca.Config.hubURLs()
+	if ca.hubPrimary == nil || !ca.hubPrimary.matches(urls, ca.Config.HubUser, ca.Config.HubPassword) {
+		ca.hubPrimary = newHubDestination(hubDestinationName, urls, ca.Config.HubUser, ca.Config.HubPassword, ca.hostUUID())
+	}
+
+	return ca.hubPrimary
+}
+
+// initHubDestinations starts delivering to the [[hub_destinations]]
+func (ca *Cagent) initHubDestinations() {
+	ca.stopHubDestinations()
+	if len(ca.Config.HubDestinations) == 0 {
+		return
+	}
+
+	ca.initHubClientOnce()
+	settings := hubForwarderSettings{
+		client:           ca.hubClient,
+		userAgent:        ca.userAgent(),
+		gzip:             ca.Config.HubGzip,
+		timeout:          time.Duration(ca.Config.HubRequestTimeout) * time.Second,
+		retryInterval:    secToDuration(ca.Config.OnHTTP5xxRetryInterval),
+		maxRetryInterval: secToDuration(ca.Config.Interval),
+	}
+	if ca.Config.Outbox.Enabled {
+		settings.outboxDir = filepath.Join(ca.Config.Outbox.DirPath, hubDestinationsOutboxDir)
+		settings.outboxLimits = ca.Config.Outbox.limits()
+	}
+	for _, d := range ca.Config.HubDestinations {
+		dest := newHubDestination(d.Name, d.urls(), d.User, d.Password, ca.hostUUID())
+		ca.hubForwarders = append(ca.hubForwarders, newHubForwarder(dest, settings, &ca.status))
+	}
+}
+
+func (ca *Cagent) stopHubDestinations() {
+	for _, f := range ca.hubForwarders {
+		f.shutdown()
+	}
+	ca.hubForwarders = nil
+	ca.status.clearHubDestinations()
+}
+
+// forwardResult hands the result over to the [[hub_destinations]]
+func (d *delivery) forwardResult(result *Result) {
+	for _, f := range d.forwarders {
+		f.offer(result)
+	}
+}
+
+// hubForwarderMaxQueued limits the results a destination keeps in memory while the outbox is disabled
+const hubForwarderMaxQueued = 100
+
+// hubForwarderSettings are taken from the config the forwarder was started with, it doesn't hold the reload lock
+type hubForwarderSettings struct {
+	client           *http.Client
+	userAgent        string
+	gzip             bool
+	timeout          time.Duration
+	retryInterval    time.Duration
+	maxRetryInterval time.Duration
+	// outboxDir is set if the outbox is enabled, the results of each destination wait in a subdirectory named after it
+	outboxDir    string
+	outboxLimits outbox.Limits
+}
+
+// hubForwarder delivers the results to a [[hub_destinations]] entry in the background with its own retries.
+// The results wait for the delivery in order, in the outbox if it's enabled and in memory otherwise.
+// The oldest results are dropped once the limits of the outbox or hubForwarderMaxQueued are exceeded
+type hubForwarder struct {
+	dest     *hubDestination
+	settings hubForwarderSettings
+	status   *agentStatus
+
+	mu    sync.Mutex
+	queue hubForwarderQueue
+	// lastOffered is the last result handed over, the retries of the Hub offer the same result again
+	lastOffered *Result
+
+	// wake is signaled when a result is queued
+	wake    chan struct{}
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+func newHubForwarder(dest *hubDestination, settings hubForwarderSettings, status *agentStatus) *hubForwarder {
+	f := &hubForwarder{
+		dest:     dest,
+		settings: settings,
+		status:   status,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if settings.outboxDir != "" {
+		box := outbox.New(filepath.Join(settings.outboxDir, dest.name), settings.outboxLimits, log.StandardLogger())
+		f.queue = &outboxForwarderQueue{name: dest.name, box: box}
+		// the results left from before a restart or a reload are delivered first
+		f.signal()
+	} else {
+		f.queue = &memoryForwarderQueue{max: hubForwarderMaxQueued}
+	}
+	status.initHubDestination(dest.name, dest.activeURL())
+	go f.run()
+
+	return f
+}
+
+func (f *hubForwarder) offer(result *Result) {
+	f.mu.Lock()
+	if result == f.lastOffered {
+		f.mu.Unlock()
+		return
+	}
+	f.lastOffered = result
+	dropped := f.queue.push(result)
+	f.mu.Unlock()
+
+	if dropped > 0 {
+		log.Warnf("%s: too many results wait for the delivery, dropped %d oldest", f.dest.name, dropped)
+	}
+	for i := 0; i < dropped; i++ {
+		f.status.addHubDestinationDropped(f.dest.name)
+	}
+	f.signal()
+}
+
+func (f *hubForwarder) signal() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (f *hubForwarder) next() *Result {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.queue.next()
+}
+
+func (f *hubForwarder) remove(result *Result) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.queue.remove(result)
+}
+
+// shutdown stops the retries and waits for a request in progress.
+// The results offered right before are still sent once, e.g. with -r
+func (f *hubForwarder) shutdown() {
+	close(f.stop)
+	<-f.stopped
+}
+
+func (f *hubForwarder) run() {
+	defer close(f.stopped)
+
+	for {
+		select {
+		case <-f.stop:
+			f.flush()
+			return
+		case <-f.wake:
+			if !f.deliverQueued() {
+				return
+			}
+		}
+	}
+}
+
+// deliverQueued delivers the queued results from the oldest one, it returns false if the forwarder was stopped meanwhile
+func (f *hubForwarder) deliverQueued() bool {
+	for result := f.next(); result != nil; result = f.next() {
+		if !f.deliver(result) {
+			return false
+		}
+		f.remove(result)
+	}
+
+	return true
+}
+
+// flush sends each queued result once until a request fails
+func (f *hubForwarder) flush() {
+	for result := f.next(); result != nil; result = f.next() {
+		if err := f.send(result); err != nil {
+			log.WithError(err).Warnf("%s: delivery failed on shutdown", f.dest.name)
+			return
+		}
+		f.remove(result)
+	}
+}
+
+// deliver sends the result until it succeeds or the destination refuses it, the results queued meanwhile wait.
+// It returns false if the forwarder was stopped while waiting for the next attempt
+func (f *hubForwarder) deliver(result *Result) bool {
+	for attempt := 1; ; attempt++ {
+		err := f.send(result)
+		if err == nil {
+			return true
+		}
+
+		if errors.Cause(err) != ErrHubTooManyRequests && !isHubUnreachable(err) {
+			log.WithError(err).Errorf("%s: the result was refused, dropping it", f.dest.name)
+			f.status.addHubDestinationDropped(f.dest.name)
+			return true
+		}
+
+		retryIn := f.retryIn(attempt, err)
+		f.status.setHubDestinationRetry(f.dest.name, retryIn)
+		log.WithError(err).Infof("%s: delivery failed, retrying in %v", f.dest.name, retryIn)
+
+		timer := time.NewTimer(retryIn)
+		select {
+		case <-f.stop:
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+// retryIn returns the Retry-After of the destination or a jittered delay that grows with each failed attempt up to the collection interval
+func (f *hubForwarder) retryIn(attempt int, err error) time.Duration {
+	policy := backoff.Policy{Base: f.settings.retryInterval, Max: f.settings.maxRetryInterval}
+	if errors.Cause(err) == ErrHubTooManyRequests {
+		policy.Base = tooManyRequestsBackoffBase
+	}
+	if policy.Base <= 0 {
+		policy.Base = time.Second
+	}
+
+	return policy.Next(attempt-1, err)
+}
+
+// send posts the result in full, the delta encoding and the responses only apply to the Hub set with hub_url
+func (f *hubForwarder) send(result *Result) error {
+	body, _, _, err := encodeHubPayload(result, f.settings.gzip)
+	if err != nil {
+		return err
+	}
+
+	// a request in progress is not cancelled by the shutdown, it's limited by hub_request_timeout
+	ctx, cancelFn := context.WithTimeout(context.Background(), f.settings.timeout)
+	defer cancelFn()
+
+	resp, err := f.dest.do(f.settings.client, func(url string) (*http.Request, error) {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Add("User-Agent", f.settings.userAgent)
+		if f.settings.gzip {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		return req.WithContext(ctx), nil
+	}, func(url string, started time.Time, resp *http.Response, err error) {
+		f.status.setHubDestinationRequest(f.dest.name, url, started, resp, err)
+	})
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return errHubConnectionTimeout
+		}
+		return errors.WithStack(err)
+	}
+	readHubResponseBody(resp)
+
+	if err := hubResultStatusError(resp); err != nil {
+		return err
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return errors.Errorf("%s responded with %s", f.dest.name, resp.Status)
+	}
+
+	f.status.addHubDestinationDelivered(f.dest.name)
+	return nil
+}
+
+// hubForwarderQueue keeps the results waiting for the delivery to a destination in order
+type hubForwarderQueue interface {
+	// push adds the result and returns the number of the oldest results dropped to stay within the limits
+	push(result *Result) (dropped int)
+	// next returns the oldest result, nil if the queue is empty
+	next() *Result
+	// remove removes the result returned by next, it's not an error if it was dropped meanwhile
+	remove(result *Result)
+}
+
+// memoryForwarderQueue keeps up to max results in memory, they are lost on a restart
+type memoryForwarderQueue struct {
+	max     int
+	results []*Result
+}
+
+func (q *memoryForwarderQueue) push(result *Result) int {
+	q.results = append(q.results, result)
+	dropped := len(q.results) - q.max
+	if dropped <= 0 {
+		return 0
+	}
+
+	q.results = q.results[dropped:]
+	return dropped
+}
+
+func (q *memoryForwarderQueue) next() *Result {
+	if len(q.results) == 0 {
+		return nil
+	}
+	return q.results[0]
+}
+
+func (q *memoryForwarderQueue) remove(result *Result) {
+	if len(q.results) > 0 && q.results[0] == result {
+		q.results[0] = nil
+		q.results = q.results[1:]
+	}
+}
+
+// outboxForwarderQueue keeps the results in an outbox of the destination, they survive restarts and reloads
+type outboxForwarderQueue struct {
+	name string
+	box  *outbox.Outbox
+
+	// head is the entry of the result returned by next
+	head       outbox.Entry
+	headResult *Result
+}
+
+func (q *outboxForwarderQueue) push(result *Result) int {
+	before := q.box.Len()
+	if err := q.box.Put(result.Timestamp, result); err != nil {
+		log.WithError(err).Errorf("%s: failed to store the result in the outbox, dropping it", q.name)
+		return 1
+	}
+
+	if dropped := before + 1 - q.box.Len(); dropped > 0 {
+		return dropped
+	}
+	return 0
+}
+
+func (q *outboxForwarderQueue) next() *Result {
+	q.headResult = nil
+
+	entries, err := q.box.Entries()
+	if err != nil {
+		log.WithError(err).Errorf("%s: failed to list the outbox entries", q.name)
+		return nil
+	}
+
+	for _, e := range entries {
+		var result Result
+		if err := q.box.Read(e, &result); err != nil {
+			log.WithError(err).Errorf("%s: dropping unreadable outbox entry", q.name)
+			_ = q.box.Remove(e)
+			continue
+		}
+
+		q.head = e
+		q.headResult = &result
+		return q.headResult
+	}
+
+	return nil
+}
+
+func (q *outboxForwarderQueue) remove(result *Result) {
+	if result != q.headResult {
+		return
+	}
+
+	if err := q.box.Remove(q.head); err != nil {
+		log.WithError(err).Errorf("%s: failed to remove the delivered outbox entry", q.name)
+	}
+	q.headResult = nil
+}
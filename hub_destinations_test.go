@@ -0,0 +1,158 @@
+package cagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCagentHubURLsFailover(t *testing.T) {
+	var primaryRequests, secondaryRequests int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryRequests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&secondaryRequests, 1)
+	}))
+	defer secondary.Close()
+
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+	ca.Config.HubURLs = []string{primary.URL, secondary.URL}
+
+	result := newResult(map[string]interface{}{"key": 1})
+	assert.NoError(t, ca.PostResultToHub(context.Background(), result))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&primaryRequests))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&secondaryRequests))
+
+	// the URL that answered is used until it fails
+	assert.NoError(t, ca.sendHeartbeat(ca.deliverySnapshot()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&primaryRequests))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&secondaryRequests))
+	assert.Equal(t, secondary.URL, ca.hub().activeURL())
+
+	// all URLs failing is reported as usual
+	secondary.Close()
+	assert.Equal(t, ErrHubServerError, ca.sendHeartbeat(ca.deliverySnapshot()))
+}
+
+func TestCagentHubDestinations(t *testing.T) {
+	var hubRequests int32
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hubRequests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer hub.Close()
+
+	var destRequests int32
+	received := make(chan string, 10)
+	dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&destRequests, 1) == 1 {
+			// the first attempt fails and is retried
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		user, _, _ := r.BasicAuth()
+		received <- user
+	}))
+	defer dest.Close()
+
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+	ca.Config.HubURL = hub.URL
+	ca.Config.HubDestinations = []HubDestinationConfig{{Name: "tenant", URL: dest.URL, User: "tenant-user"}}
+	ca.Config.OnHTTP5xxRetryInterval = 0.01
+	ca.initHubDestinations()
+
+	result := newResult(map[string]interface{}{"key": 1})
+	// the Hub failing doesn't stop the delivery to the other destinations
+	assert.Equal(t, ErrHubServerError, ca.PostResultToHub(context.Background(), result))
+	// the retry of the Hub doesn't send the result twice
+	assert.Equal(t, ErrHubServerError, ca.PostResultToHub(context.Background(), result))
+
+	select {
+	case user := <-received:
+		assert.Equal(t, "tenant-user", user)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the result was not delivered")
+	}
+
+	for i := 0; i < 100 && ca.Status().HubDestinations["tenant"].Delivered == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	m := ca.selfTelemetry(nil)
+	assert.Equal(t, 0, m["cagent.self.hub.success"])
+	assert.Equal(t, 1, m["cagent.self.hub_destinations.tenant.success"])
+	assert.Equal(t, int64(1), m["cagent.self.hub_destinations.tenant.delivered"])
+	assert.Equal(t, dest.URL, m["cagent.self.hub_destinations.tenant.url"])
+
+	ca.stopHubDestinations()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&hubRequests))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&destRequests))
+}
+
+func TestCagentHubDestinationDownForSeveralResults(t *testing.T) {
+	for _, outboxEnabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("outbox %v", outboxEnabled), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "outbox")
+			assert.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			defer hub.Close()
+
+			var up int32
+			received := make(chan float64, 10)
+			dest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.LoadInt32(&up) == 0 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				var result Result
+				_ = json.NewDecoder(r.Body).Decode(&result)
+				received <- result.Measurements["seq"].(float64)
+			}))
+			defer dest.Close()
+
+			ca := helperCreateCagent(t)
+			defer ca.Shutdown()
+			ca.Config.HubURL = hub.URL
+			ca.Config.HubGzip = false
+			ca.Config.HubDestinations = []HubDestinationConfig{{Name: "tenant", URL: dest.URL}}
+			ca.Config.OnHTTP5xxRetryInterval = 0.01
+			ca.Config.Outbox.Enabled = outboxEnabled
+			ca.Config.Outbox.DirPath = dir
+			ca.initHubDestinations()
+
+			// the destination is down while several results are collected, none of them is dropped
+			for seq := 1; seq <= 3; seq++ {
+				assert.NoError(t, ca.PostResultToHub(context.Background(), newResult(map[string]interface{}{"seq": seq})))
+			}
+			time.Sleep(50 * time.Millisecond)
+			atomic.StoreInt32(&up, 1)
+
+			for _, expected := range []float64{1, 2, 3} {
+				select {
+				case seq := <-received:
+					assert.Equal(t, expected, seq)
+				case <-time.After(5 * time.Second):
+					t.Fatal("the result was not delivered")
+				}
+			}
+
+			ca.stopHubDestinations()
+			assert.Equal(t, int64(0), ca.Status().HubDestinations["tenant"].Dropped)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package cagent
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/securez-one/cagent/pkg/backoff"
+)
+
+const (
+	tooManyRequestsBackoffBase = 10 * time.Second
+	unauthorizedBackoffBase    = 30 * time.Second
+	unauthorizedBackoffMax     = time.Hour
+)
+
+// hubRetry decides when Run and RunHeartbeat contact the Hub again after a failed request.
+// The delays follow the Retry-After header of the Hub or grow exponentially with full jitter
+type hubRetry struct {
+	// retries counts the retries of the same payload after 5xx errors
+	retries int
+	// failures counts the consecutive failed requests, the delays grow with it
+	failures   int
+	firstRetry time.Time
+}
+
+// next returns the delay before the next request, interval is the regular interval of the loop.
+// gaveUp is true if the retries of the payload after 5xx errors are exhausted
+func (r *hubRetry) next(err error, cfg *Config, interval time.Duration) (retryIn time.Duration, gaveUp bool) {
+	if err == nil {
+		r.retries = 0
+		r.failures = 0
+		return interval, false
+	}
+
+	attempt := r.failures
+	r.failures++
+
+	switch errors.Cause(err) {
+	case ErrHubTooManyRequests:
+		return backoff.Policy{Base: tooManyRequestsBackoffBase, Max: interval}.Next(attempt, err), false
+	case ErrHubUnauthorized:
+		r.retries = 0
+		base := unauthorizedBackoffBase
+		if sleep := secToDuration(cfg.Sleep); sleep > base {
+			base = sleep
+		}
+		return backoff.Policy{Base: base, Max: unauthorizedBackoffMax}.Next(attempt, err), false
+	case ErrHubServerError:
+		if r.retries == 0 {
+			r.firstRetry = time.Now()
+		}
+		r.retries++
+		if r.retries <= cfg.OnHTTP5xxRetries {
+			return backoff.Policy{Base: secToDuration(cfg.OnHTTP5xxRetryInterval), Max: interval}.Next(r.retries-1, err), false
+		}
+
+		// the next regular run, unless the Hub asked to wait longer
+		r.retries = 0
+		retryIn = interval - time.Since(r.firstRetry)
+		if retryAfter, ok := backoff.RetryAfterOf(err); ok && retryAfter > retryIn {
+			retryIn = retryAfter
+		}
+		if retryIn < 0 {
+			retryIn = 0
+		}
+		return retryIn, true
+	}
+
+	r.retries = 0
+	return interval, false
+}
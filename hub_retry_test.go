@@ -0,0 +1,51 @@
+package cagent
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/securez-one/cagent/pkg/backoff"
+)
+
+func TestHubRetry(t *testing.T) {
+	cfg := NewConfig()
+	cfg.OnHTTP5xxRetries = 2
+	cfg.OnHTTP5xxRetryInterval = 1
+	interval := time.Minute
+
+	var retry hubRetry
+	for i := 1; i <= cfg.OnHTTP5xxRetries; i++ {
+		retryIn, gaveUp := retry.next(ErrHubServerError, cfg, interval)
+		assert.False(t, gaveUp)
+		assert.Equal(t, i, retry.retries)
+		assert.True(t, retryIn >= 0 && retryIn < time.Duration(i)*time.Second)
+	}
+	retryIn, gaveUp := retry.next(ErrHubServerError, cfg, interval)
+	assert.True(t, gaveUp)
+	assert.Equal(t, 0, retry.retries)
+	assert.True(t, retryIn > interval-time.Second && retryIn <= interval)
+
+	// the Retry-After of the Hub takes precedence
+	err := &backoff.RetryAfterError{Err: ErrHubTooManyRequests, RetryAfter: 42 * time.Second}
+	retryIn, _ = retry.next(err, cfg, interval)
+	assert.Equal(t, 42*time.Second, retryIn)
+
+	// Retry-After: 0 doesn't retry back-to-back
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"0"}}}
+	retryIn, _ = retry.next(backoff.WithRetryAfter(ErrHubTooManyRequests, resp), cfg, interval)
+	assert.Equal(t, backoff.MinRetryAfter, retryIn)
+
+	for i := 0; i < 10; i++ {
+		retryIn, _ = retry.next(ErrHubUnauthorized, cfg, interval)
+		assert.True(t, retryIn < unauthorizedBackoffMax)
+	}
+	assert.Equal(t, 15, retry.failures)
+
+	retryIn, gaveUp = retry.next(nil, cfg, interval)
+	assert.False(t, gaveUp)
+	assert.Equal(t, interval, retryIn)
+	assert.Equal(t, 0, retry.failures)
+}
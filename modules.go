@@ -13,9 +13,10 @@ import (
 
 var modules []monitoring.Module
 
-func (ca *Cagent) initModules() {
+// initModules returns the enabled modules, they are created on the first call after the start or a config reload
+func (ca *Cagent) initModules() []monitoring.Module {
 	if len(modules) > 0 {
-		return
+		return modules
 	}
 
 	l := []func() monitoring.Module{
@@ -36,14 +37,13 @@ func (ca *Cagent) initModules() {
 			modules = append(modules, m)
 		}
 	}
+	return modules
 }
 
-func (ca *Cagent) collectModulesMeasurements() ([]*monitoring.ModuleReport, error) {
+func collectModulesMeasurements(modules []monitoring.Module) ([]*monitoring.ModuleReport, error) {
 	var result []*monitoring.ModuleReport
 	var errs common.ErrorCollector
 
-	ca.initModules()
-
 	for _, m := range modules {
 		reports, err := m.Run()
 		if err != nil {
@@ -55,5 +55,7 @@ func (ca *Cagent) collectModulesMeasurements() ([]*monitoring.ModuleReport, erro
 		result = append(result, reports...)
 	}
 
+	emitModuleEvents(result)
+
 	return result, errors.Wrap(errs.Combine(), "while collecting modules measurements")
 }
@@ -0,0 +1,92 @@
+package cagent
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/jobmon"
+	"github.com/securez-one/cagent/pkg/monitoring"
+	"github.com/securez-one/cagent/pkg/notifier"
+	"github.com/securez-one/cagent/pkg/rules"
+)
+
+// scopes of the alerts synced with the webhook notifier, alerts missing in a new collection of the scope are resolved
+const (
+	notifierScopeModules = "modules"
+	notifierScopeRules   = "rules"
+)
+
+func (ca *Cagent) initWebhookNotifier() {
+	ca.stopWebhookNotifier()
+	cfg := ca.Config.WebhookNotifier
+	if !cfg.Enabled {
+		return
+	}
+
+	webhook, err := notifier.NewWebhook(notifier.Config{
+		URL:           cfg.URL,
+		Method:        cfg.Method,
+		Headers:       cfg.Headers,
+		BodyTemplate:  cfg.BodyTemplate,
+		Timeout:       secToDuration(cfg.Timeout),
+		Retries:       cfg.Retries,
+		RetryInterval: secToDuration(cfg.RetryInterval),
+	}, log.StandardLogger())
+	if err != nil {
+		log.WithError(err).Error("failed to init the webhook notifier")
+		return
+	}
+	ca.webhookNotifier = webhook
+}
+
+func (ca *Cagent) stopWebhookNotifier() {
+	if ca.webhookNotifier != nil {
+		ca.webhookNotifier.Shutdown()
+		ca.webhookNotifier = nil
+	}
+}
+
+// notifyWebhook sends the changes of the local alerts to the webhook.
+// modulesCollected is false if the measurements lack the module reports, their alerts are kept active then
+func (ca *Cagent) notifyWebhook(measurements common.MeasurementsMap, modulesCollected bool) {
+	if ca.webhookNotifier == nil {
+		return
+	}
+
+	if modulesCollected {
+		var alerts []notifier.Alert
+		reports, _ := measurements["modules"].([]*monitoring.ModuleReport)
+		for _, r := range reports {
+			if r.Name == rules.ReportName {
+				continue
+			}
+			for _, alert := range r.Alerts {
+				alerts = append(alerts, notifier.Alert{Key: r.Name + "|" + string(alert), Source: r.Name, Severity: "alert", Message: string(alert)})
+			}
+			for _, warning := range r.Warnings {
+				alerts = append(alerts, notifier.Alert{Key: r.Name + "|" + string(warning), Source: r.Name, Severity: "warning", Message: string(warning)})
+			}
+		}
+		ca.webhookNotifier.Sync(notifierScopeModules, alerts)
+	}
+
+	var ruleAlerts []notifier.Alert
+	if ca.rulesEngine != nil {
+		for _, f := range ca.rulesEngine.Firing() {
+			// the messages contain the current values, rules are identified by the name
+			ruleAlerts = append(ruleAlerts, notifier.Alert{Key: rules.ReportName + "|" + f.Name, Source: rules.ReportName, Severity: f.Severity, Message: f.Message})
+		}
+	}
+	ca.webhookNotifier.Sync(notifierScopeRules, ruleAlerts)
+
+	jobs, _ := measurements["jobmon"].([]*jobmon.JobRun)
+	for _, job := range jobs {
+		key := "jobmon|" + job.ID
+		if !job.Failed() {
+			// the next successful run resolves the failure
+			ca.webhookNotifier.Resolve(key)
+		} else if job.Severity != jobmon.SeverityNone {
+			ca.webhookNotifier.Fire(notifier.Alert{Key: key, Source: "jobmon", Severity: string(job.Severity), Message: jobFailureMessage(job)})
+		}
+	}
+}
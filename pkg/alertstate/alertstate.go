@@ -0,0 +1,243 @@
+// Package alertstate tracks the alerts reported on every run across the runs.
+// It assigns stable IDs, remembers when an alert was seen first and last and reports when alerts open and resolve
+package alertstate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Transitions of the alerts
+const (
+	TransitionOpened   = "opened"
+	TransitionResolved = "resolved"
+)
+
+const stateFilePermissions = 0600
+
+// Condition is an alert or a warning reported by a run
+type Condition struct {
+	// Source is what reported the condition, e.g. "modules" or "smart"
+	Source string
+	// Module is the module that reported the condition, if any
+	Module string
+	// Key identifies the condition within the source and the module, e.g. the name of the disk.
+	// The message may change between the runs, e.g. contain a current value, the key doesn't
+	Key      string
+	Severity string
+	Message  string
+}
+
+// ID returns the stable ID of the condition derived from its source, module and key
+func (c Condition) ID() string {
+	sum := sha256.Sum256([]byte(c.Source + "\x00" + c.Module + "\x00" + c.Key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Alert is an open alert
+type Alert struct {
+	ID        string `json:"id"`
+	Scope     string `json:"scope"`
+	Source    string `json:"source"`
+	Module    string `json:"module,omitempty"`
+	Key       string `json:"key"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+	FirstSeen int64  `json:"first_seen"`
+	LastSeen  int64  `json:"last_seen"`
+	// Flaps counts how often the condition cleared and came back within the flap window
+	Flaps int `json:"flaps"`
+	// ClearedAt is set while the condition is cleared but the flap window has not passed yet
+	ClearedAt int64 `json:"cleared_at,omitempty"`
+}
+
+// Transition is a change of the state of an alert
+type Transition struct {
+	ID         string `json:"id"`
+	Transition string `json:"transition"`
+	Source     string `json:"source"`
+	Module     string `json:"module,omitempty"`
+	Key        string `json:"key"`
+	Severity   string `json:"severity"`
+	Message    string `json:"message"`
+	FirstSeen  int64  `json:"first_seen"`
+	LastSeen   int64  `json:"last_seen"`
+	Timestamp  int64  `json:"timestamp"`
+}
+
+// Store keeps the open alerts in a file to survive restarts. It's safe for concurrent use
+type Store struct {
+	path       string
+	flapWindow time.Duration
+
+	mu     sync.Mutex
+	alerts map[string]*Alert
+}
+
+// Open loads the state from the file, a missing file means no open alerts.
+// An alert is resolved once its condition stays cleared for flapWindow, it stays open if the condition comes back earlier
+func Open(path string, flapWindow time.Duration) (*Store, error) {
+	s := &Store{
+		path:       path,
+		flapWindow: flapWindow,
+		alerts:     make(map[string]*Alert),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return s, errors.Wrap(err, "while reading the alert state")
+	}
+
+	var alerts []*Alert
+	if err = json.Unmarshal(data, &alerts); err != nil {
+		return s, errors.Wrapf(err, "while parsing the alert state %s, starting from scratch", path)
+	}
+	for _, a := range alerts {
+		s.alerts[a.ID] = a
+	}
+
+	return s, nil
+}
+
+// Update applies the conditions of the scope reported by a run and returns the transitions.
+// Open alerts of the scope missing in conditions are cleared, alerts of the other scopes are not changed
+func (s *Store) Update(scope string, conditions []Condition, now time.Time) ([]Transition, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts := now.Unix()
+	changed := false
+	var transitions []Transition
+
+	current := make(map[string]bool, len(conditions))
+	for _, c := range conditions {
+		id := c.ID()
+		current[id] = true
+
+		a, exists := s.alerts[id]
+		if !exists {
+			a = &Alert{
+				ID:        id,
+				Scope:     scope,
+				Source:    c.Source,
+				Module:    c.Module,
+				Key:       c.Key,
+				Severity:  c.Severity,
+				Message:   c.Message,
+				FirstSeen: ts,
+				LastSeen:  ts,
+			}
+			s.alerts[id] = a
+			transitions = append(transitions, a.transition(TransitionOpened, ts))
+			changed = true
+		} else if a.ClearedAt != 0 {
+			// back within the flap window, the alert stays open
+			a.ClearedAt = 0
+			a.Flaps++
+			changed = true
+		}
+		if a.Severity != c.Severity {
+			a.Severity = c.Severity
+			changed = true
+		}
+		// not saved on their own, otherwise the file would be rewritten on every run while an alert is open
+		a.Message = c.Message
+		a.LastSeen = ts
+	}
+
+	for id, a := range s.alerts {
+		if a.Scope != scope || current[id] {
+			continue
+		}
+
+		if a.ClearedAt == 0 {
+			a.ClearedAt = ts
+			changed = true
+		}
+
+		if now.Sub(time.Unix(a.ClearedAt, 0)) >= s.flapWindow {
+			delete(s.alerts, id)
+			transitions = append(transitions, a.transition(TransitionResolved, ts))
+			changed = true
+		}
+	}
+
+	sort.Slice(transitions, func(i, j int) bool {
+		return transitions[i].ID < transitions[j].ID
+	})
+
+	if !changed {
+		return transitions, nil
+	}
+
+	return transitions, s.save()
+}
+
+// Alerts returns the open alerts ordered by the time they were seen first
+func (s *Store) Alerts() []Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sorted()
+}
+
+func (s *Store) sorted() []Alert {
+	alerts := make([]Alert, 0, len(s.alerts))
+	for _, a := range s.alerts {
+		alerts = append(alerts, *a)
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].FirstSeen != alerts[j].FirstSeen {
+			return alerts[i].FirstSeen < alerts[j].FirstSeen
+		}
+		return alerts[i].ID < alerts[j].ID
+	})
+
+	return alerts
+}
+
+func (s *Store) save() error {
+	data, err := json.Marshal(s.sorted())
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return errors.Wrap(err, "while saving the alert state")
+	}
+
+	// write a temp file first to not leave a broken state behind
+	tmpPath := s.path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, data, stateFilePermissions); err != nil {
+		return errors.Wrap(err, "while saving the alert state")
+	}
+
+	return errors.Wrap(os.Rename(tmpPath, s.path), "while saving the alert state")
+}
+
+func (a *Alert) transition(transition string, ts int64) Transition {
+	return Transition{
+		ID:         a.ID,
+		Transition: transition,
+		Source:     a.Source,
+		Module:     a.Module,
+		Key:        a.Key,
+		Severity:   a.Severity,
+		Message:    a.Message,
+		FirstSeen:  a.FirstSeen,
+		LastSeen:   a.LastSeen,
+		Timestamp:  ts,
+	}
+}
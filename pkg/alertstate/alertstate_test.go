@@ -0,0 +1,137 @@
+package alertstate
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alertstate")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "alert_state.json")
+
+	degraded := Condition{Source: "modules", Module: "raid", Key: "md0 missing devices", Severity: "alert", Message: "Raid md0 degraded. Missing 1 devices."}
+	failed := Condition{Source: "smart", Key: "sda", Severity: "alert", Message: "S.M.A.R.T. status of sda is FAILED"}
+	start := time.Unix(1000, 0)
+
+	s, err := Open(path, time.Minute)
+	assert.NoError(t, err)
+
+	transitions, err := s.Update("modules", []Condition{degraded}, start)
+	assert.NoError(t, err)
+	assert.Len(t, transitions, 1)
+	assert.Equal(t, TransitionOpened, transitions[0].Transition)
+	assert.Equal(t, degraded.ID(), transitions[0].ID)
+
+	transitions, err = s.Update("smart", []Condition{failed}, start)
+	assert.NoError(t, err)
+	assert.Len(t, transitions, 1)
+
+	// the same condition keeps the alert open, the state survives a restart
+	s, err = Open(path, time.Minute)
+	assert.NoError(t, err)
+	transitions, err = s.Update("modules", []Condition{degraded}, start.Add(30*time.Second))
+	assert.NoError(t, err)
+	assert.Empty(t, transitions)
+	alerts := s.Alerts()
+	assert.Len(t, alerts, 2)
+	assert.Equal(t, int64(1000), alerts[0].FirstSeen)
+
+	// flapping within the window is suppressed
+	transitions, err = s.Update("modules", nil, start.Add(60*time.Second))
+	assert.NoError(t, err)
+	assert.Empty(t, transitions)
+	transitions, err = s.Update("modules", []Condition{degraded}, start.Add(90*time.Second))
+	assert.NoError(t, err)
+	assert.Empty(t, transitions)
+
+	// resolved once the condition stays cleared for the window, the other scope is not affected
+	_, err = s.Update("modules", nil, start.Add(120*time.Second))
+	assert.NoError(t, err)
+	transitions, err = s.Update("modules", nil, start.Add(180*time.Second))
+	assert.NoError(t, err)
+	assert.Len(t, transitions, 1)
+	assert.Equal(t, TransitionResolved, transitions[0].Transition)
+	assert.Equal(t, int64(1090), transitions[0].LastSeen)
+
+	alerts = s.Alerts()
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, "smart", alerts[0].Source)
+}
+
+func TestStoreUpdateSavesChangesOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alertstate")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "alert_state.json")
+
+	degraded := Condition{Source: "modules", Module: "raid", Key: "md0 missing devices", Severity: "alert", Message: "Raid md0 degraded. Missing 1 devices."}
+	start := time.Unix(1000, 0)
+
+	s, err := Open(path, time.Minute)
+	assert.NoError(t, err)
+	_, err = s.Update("modules", []Condition{degraded}, start)
+	assert.NoError(t, err)
+	assert.FileExists(t, path)
+
+	// the alert is still open, only the last seen time changed
+	assert.NoError(t, os.Remove(path))
+	_, err = s.Update("modules", []Condition{degraded}, start.Add(30*time.Second))
+	assert.NoError(t, err)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, int64(1030), s.Alerts()[0].LastSeen)
+
+	// the cleared condition is saved
+	_, err = s.Update("modules", nil, start.Add(60*time.Second))
+	assert.NoError(t, err)
+	assert.FileExists(t, path)
+}
+
+func TestStoreUpdateChangingMessage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "alertstate")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	condition := func(severity string, usage int) Condition {
+		return Condition{
+			Source:   "modules",
+			Module:   "disk usage",
+			Key:      "/var",
+			Severity: severity,
+			Message:  fmt.Sprintf("/var is %d%% full", usage),
+		}
+	}
+	start := time.Unix(1000, 0)
+
+	s, err := Open(filepath.Join(dir, "alert_state.json"), time.Minute)
+	assert.NoError(t, err)
+
+	transitions, err := s.Update("modules", []Condition{condition("warning", 91)}, start)
+	assert.NoError(t, err)
+	assert.Len(t, transitions, 1)
+	id := transitions[0].ID
+
+	// the same key with a new value keeps the alert open, the message and the severity follow the condition
+	transitions, err = s.Update("modules", []Condition{condition("warning", 93)}, start.Add(30*time.Second))
+	assert.NoError(t, err)
+	assert.Empty(t, transitions)
+	transitions, err = s.Update("modules", []Condition{condition("alert", 97)}, start.Add(60*time.Second))
+	assert.NoError(t, err)
+	assert.Empty(t, transitions)
+
+	alerts := s.Alerts()
+	assert.Len(t, alerts, 1)
+	assert.Equal(t, id, alerts[0].ID)
+	assert.Equal(t, "/var is 97% full", alerts[0].Message)
+	assert.Equal(t, "alert", alerts[0].Severity)
+	assert.Equal(t, int64(1000), alerts[0].FirstSeen)
+	assert.Equal(t, 0, alerts[0].Flaps)
+}
@@ -0,0 +1,162 @@
+// Package backoff computes the delays between the retries of failed requests to the Hub.
+// The delays grow exponentially with full jitter, so many agents failing at the same time don't retry in lockstep.
+// A Retry-After header sent by the Hub takes precedence
+package backoff
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Limits of the delay requested with Retry-After.
+// MinRetryAfter keeps a Retry-After of 0 or a date in the past from retrying back-to-back
+const (
+	MinRetryAfter = time.Second
+	MaxRetryAfter = time.Hour
+)
+
+var (
+	randLock sync.Mutex
+	// the source differs per host and per start, the default source of math/rand is the same on every host
+	random = rand.New(rand.NewSource(seed()))
+)
+
+func seed() int64 {
+	h := fnv.New64a()
+	hostname, _ := os.Hostname()
+	_, _ = h.Write([]byte(hostname))
+	return time.Now().UnixNano() ^ int64(h.Sum64())
+}
+
+// randomDuration returns a random duration in [0, max)
+func randomDuration(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+
+	randLock.Lock()
+	defer randLock.Unlock()
+
+	return time.Duration(random.Int63n(int64(max)))
+}
+
+// Policy is an exponential backoff with full jitter
+type Policy struct {
+	// Base is the upper limit of the first delay, it doubles with each attempt
+	Base time.Duration
+	// Max limits the upper limit of the delays, MaxRetryAfter if not set
+	Max time.Duration
+}
+
+// Delay returns a random delay between 0 and Base*2^attempt limited to Max, the first attempt is 0
+func (p Policy) Delay(attempt int) time.Duration {
+	max := p.Max
+	if max <= 0 {
+		max = MaxRetryAfter
+	}
+
+	limit := p.Base
+	for i := 0; i < attempt && limit < max; i++ {
+		limit *= 2
+	}
+	if limit > max {
+		limit = max
+	}
+
+	return randomDuration(limit)
+}
+
+// Next returns the delay requested by the Retry-After of err or a jittered delay for the attempt otherwise
+func (p Policy) Next(attempt int, err error) time.Duration {
+	if retryAfter, ok := RetryAfterOf(err); ok {
+		return retryAfter
+	}
+
+	return p.Delay(attempt)
+}
+
+// StartOffset returns a random offset in [0, interval) to spread the schedules of many hosts
+func StartOffset(interval time.Duration) time.Duration {
+	return randomDuration(interval)
+}
+
+// RetryAfterError is an error of a response with a Retry-After header
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the wrapped error for errors.Cause
+func (e *RetryAfterError) Cause() error {
+	return e.Err
+}
+
+// Unwrap returns the wrapped error for errors.Is and errors.As
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// WithRetryAfter adds the Retry-After of the response to err, err is returned unchanged if the header is missing or invalid
+func WithRetryAfter(err error, resp *http.Response) error {
+	if err == nil || resp == nil {
+		return err
+	}
+
+	retryAfter, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return err
+	}
+
+	return &RetryAfterError{Err: err, RetryAfter: retryAfter}
+}
+
+// RetryAfterOf returns the delay requested with Retry-After if err carries one
+func RetryAfterOf(err error) (time.Duration, bool) {
+	var retryAfterErr *RetryAfterError
+	if errors.As(err, &retryAfterErr) {
+		return retryAfterErr.RetryAfter, true
+	}
+
+	return 0, false
+}
+
+// ParseRetryAfter parses the value of a Retry-After header, either seconds or an HTTP date.
+// The delay is limited to [MinRetryAfter, MaxRetryAfter]
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		retryAfter = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		retryAfter = date.Sub(now)
+	} else {
+		return 0, false
+	}
+
+	if retryAfter < MinRetryAfter {
+		retryAfter = MinRetryAfter
+	} else if retryAfter > MaxRetryAfter {
+		retryAfter = MaxRetryAfter
+	}
+
+	return retryAfter, true
+}
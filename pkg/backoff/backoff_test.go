@@ -0,0 +1,66 @@
+package backoff
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{Base: time.Second, Max: 10 * time.Second}
+
+	for attempt, limit := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		for i := 0; i < 100; i++ {
+			d := p.Delay(attempt)
+			assert.True(t, d >= 0 && d < limit, "attempt %d: %v not in [0, %v)", attempt, d, limit)
+		}
+	}
+
+	assert.True(t, Policy{Base: time.Second}.Delay(1000) < MaxRetryAfter)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	d, ok := ParseRetryAfter("120", now)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Minute, d)
+
+	d, ok = ParseRetryAfter("Wed, 01 Jan 2020 12:00:30 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, d)
+
+	d, ok = ParseRetryAfter("86400", now)
+	assert.True(t, ok)
+	assert.Equal(t, MaxRetryAfter, d)
+
+	// retrying right away is not allowed
+	for _, value := range []string{"0", "Wed, 01 Jan 2020 11:59:00 GMT"} {
+		d, ok = ParseRetryAfter(value, now)
+		assert.True(t, ok, value)
+		assert.Equal(t, MinRetryAfter, d, value)
+	}
+
+	for _, value := range []string{"", "-1", "soon"} {
+		_, ok = ParseRetryAfter(value, now)
+		assert.False(t, ok, value)
+	}
+}
+
+func TestWithRetryAfter(t *testing.T) {
+	errTooManyRequests := errors.New("429")
+	resp := &http.Response{Header: http.Header{}}
+
+	assert.Equal(t, errTooManyRequests, WithRetryAfter(errTooManyRequests, resp))
+
+	resp.Header.Set("Retry-After", "7")
+	err := WithRetryAfter(errTooManyRequests, resp)
+	assert.True(t, errors.Is(err, errTooManyRequests))
+	d, ok := RetryAfterOf(err)
+	assert.True(t, ok)
+	assert.Equal(t, 7*time.Second, d)
+	assert.Equal(t, 7*time.Second, Policy{Base: time.Minute}.Next(3, err))
+}
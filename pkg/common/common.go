@@ -12,6 +12,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -19,6 +20,9 @@ import (
 
 var ErrCommandExecutionTimeout = errors.New("command execution timeout exceeded")
 
+// commandsCount and commandsDurationNs count the commands executed by RunCommandWithTimeout
+var commandsCount, commandsDurationNs int64
+
 // Invoker executes command in context and gathers stdout/stderr output into slice
 type Invoker interface {
 	CommandWithContext(context.Context, string, ...string) ([]byte, error)
@@ -60,18 +64,31 @@ func RunCommandInBackground(name string, arg ...string) ([]byte, error) {
 
 // RunCommandWithTimeout runs command and returns it's standard output. If timeout exceeded the returned error is ErrCommandExecutionTimeout
 func RunCommandWithTimeout(timeout time.Duration, name string, arg ...string) ([]byte, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	return RunCommandWithTimeoutContext(context.Background(), timeout, name, arg...)
+}
+
+// RunCommandWithTimeoutContext is RunCommandWithTimeout that also kills the command when the parent ctx is done
+func RunCommandWithTimeoutContext(parent context.Context, timeout time.Duration, name string, arg ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, name, arg...)
 
+	started := time.Now()
 	result, err := cmd.Output()
+	atomic.AddInt64(&commandsCount, 1)
+	atomic.AddInt64(&commandsDurationNs, int64(time.Since(started)))
 	if ctx.Err() == context.DeadlineExceeded {
 		err = ErrCommandExecutionTimeout
 	}
 	return result, err
 }
 
+// CommandsStats returns the number and the total duration of the commands executed by RunCommandWithTimeout since the start
+func CommandsStats() (int64, time.Duration) {
+	return atomic.LoadInt64(&commandsCount), time.Duration(atomic.LoadInt64(&commandsDurationNs))
+}
+
 func MergeStringMaps(mapA, mapB map[string]interface{}) map[string]interface{} {
 	for k, v := range mapB {
 		mapA[k] = v
@@ -22,6 +22,11 @@ func (c *ErrorCollector) HasErrors() bool {
 	return len(c.errs) > 0
 }
 
+// Errors returns the collected errors
+func (c *ErrorCollector) Errors() []error {
+	return c.errs
+}
+
 // Combine returns all collected errors as a single error
 func (c *ErrorCollector) Combine() error {
 	if c.HasErrors() {
@@ -0,0 +1,264 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HubTLSConfig holds the TLS options of the connection to the Hub
+type HubTLSConfig struct {
+	// ClientCertFile and ClientKeyFile are the PEM files of the client certificate sent when the Hub requests one
+	ClientCertFile string
+	ClientKeyFile  string
+	// CAFile replaces the system roots and /etc/cagent/cacert.pem to verify the Hub certificate
+	CAFile string
+	// PinnedSHA256 lists the SHA-256 hashes of the accepted public keys (SubjectPublicKeyInfo), base64 or hex encoded.
+	// A certificate of the verified chain must match one of them
+	PinnedSHA256 []string
+}
+
+// IsEmpty returns true if none of the options is set
+func (c HubTLSConfig) IsEmpty() bool {
+	return c.ClientCertFile == "" && c.ClientKeyFile == "" && c.CAFile == "" && len(c.PinnedSHA256) == 0
+}
+
+// Validate checks the options without reading the files
+func (c HubTLSConfig) Validate() error {
+	if (c.ClientCertFile == "") != (c.ClientKeyFile == "") {
+		return fmt.Errorf("hub_tls_client_cert and hub_tls_client_key must be set together")
+	}
+
+	_, err := parsePins(c.PinnedSHA256)
+	return err
+}
+
+// Transport returns the round tripper to the Hub, a copy of base with the TLS options applied.
+// rootCAs is used unless CAFile is set, nil means the system roots.
+// The client certificate is read on the first handshake and read again whenever it changes, the CA file is read
+// before the first request and the transport is set up again whenever it changes. So both can be rotated without a restart.
+// Errors loading them fail the requests
+func (c HubTLSConfig) Transport(base *http.Transport, rootCAs *x509.CertPool) (http.RoundTripper, error) {
+	pins, err := parsePins(c.PinnedSHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{RootCAs: rootCAs}
+
+	if c.ClientCertFile != "" {
+		certs := &reloadingFiles{paths: []string{c.ClientCertFile, c.ClientKeyFile}}
+		var cert *tls.Certificate
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			err := certs.reload(func(data [][]byte) error {
+				loaded, err := tls.X509KeyPair(data[0], data[1])
+				if err != nil {
+					return err
+				}
+				cert = &loaded
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to load hub_tls_client_cert and hub_tls_client_key: %s", err.Error())
+			}
+			return cert, nil
+		}
+	}
+
+	if len(pins) > 0 {
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if !matchesPin(cs.VerifiedChains, pins) {
+				return fmt.Errorf("the public key of the Hub certificate %s doesn't match hub_tls_pinned_sha256", describeCert(cs.PeerCertificates))
+			}
+			return nil
+		}
+	}
+
+	transport := base.Clone()
+	transport.TLSClientConfig = cfg
+	if c.CAFile == "" {
+		return transport, nil
+	}
+
+	return &caReloadingTransport{
+		template: transport,
+		cas:      &reloadingFiles{paths: []string{c.CAFile}},
+	}, nil
+}
+
+// caReloadingTransport verifies the Hub certificate against the current content of the CA file.
+// The certificate is verified by crypto/tls, so the host name or IP address of the Hub is checked as well
+type caReloadingTransport struct {
+	template *http.Transport
+	cas      *reloadingFiles
+
+	mu      sync.Mutex
+	current *http.Transport
+}
+
+func (t *caReloadingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	current, err := t.transport()
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	return current.RoundTrip(req)
+}
+
+// CloseIdleConnections is called by http.Client.CloseIdleConnections
+func (t *caReloadingTransport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.current != nil {
+		t.current.CloseIdleConnections()
+	}
+}
+
+// transport returns the transport set up with the current content of the CA file
+func (t *caReloadingTransport) transport() (*http.Transport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := t.cas.reload(func(data [][]byte) error {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data[0]) {
+			return fmt.Errorf("no PEM certificates found")
+		}
+
+		next := t.template.Clone()
+		next.TLSClientConfig.RootCAs = pool
+		if t.current != nil {
+			// the connections verified with the previous CA are not reused
+			t.current.CloseIdleConnections()
+		}
+		t.current = next
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load hub_tls_ca_file: %s", err.Error())
+	}
+
+	return t.current, nil
+}
+
+// PublicKeySHA256 returns the base64 encoded hash of the public key of the certificate as expected in hub_tls_pinned_sha256
+func PublicKeySHA256(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func parsePins(pins []string) ([][]byte, error) {
+	var parsed [][]byte
+	for _, pin := range pins {
+		pin = strings.TrimPrefix(strings.TrimSpace(pin), "sha256//")
+
+		sum, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(sum) != sha256.Size {
+			sum, err = hex.DecodeString(strings.Replace(pin, ":", "", -1))
+		}
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("hub_tls_pinned_sha256: '%s' is not a base64 or hex encoded SHA-256 hash", pin)
+		}
+		parsed = append(parsed, sum)
+	}
+
+	return parsed, nil
+}
+
+func matchesPin(chains [][]*x509.Certificate, pins [][]byte) bool {
+	for _, chain := range chains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if bytes.Equal(sum[:], pin) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func describeCert(certs []*x509.Certificate) string {
+	if len(certs) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("'%s' (sha256 %s)", certs[0].Subject.CommonName, PublicKeySHA256(certs[0]))
+}
+
+// reloadingFiles reads the files again when the size or the modification time of any of them changed
+type reloadingFiles struct {
+	paths []string
+
+	mu     sync.Mutex
+	loaded bool
+	stamps []fileStamp
+}
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// reload passes the content to apply if the files changed since the last successful apply
+func (r *reloadingFiles) reload(apply func(data [][]byte) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stamps := make([]fileStamp, len(r.paths))
+	for i, path := range r.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		stamps[i] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+	}
+
+	if r.loaded && stampsEqual(stamps, r.stamps) {
+		return nil
+	}
+
+	data := make([][]byte, len(r.paths))
+	for i, path := range r.paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		data[i] = b
+	}
+
+	if err := apply(data); err != nil {
+		return err
+	}
+
+	r.loaded = true
+	r.stamps = stamps
+
+	return nil
+}
+
+func stampsEqual(a, b []fileStamp) bool {
+	for i := range a {
+		if a[i].size != b[i].size || !a[i].modTime.Equal(b[i].modTime) {
+			return false
+		}
+	}
+
+	return true
+}
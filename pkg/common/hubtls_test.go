@@ -0,0 +1,193 @@
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, name string, ip string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP(ip)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestHubTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hubtls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", "127.0.0.1", nil)
+	otherCA := newTestCert(t, "other ca", "127.0.0.1", nil)
+	server := newTestCert(t, "hub", "127.0.0.1", ca)
+	client := newTestCert(t, "client", "127.0.0.1", ca)
+
+	serverCert, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	hub := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	hub.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	hub.StartTLS()
+	defer hub.Close()
+
+	cfg := HubTLSConfig{
+		ClientCertFile: filepath.Join(dir, "client.pem"),
+		ClientKeyFile:  filepath.Join(dir, "client.key"),
+		CAFile:         filepath.Join(dir, "ca.pem"),
+	}
+	start := time.Now().Add(-time.Minute)
+	writeFile(t, cfg.ClientCertFile, client.certPEM, start)
+	writeFile(t, cfg.ClientKeyFile, client.keyPEM, start)
+	writeFile(t, cfg.CAFile, ca.certPEM, start)
+
+	get := func(cfg HubTLSConfig) error {
+		transport, err := cfg.Transport(&http.Transport{}, nil)
+		require.NoError(t, err)
+		c := &http.Client{Transport: transport}
+		resp, err := c.Get(hub.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	assert.NoError(t, get(cfg))
+
+	cfg.PinnedSHA256 = []string{PublicKeySHA256(ca.cert)}
+	assert.NoError(t, get(cfg))
+
+	cfg.PinnedSHA256 = []string{PublicKeySHA256(otherCA.cert)}
+	err = get(cfg)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "hub_tls_pinned_sha256")
+	}
+
+	// the files are read again once they change
+	cfg.PinnedSHA256 = nil
+	transport, err := cfg.Transport(&http.Transport{DisableKeepAlives: true}, nil)
+	require.NoError(t, err)
+	c := &http.Client{Transport: transport}
+	resp, err := c.Get(hub.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	writeFile(t, cfg.CAFile, otherCA.certPEM, start.Add(time.Second))
+	_, err = c.Get(hub.URL)
+	assert.Error(t, err)
+
+	writeFile(t, cfg.CAFile, ca.certPEM, start.Add(2*time.Second))
+	resp, err = c.Get(hub.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+
+	os.Remove(cfg.ClientKeyFile)
+	_, err = c.Get(hub.URL)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "hub_tls_client_key")
+	}
+}
+
+func TestHubTLSConfigIPMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hubtls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// signed by the CA, but for another host
+	ca := newTestCert(t, "ca", "127.0.0.1", nil)
+	server := newTestCert(t, "hub", "10.0.0.1", ca)
+	serverCert, err := tls.X509KeyPair(server.certPEM, server.keyPEM)
+	require.NoError(t, err)
+
+	hub := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	hub.TLS = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	hub.StartTLS()
+	defer hub.Close()
+
+	cfg := HubTLSConfig{CAFile: filepath.Join(dir, "ca.pem")}
+	writeFile(t, cfg.CAFile, ca.certPEM, time.Now())
+
+	transport, err := cfg.Transport(&http.Transport{}, nil)
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: transport}).Get(hub.URL)
+	if assert.Error(t, err) {
+		var hostnameErr x509.HostnameError
+		assert.True(t, errors.As(err, &hostnameErr), err.Error())
+	}
+}
+
+func TestHubTLSConfigValidate(t *testing.T) {
+	assert.NoError(t, HubTLSConfig{}.Validate())
+	assert.Error(t, HubTLSConfig{ClientCertFile: "client.pem"}.Validate())
+	assert.NoError(t, HubTLSConfig{PinnedSHA256: []string{
+		"sha256//47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+		"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	}}.Validate())
+	assert.Error(t, HubTLSConfig{PinnedSHA256: []string{"abc"}}.Validate())
+}
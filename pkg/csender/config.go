@@ -17,6 +17,7 @@ type Csender struct {
 	Verbose    bool
 	RetryLimit int
 	Timeout    time.Duration
+	TLS        common.HubTLSConfig
 
 	version string
 	result  common.MeasurementsMap
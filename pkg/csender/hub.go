@@ -17,15 +17,16 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	"github.com/securez-one/cagent"
+	"github.com/securez-one/cagent/pkg/backoff"
 	"github.com/securez-one/cagent/pkg/common"
 	"github.com/securez-one/cagent/pkg/proxydetect"
 )
 
-func (cs *Csender) httpClient() *http.Client {
-	tr := *(http.DefaultTransport.(*http.Transport))
+func (cs *Csender) httpClient() (*http.Client, error) {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
 	rootCAs, err := common.CustomRootCertPool()
 	if err != nil {
-		if err != common.ErrorCustomRootCertPoolNotImplementedForOS {
+		if err != common.ErrorCustomRootCertPoolNotImplementedForOS && cs.TLS.CAFile == "" {
 			fmt.Fprintln(os.Stderr, "failed to add root certs: "+err.Error())
 		}
 	} else if rootCAs != nil {
@@ -37,16 +38,28 @@ func (cs *Csender) httpClient() *http.Client {
 	tr.Proxy = proxydetect.GetProxyForRequest
 	proxydetect.UserAgent = cs.userAgent()
 
+	var roundTripper http.RoundTripper = tr
+	if !cs.TLS.IsEmpty() {
+		roundTripper, err = cs.TLS.Transport(tr, rootCAs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &http.Client{
 		Timeout:   cs.Timeout,
-		Transport: &tr,
-	}
+		Transport: roundTripper,
+	}, nil
 }
 
+// retries of 429 and 5xx responses back off up to maxRetryInterval unless the Hub sends a Retry-After header
+const maxRetryInterval = time.Minute
+
 // GracefulSend sends to hub with retry logic
 func (cs *Csender) GracefulSend() error {
 
 	retries := 0
+	failures := 0
 	var retryIn time.Duration
 
 	for {
@@ -63,16 +76,17 @@ func (cs *Csender) GracefulSend() error {
 			return nil
 		}
 
-		if err == cagent.ErrHubTooManyRequests {
-			// for error code 429, wait 10 seconds and try again
-			retryIn = 10 * time.Second
+		cause := errors.Cause(err)
+		if cause == cagent.ErrHubTooManyRequests {
+			// for error code 429, wait as requested by the Hub or back off starting at 10 seconds
+			retryIn = backoff.Policy{Base: 10 * time.Second, Max: maxRetryInterval}.Next(failures, err)
 			if cs.Verbose {
 				log.Infof("got HTTP %d from %s, retrying in %v", statusCode, cs.HubURL, retryIn)
 			}
-		} else if err == cagent.ErrHubServerError || errors.Is(err, context.DeadlineExceeded) {
-			// for error codes 5xx, wait for 1 seconds and try again, increase by 1 second each retry
+		} else if cause == cagent.ErrHubServerError || errors.Is(err, context.DeadlineExceeded) {
+			// for error codes 5xx, wait as requested by the Hub or back off starting at 1 second
 			retries++
-			retryIn = time.Duration(retries) * time.Second
+			retryIn = backoff.Policy{Base: time.Second, Max: maxRetryInterval}.Next(failures, err)
 
 			if retries > cs.RetryLimit {
 				if cs.Verbose {
@@ -86,6 +100,7 @@ func (cs *Csender) GracefulSend() error {
 		} else {
 			return err
 		}
+		failures++
 
 		time.Sleep(retryIn)
 	}
@@ -93,7 +108,10 @@ func (cs *Csender) GracefulSend() error {
 
 // Send is used by csender. returns status code, error
 func (cs *Csender) Send() (int, error) {
-	client := cs.httpClient()
+	client, err := cs.httpClient()
+	if err != nil {
+		return 0, err
+	}
 
 	if _, err := url.Parse(cs.HubURL); err != nil {
 		return 0, fmt.Errorf("incorrect URL provided with -u (hub URL): %s", err.Error())
@@ -137,10 +155,10 @@ func (cs *Csender) Send() (int, error) {
 
 	if resp != nil {
 		if resp.StatusCode == http.StatusTooManyRequests {
-			return resp.StatusCode, cagent.ErrHubTooManyRequests
+			return resp.StatusCode, backoff.WithRetryAfter(cagent.ErrHubTooManyRequests, resp)
 		}
 		if resp.StatusCode >= 500 && resp.StatusCode <= 599 {
-			return resp.StatusCode, cagent.ErrHubServerError
+			return resp.StatusCode, backoff.WithRetryAfter(cagent.ErrHubServerError, resp)
 		}
 	}
 
@@ -0,0 +1,211 @@
+// Package delta encodes large, slow-changing sections of the measurements as changes against the last acknowledged snapshot
+package delta
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IDFields defines the fields identifying an entry of the list sections.
+// Sections that are maps are identified by the map keys
+var IDFields = map[string][]string{
+	"services.list":       {"name", "manager"},
+	"listeningports.list": {"proto", "addr", "pid"},
+	"proc.list":           {"pid"},
+	"docker.containers":   {"id"},
+	"hw.inventory":        nil,
+}
+
+const idSeparator = "|"
+
+// Section describes how a section of the measurements was encoded.
+// A full section is sent as usual along with its version. Otherwise the section is replaced with the changes
+// against the snapshot with BaseVersion. The receiver must request a full resend if it doesn't have that snapshot
+type Section struct {
+	Version     uint64                     `json:"version"`
+	Full        bool                       `json:"full"`
+	BaseVersion uint64                     `json:"base_version,omitempty"`
+	IDFields    []string                   `json:"id_fields,omitempty"`
+	Added       map[string]json.RawMessage `json:"added,omitempty"`
+	Changed     map[string]json.RawMessage `json:"changed,omitempty"`
+	Removed     []string                   `json:"removed,omitempty"`
+}
+
+type snapshot struct {
+	version  uint64
+	entries  map[string]json.RawMessage
+	lastFull time.Time
+}
+
+// Encoder keeps the last acknowledged snapshot of each section. It's safe for concurrent use
+type Encoder struct {
+	mu           sync.Mutex
+	fullInterval time.Duration
+	snapshots    map[string]*snapshot
+}
+
+// NewEncoder creates an encoder that sends every section in full at least once per fullInterval
+func NewEncoder(fullInterval time.Duration) *Encoder {
+	return &Encoder{
+		fullInterval: fullInterval,
+		snapshots:    make(map[string]*snapshot),
+	}
+}
+
+// Encode returns the encoded section and the function to call once the receiver acknowledged it.
+// Until then the following calls are encoded against the same snapshot
+func (e *Encoder) Encode(name string, value interface{}, now time.Time) (*Section, func(), error) {
+	entries, err := toEntries(name, value)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	prev := e.snapshots[name]
+	s := &Section{}
+	if prev == nil || now.Sub(prev.lastFull) >= e.fullInterval {
+		s.Full = true
+		s.IDFields = IDFields[name]
+	} else {
+		s.BaseVersion = prev.version
+		s.Added, s.Changed, s.Removed = diff(prev.entries, entries)
+	}
+
+	if prev != nil {
+		s.Version = prev.version + 1
+	} else {
+		s.Version = 1
+	}
+
+	commit := func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		current := e.snapshots[name]
+		if current != prev {
+			// the encoder was reset or another encoding was acknowledged in between
+			return
+		}
+
+		next := &snapshot{version: s.Version, entries: entries}
+		if s.Full {
+			next.lastFull = now
+		} else {
+			next.lastFull = prev.lastFull
+		}
+		e.snapshots[name] = next
+	}
+
+	return s, commit, nil
+}
+
+// Reset drops all snapshots, the next encoding of each section is full
+func (e *Encoder) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.snapshots = make(map[string]*snapshot)
+}
+
+// ResetSection drops the snapshot of the section, its next encoding is full
+func (e *Encoder) ResetSection(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.snapshots, name)
+}
+
+func diff(prev, current map[string]json.RawMessage) (added, changed map[string]json.RawMessage, removed []string) {
+	added = make(map[string]json.RawMessage)
+	changed = make(map[string]json.RawMessage)
+	for id, entry := range current {
+		prevEntry, exists := prev[id]
+		if !exists {
+			added[id] = entry
+		} else if string(prevEntry) != string(entry) {
+			changed[id] = entry
+		}
+	}
+
+	removed = []string{}
+	for id := range prev {
+		if _, exists := current[id]; !exists {
+			removed = append(removed, id)
+		}
+	}
+	sort.Strings(removed)
+
+	return added, changed, removed
+}
+
+// toEntries converts the section to its entries keyed by the ID in the canonical JSON form
+func toEntries(name string, value interface{}) (map[string]json.RawMessage, error) {
+	// the sections are of different types, JSON is what is sent eventually
+	b, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized interface{}
+	if err = json.Unmarshal(b, &normalized); err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]json.RawMessage)
+	switch v := normalized.(type) {
+	case nil:
+	case map[string]interface{}:
+		for key, entry := range v {
+			if entries[key], err = json.Marshal(entry); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for _, entry := range v {
+			raw, err := json.Marshal(entry)
+			if err != nil {
+				return nil, err
+			}
+			id := entryID(IDFields[name], entry, raw)
+			if _, duplicate := entries[id]; duplicate {
+				id = string(raw)
+			}
+			entries[id] = raw
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported type %T", name, value)
+	}
+
+	return entries, nil
+}
+
+// entryID joins the values of the ID fields, entries without them are identified by their content
+func entryID(fields []string, entry interface{}, raw json.RawMessage) string {
+	m, isMap := entry.(map[string]interface{})
+	if !isMap || len(fields) == 0 {
+		return string(raw)
+	}
+
+	values := make([]string, 0, len(fields))
+	found := false
+	for _, f := range fields {
+		v, exists := m[f]
+		if !exists || v == nil {
+			values = append(values, "")
+			continue
+		}
+		found = true
+		values = append(values, fmt.Sprint(v))
+	}
+
+	if !found {
+		return string(raw)
+	}
+	return strings.Join(values, idSeparator)
+}
@@ -0,0 +1,93 @@
+package delta
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type proc struct {
+	PID  int    `json:"pid"`
+	Name string `json:"name"`
+}
+
+func TestEncoderList(t *testing.T) {
+	e := NewEncoder(time.Hour)
+	now := time.Now()
+
+	s, ack, err := e.Encode("proc.list", []proc{{1, "init"}, {2, "sshd"}}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, &Section{Version: 1, Full: true, IDFields: []string{"pid"}}, s)
+	ack()
+
+	s, _, err = e.Encode("proc.list", []proc{{1, "init"}, {2, "sshd -D"}, {3, "cron"}}, now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, &Section{
+		Version:     2,
+		BaseVersion: 1,
+		Added:       map[string]json.RawMessage{"3": json.RawMessage(`{"name":"cron","pid":3}`)},
+		Changed:     map[string]json.RawMessage{"2": json.RawMessage(`{"name":"sshd -D","pid":2}`)},
+		Removed:     []string{},
+	}, s)
+
+	// not acknowledged, so the next one is encoded against the same snapshot
+	s, ack, err = e.Encode("proc.list", []proc{{2, "sshd"}}, now.Add(2*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), s.BaseVersion)
+	assert.Equal(t, uint64(2), s.Version)
+	assert.Equal(t, []string{"1"}, s.Removed)
+	assert.Empty(t, s.Added)
+	assert.Empty(t, s.Changed)
+	ack()
+
+	// the full interval has passed
+	s, _, err = e.Encode("proc.list", []proc{{2, "sshd"}}, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, s.Full)
+	assert.Equal(t, uint64(3), s.Version)
+}
+
+func TestEncoderMapAndReset(t *testing.T) {
+	e := NewEncoder(time.Hour)
+	now := time.Now()
+
+	_, ack, err := e.Encode("hw.inventory", map[string]interface{}{"cpu": "x86", "ram": 1024}, now)
+	assert.NoError(t, err)
+	ack()
+
+	s, ack, err := e.Encode("hw.inventory", map[string]interface{}{"cpu": "x86", "ram": 2048}, now)
+	assert.NoError(t, err)
+	assert.False(t, s.Full)
+	assert.Equal(t, map[string]json.RawMessage{"ram": json.RawMessage(`2048`)}, s.Changed)
+
+	// a reset in between discards the acknowledgement of the older encoding
+	e.Reset()
+	ack()
+	s, _, err = e.Encode("hw.inventory", map[string]interface{}{"cpu": "x86", "ram": 2048}, now)
+	assert.NoError(t, err)
+	assert.True(t, s.Full)
+	assert.Equal(t, uint64(1), s.Version)
+}
+
+func TestEncoderResetSection(t *testing.T) {
+	e := NewEncoder(time.Hour)
+	now := time.Now()
+
+	for _, name := range []string{"hw.inventory", "proc.list"} {
+		_, ack, err := e.Encode(name, map[string]interface{}{"a": 1}, now)
+		assert.NoError(t, err)
+		ack()
+	}
+
+	e.ResetSection("hw.inventory")
+
+	s, _, err := e.Encode("hw.inventory", map[string]interface{}{"a": 1}, now)
+	assert.NoError(t, err)
+	assert.True(t, s.Full)
+
+	s, _, err = e.Encode("proc.list", map[string]interface{}{"a": 1}, now)
+	assert.NoError(t, err)
+	assert.False(t, s.Full)
+}
@@ -0,0 +1,161 @@
+// Package events pushes urgent events like threshold crossings or degraded raids right away instead of waiting for the next report
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Severities of the events
+const (
+	SeverityAlert     = "alert"
+	SeverityWarning   = "warning"
+	SeverityRecovered = "recovered"
+)
+
+// queueSize is the number of events waiting to be sent, further events are dropped
+const queueSize = 100
+
+// Event is an urgent state change emitted by a watcher or a module
+type Event struct {
+	// Source is the emitter, e.g. "cpu", "raid" or "rules"
+	Source string `json:"source"`
+	// Key identifies what the event is about within the source, e.g. the name of the rule. Events are debounced by source and key
+	Key       string      `json:"key"`
+	Severity  string      `json:"severity"`
+	Message   string      `json:"message"`
+	Value     interface{} `json:"value,omitempty"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+// SendFunc delivers the events to the endpoint
+type SendFunc func(events []Event) error
+
+// Publisher sends the events in the background. The same event is sent at most once per debounce interval,
+// a change of the severity is always sent
+type Publisher struct {
+	send     SendFunc
+	debounce time.Duration
+	logger   *logrus.Logger
+
+	queue     chan Event
+	stop      chan struct{}
+	stopped   chan struct{}
+	mu        sync.Mutex
+	lastSent  map[string]sentEvent
+	lastPrune time.Time
+}
+
+// sentEvent is the last event published for a source and key
+type sentEvent struct {
+	severity string
+	at       time.Time
+}
+
+// NewPublisher creates a publisher and starts sending the events
+func NewPublisher(send SendFunc, debounce time.Duration, logger *logrus.Logger) *Publisher {
+	p := &Publisher{
+		send:     send,
+		debounce: debounce,
+		logger:   logger,
+		queue:    make(chan Event, queueSize),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		lastSent: make(map[string]sentEvent),
+	}
+	go p.run()
+
+	return p
+}
+
+// Publish queues the event unless the same one was published within the debounce interval. It never blocks
+func (p *Publisher) Publish(e Event) {
+	now := time.Now()
+	if e.Timestamp == 0 {
+		e.Timestamp = now.Unix()
+	}
+
+	id := e.Source + "|" + e.Key
+	p.mu.Lock()
+	p.pruneLastSent(now)
+	if last, exists := p.lastSent[id]; exists && last.severity == e.Severity && now.Sub(last.at) < p.debounce {
+		p.mu.Unlock()
+		return
+	}
+	p.lastSent[id] = sentEvent{severity: e.Severity, at: now}
+	p.mu.Unlock()
+
+	select {
+	case p.queue <- e:
+	default:
+		p.logger.Warnf("events: queue is full, dropping %s event of %s: %s", e.Severity, e.Source, e.Message)
+	}
+}
+
+// pruneLastSent drops the events that don't debounce anymore, at most once per debounce interval.
+// Keys like the ones of the failed jobs are unique, they would pile up otherwise
+func (p *Publisher) pruneLastSent(now time.Time) {
+	if now.Sub(p.lastPrune) < p.debounce {
+		return
+	}
+
+	for id, last := range p.lastSent {
+		if now.Sub(last.at) >= p.debounce {
+			delete(p.lastSent, id)
+		}
+	}
+	p.lastPrune = now
+}
+
+// Shutdown stops sending, queued events are dropped
+func (p *Publisher) Shutdown() {
+	close(p.stop)
+	<-p.stopped
+}
+
+func (p *Publisher) run() {
+	defer close(p.stopped)
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case e := <-p.queue:
+			// send everything that piled up during the previous request at once
+			batch := []Event{e}
+			for len(batch) < queueSize && len(p.queue) > 0 {
+				batch = append(batch, <-p.queue)
+			}
+
+			if err := p.send(batch); err != nil {
+				p.logger.WithError(err).Errorf("events: failed to send %d events", len(batch))
+			}
+		}
+	}
+}
+
+var (
+	defaultPublisher     *Publisher
+	defaultPublisherLock sync.RWMutex
+)
+
+// SetDefault sets the publisher used by Emit, nil disables the events
+func SetDefault(p *Publisher) {
+	defaultPublisherLock.Lock()
+	defer defaultPublisherLock.Unlock()
+
+	defaultPublisher = p
+}
+
+// Emit publishes the event with the default publisher. It does nothing if the events are disabled
+func Emit(e Event) {
+	defaultPublisherLock.RLock()
+	p := defaultPublisher
+	defaultPublisherLock.RUnlock()
+
+	if p != nil {
+		p.Publish(e)
+	}
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublisherDebounce(t *testing.T) {
+	var mu sync.Mutex
+	var sent []Event
+	p := NewPublisher(func(events []Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, events...)
+		return nil
+	}, time.Hour, logrus.StandardLogger())
+	defer p.Shutdown()
+
+	SetDefault(p)
+	defer SetDefault(nil)
+
+	Emit(Event{Source: "raid", Key: "md0", Severity: SeverityAlert, Message: "Raid md0 degraded"})
+	Emit(Event{Source: "raid", Key: "md0", Severity: SeverityAlert, Message: "Raid md0 degraded"})
+	Emit(Event{Source: "raid", Key: "md0", Severity: SeverityRecovered, Message: "Raid md0 recovered"})
+	Emit(Event{Source: "cpu", Key: "idle.avg1", Severity: SeverityWarning, Message: "CPU utilisation"})
+
+	deadline := time.Now().Add(time.Second)
+	mu.Lock()
+	defer mu.Unlock()
+	for len(sent) < 3 && time.Now().Before(deadline) {
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+	}
+
+	assert.Len(t, sent, 3)
+	assert.Equal(t, "Raid md0 degraded", sent[0].Message)
+	assert.Equal(t, SeverityRecovered, sent[1].Severity)
+	assert.Equal(t, "cpu", sent[2].Source)
+	assert.NotZero(t, sent[0].Timestamp)
+}
+
+func TestPublisherDebounceStateChanges(t *testing.T) {
+	p := &Publisher{
+		debounce: time.Hour,
+		logger:   logrus.StandardLogger(),
+		queue:    make(chan Event, queueSize),
+		lastSent: make(map[string]sentEvent),
+	}
+
+	// fire, recover and fire again within the debounce interval
+	p.Publish(Event{Source: "rules", Key: "load", Severity: SeverityAlert})
+	p.Publish(Event{Source: "rules", Key: "load", Severity: SeverityRecovered})
+	p.Publish(Event{Source: "rules", Key: "load", Severity: SeverityAlert})
+	p.Publish(Event{Source: "rules", Key: "load", Severity: SeverityAlert})
+	assert.Len(t, p.queue, 3)
+
+	// the events older than the debounce interval are dropped
+	p.lastPrune = time.Time{}
+	p.lastSent["jobmon|backup|1"] = sentEvent{severity: SeverityAlert, at: time.Now().Add(-2 * time.Hour)}
+	p.Publish(Event{Source: "cpu", Key: "idle.avg1", Severity: SeverityWarning})
+	assert.NotContains(t, p.lastSent, "jobmon|backup|1")
+	assert.Contains(t, p.lastSent, "rules|load")
+}
+
+func TestEmitWithoutPublisher(t *testing.T) {
+	SetDefault(nil)
+	Emit(Event{Source: "raid", Key: "md0", Severity: SeverityAlert})
+}
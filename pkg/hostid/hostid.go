@@ -0,0 +1,266 @@
+// Package hostid keeps a UUID of the host that stays the same across restarts and detects when the host was cloned,
+// e.g. when several VMs are started from the same image including the cagent state
+package hostid
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const stateFilePermissions = 0600
+
+// Identifiers are the identifiers of the host the UUID is cross-checked against. Empty ones are unknown, e.g. not readable
+type Identifiers struct {
+	// ProductUUID is the DMI product UUID set by the vendor or the hypervisor
+	ProductUUID string `json:"product_uuid,omitempty"`
+	// MachineID is /etc/machine-id on Linux and the ID of the OS installation on other systems
+	MachineID string `json:"machine_id,omitempty"`
+	// MACs are the globally administered MAC addresses of the network interfaces, sorted
+	MACs []string `json:"macs,omitempty"`
+}
+
+// Identity is the persisted state
+type Identity struct {
+	UUID        string      `json:"uuid"`
+	CreatedAt   int64       `json:"created_at"`
+	Identifiers Identifiers `json:"identifiers"`
+	// PreviousUUID is the UUID used before a clone was detected
+	PreviousUUID string `json:"previous_uuid,omitempty"`
+}
+
+// Clone describes why the host is considered a clone
+type Clone struct {
+	// Of is the UUID of the host the state was copied from
+	Of string
+	// Changed lists the identifiers that differ from the stored ones
+	Changed []string
+	// KeptUUID is set if all MAC addresses changed, but the product UUID and the machine ID didn't.
+	// The network cards may have been replaced or the machine ID was copied as well, the UUID is not changed then
+	KeptUUID bool
+}
+
+// Load reads the identity from the file or creates a new one with a random UUID at the first start.
+// If the stored identifiers don't match the current ones in a way that suggests the host was cloned,
+// a new UUID is assigned and the clone is returned. The file is updated with the current identifiers.
+// A corrupt file is replaced with a new identity, the error is returned along with it.
+// If the file can't be read or written, the error is returned and the identity is not persisted
+func Load(path string, current Identifiers) (*Identity, *Clone, error) {
+	stored, readErr := read(path)
+	if readErr != nil && !os.IsNotExist(errors.Cause(readErr)) && !isCorrupt(readErr) {
+		return nil, nil, readErr
+	}
+
+	var err error
+	var clone *Clone
+	identity := stored
+	if identity == nil {
+		identity, err = newIdentity(current)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if changed, suspected := compare(stored.Identifiers, current); suspected {
+		clone = &Clone{Of: stored.UUID, Changed: changed}
+		identity, err = newIdentity(current)
+		if err != nil {
+			return nil, nil, err
+		}
+		identity.PreviousUUID = stored.UUID
+	} else {
+		if len(changed) > 0 {
+			clone = &Clone{Of: stored.UUID, Changed: changed, KeptUUID: true}
+		}
+		// e.g. a replaced network card or identifiers that were not readable before
+		merged := *stored
+		merged.Identifiers = merge(stored.Identifiers, current)
+		identity = &merged
+	}
+
+	if stored != nil && stored.UUID == identity.UUID && sameIdentifiers(stored.Identifiers, identity.Identifiers) {
+		return identity, clone, nil
+	}
+
+	if err = write(path, identity); err != nil {
+		return identity, clone, err
+	}
+	if isCorrupt(readErr) {
+		// keeping the corrupt file would leave the host without a UUID for good
+		return identity, clone, errors.Wrap(readErr, "replaced the host identity with a new one")
+	}
+
+	return identity, clone, nil
+}
+
+// compare returns the changed identifiers. The product UUID and the machine ID are stable for the lifetime of a host,
+// if either of them changed the host was most likely cloned. MAC addresses only decide if nothing else is known
+func compare(stored, current Identifiers) (changed []string, suspected bool) {
+	strong := 0
+	if stored.ProductUUID != "" && current.ProductUUID != "" {
+		strong++
+		if !strings.EqualFold(stored.ProductUUID, current.ProductUUID) {
+			changed = append(changed, "product_uuid")
+		}
+	}
+	if stored.MachineID != "" && current.MachineID != "" {
+		strong++
+		if stored.MachineID != current.MachineID {
+			changed = append(changed, "machine_id")
+		}
+	}
+	if len(changed) > 0 {
+		return changed, true
+	}
+
+	if len(stored.MACs) > 0 && len(current.MACs) > 0 && !shareAny(stored.MACs, current.MACs) {
+		changed = append(changed, "macs")
+		return changed, strong == 0
+	}
+
+	return changed, false
+}
+
+func merge(stored, current Identifiers) Identifiers {
+	merged := current
+	if merged.ProductUUID == "" {
+		merged.ProductUUID = stored.ProductUUID
+	}
+	if merged.MachineID == "" {
+		merged.MachineID = stored.MachineID
+	}
+	if len(merged.MACs) == 0 {
+		merged.MACs = stored.MACs
+	}
+	return merged
+}
+
+func shareAny(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sameIdentifiers(a, b Identifiers) bool {
+	if a.ProductUUID != b.ProductUUID || a.MachineID != b.MachineID || len(a.MACs) != len(b.MACs) {
+		return false
+	}
+	for i := range a.MACs {
+		if a.MACs[i] != b.MACs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func newIdentity(current Identifiers) (*Identity, error) {
+	uuid, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{UUID: uuid, CreatedAt: time.Now().Unix(), Identifiers: current}, nil
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", errors.Wrap(err, "failed to generate the host UUID")
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// corruptError is returned by read if the file doesn't contain a valid identity
+type corruptError struct {
+	err error
+}
+
+func (e corruptError) Error() string {
+	return e.err.Error()
+}
+
+func isCorrupt(err error) bool {
+	_, corrupt := errors.Cause(err).(corruptError)
+	return corrupt
+}
+
+func read(path string) (*Identity, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var identity Identity
+	if err := json.Unmarshal(data, &identity); err != nil {
+		return nil, errors.WithStack(corruptError{errors.Wrap(err, "while reading the host identity")})
+	}
+	if identity.UUID == "" {
+		return nil, errors.WithStack(corruptError{errors.New("while reading the host identity: uuid is missing")})
+	}
+
+	return &identity, nil
+}
+
+func write(path string, identity *Identity) error {
+	data, err := json.Marshal(identity)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.Wrap(err, "while saving the host identity")
+	}
+
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, stateFilePermissions); err != nil {
+		return errors.Wrap(err, "while saving the host identity")
+	}
+
+	return errors.Wrap(os.Rename(tmp, path), "while saving the host identity")
+}
+
+// Current reads the identifiers of this host, the ones that can't be read are left empty
+func Current() Identifiers {
+	return Identifiers{
+		ProductUUID: productUUID(),
+		MachineID:   machineID(),
+		MACs:        macAddresses(),
+	}
+}
+
+// macAddresses returns the globally administered MAC addresses. Locally administered ones are usually random,
+// e.g. of docker bridges and veth pairs, and would change without the host changing
+func macAddresses() []string {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+
+	var macs []string
+	for _, iface := range interfaces {
+		mac := iface.HardwareAddr
+		if iface.Flags&net.FlagLoopback != 0 || len(mac) == 0 || mac[0]&0x02 != 0 {
+			continue
+		}
+		macs = append(macs, mac.String())
+	}
+	sort.Strings(macs)
+
+	return macs
+}
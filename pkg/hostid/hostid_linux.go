@@ -0,0 +1,28 @@
+// +build linux
+
+package hostid
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+// productUUID is readable by root only, cagent running as its own user falls back to the other identifiers
+func productUUID() string {
+	return readTrimmed("/sys/class/dmi/id/product_uuid")
+}
+
+func machineID() string {
+	if id := readTrimmed("/etc/machine-id"); id != "" {
+		return id
+	}
+	return readTrimmed("/var/lib/dbus/machine-id")
+}
+
+func readTrimmed(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(string(data)))
+}
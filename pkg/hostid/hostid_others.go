@@ -0,0 +1,29 @@
+// +build !linux
+
+package hostid
+
+import (
+	"context"
+	"time"
+
+	"github.com/shirou/gopsutil/host"
+)
+
+const hostInfoTimeout = 10 * time.Second
+
+// productUUID is not read separately, the host ID below is the hardware UUID on macOS
+func productUUID() string {
+	return ""
+}
+
+// machineID returns the MachineGuid of the installation on Windows and IOPlatformUUID on macOS
+func machineID() string {
+	ctx, cancel := context.WithTimeout(context.Background(), hostInfoTimeout)
+	defer cancel()
+
+	info, err := host.InfoWithContext(ctx)
+	if err != nil {
+		return ""
+	}
+	return info.HostID
+}
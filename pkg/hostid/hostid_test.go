@@ -0,0 +1,94 @@
+package hostid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostid")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "host_identity.json")
+
+	ids := Identifiers{ProductUUID: "p1", MachineID: "m1", MACs: []string{"00:11:22:33:44:55"}}
+	first, clone, err := Load(path, ids)
+	require.NoError(t, err)
+	assert.Nil(t, clone)
+	assert.Len(t, first.UUID, 36)
+
+	// the UUID survives restarts and a replaced network card, which is reported once
+	ids.MACs = []string{"00:11:22:33:44:66"}
+	identity, clone, err := Load(path, ids)
+	require.NoError(t, err)
+	if assert.NotNil(t, clone) {
+		assert.True(t, clone.KeptUUID)
+		assert.Equal(t, []string{"macs"}, clone.Changed)
+	}
+	assert.Equal(t, first.UUID, identity.UUID)
+
+	identity, clone, err = Load(path, ids)
+	require.NoError(t, err)
+	assert.Nil(t, clone)
+	assert.Equal(t, first.UUID, identity.UUID)
+
+	// identifiers that can't be read, e.g. without root, don't count as a change
+	identity, clone, err = Load(path, Identifiers{MachineID: "m1"})
+	require.NoError(t, err)
+	assert.Nil(t, clone)
+	assert.Equal(t, first.UUID, identity.UUID)
+	assert.Equal(t, "p1", identity.Identifiers.ProductUUID)
+
+	// a copied state with a different machine ID is a clone
+	cloned, clone, err := Load(path, Identifiers{ProductUUID: "p1", MachineID: "m2"})
+	require.NoError(t, err)
+	if assert.NotNil(t, clone) {
+		assert.Equal(t, first.UUID, clone.Of)
+		assert.Equal(t, []string{"machine_id"}, clone.Changed)
+	}
+	assert.NotEqual(t, first.UUID, cloned.UUID)
+	assert.Equal(t, first.UUID, cloned.PreviousUUID)
+
+	identity, clone, err = Load(path, Identifiers{ProductUUID: "p1", MachineID: "m2"})
+	require.NoError(t, err)
+	assert.Nil(t, clone)
+	assert.Equal(t, cloned.UUID, identity.UUID)
+}
+
+func TestLoadCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostid")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "host_identity.json")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte(`{"uuid": "1234`), 0600))
+
+	identity, clone, err := Load(path, Identifiers{MachineID: "m1"})
+	assert.Error(t, err)
+	assert.Nil(t, clone)
+	require.NotNil(t, identity)
+	assert.Len(t, identity.UUID, 36)
+
+	// the new identity was written over the corrupt one
+	loaded, _, err := Load(path, Identifiers{MachineID: "m1"})
+	require.NoError(t, err)
+	assert.Equal(t, identity.UUID, loaded.UUID)
+}
+
+func TestCompare(t *testing.T) {
+	_, suspected := compare(Identifiers{MACs: []string{"a"}}, Identifiers{MACs: []string{"b"}})
+	assert.True(t, suspected, "the MAC addresses decide if nothing else is known")
+
+	changed, suspected := compare(Identifiers{MachineID: "m", MACs: []string{"a"}}, Identifiers{MachineID: "m", MACs: []string{"b"}})
+	assert.False(t, suspected)
+	assert.Equal(t, []string{"macs"}, changed)
+
+	changed, suspected = compare(Identifiers{ProductUUID: "ABC", MachineID: "m"}, Identifiers{ProductUUID: "abc", MachineID: "m"})
+	assert.False(t, suspected)
+	assert.Empty(t, changed)
+}
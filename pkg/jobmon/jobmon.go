@@ -70,6 +70,11 @@ func newJobRun(cfg *JobRunConfig) *JobRun {
 	}
 }
 
+// Failed returns true if the job exited with a non-zero code or could not be run
+func (r *JobRun) Failed() bool {
+	return r.ExitCode == nil || *r.ExitCode != 0 || len(r.Errors) > 0
+}
+
 func (r *JobRun) AddError(msg string) {
 	r.Errors = append(r.Errors, msg)
 }
@@ -0,0 +1,233 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/monitoring"
+)
+
+const modulesKey = "modules"
+
+// Point is a single numeric value of the measurements map split into a measurement name, a field and tags.
+// e.g. "fs.free_B./var" becomes {Measurement: "fs", Field: "free_B", Tags: {"mountpoint": "/var"}}
+type Point struct {
+	Measurement string
+	Field       string
+	Tags        map[string]string
+	Value       float64
+}
+
+// tagByMeasurement defines the measurements that carry the name of an object after the field name
+var tagByMeasurement = map[string]string{
+	"fs":  "mountpoint",
+	"net": "interface",
+}
+
+// Flatten converts all numeric values of the measurements map into points.
+// Values that are not numbers (strings, lists, nested objects, nil) are skipped.
+// The result is sorted by measurement, field and tags
+func Flatten(m common.MeasurementsMap) []Point {
+	var points []Point
+
+	for key, value := range m {
+		if reports, isModules := value.([]*monitoring.ModuleReport); isModules && key == modulesKey {
+			points = append(points, FlattenModuleReports(reports)...)
+			continue
+		}
+
+		v, ok := toFloat(value)
+		if !ok {
+			continue
+		}
+
+		points = append(points, splitKey(key, v))
+	}
+
+	sortPoints(points)
+	return points
+}
+
+// FlattenModuleReports converts module reports into points of the "module" measurement tagged by the report name.
+// Each report provides the number of alerts and warnings and all its numeric measurements as fields,
+// the keys of nested measurements are joined with "_"
+func FlattenModuleReports(reports []*monitoring.ModuleReport) []Point {
+	var points []Point
+
+	for _, r := range reports {
+		if r == nil {
+			continue
+		}
+
+		tags := map[string]string{"name": r.Name}
+		points = append(points,
+			Point{Measurement: "module", Field: "alerts", Tags: tags, Value: float64(len(r.Alerts))},
+			Point{Measurement: "module", Field: "warnings", Tags: tags, Value: float64(len(r.Warnings))},
+		)
+
+		flattenNested("", r.Measurements, func(field string, v float64) {
+			points = append(points, Point{Measurement: "module", Field: field, Tags: tags, Value: v})
+		})
+	}
+
+	return points
+}
+
+func flattenNested(prefix string, m map[string]interface{}, f func(field string, v float64)) {
+	for k, value := range m {
+		field := k
+		if prefix != "" {
+			field = prefix + "_" + k
+		}
+
+		if nested, isMap := toMap(value); isMap {
+			flattenNested(field, nested, f)
+			continue
+		}
+
+		if v, ok := toFloat(value); ok {
+			f(field, v)
+		}
+	}
+}
+
+func toMap(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case common.MeasurementsMap:
+		return v, true
+	case map[string]int:
+		m := make(map[string]interface{}, len(v))
+		for k, i := range v {
+			m[k] = i
+		}
+		return m, true
+	case map[string]float64:
+		m := make(map[string]interface{}, len(v))
+		for k, i := range v {
+			m[k] = i
+		}
+		return m, true
+	}
+
+	return nil, false
+}
+
+func splitKey(key string, value float64) Point {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) == 1 {
+		return Point{Measurement: "cagent", Field: key, Tags: map[string]string{}, Value: value}
+	}
+
+	p := Point{Measurement: parts[0], Tags: map[string]string{}, Value: value}
+	rest := parts[1]
+
+	switch p.Measurement {
+	case "cpu":
+		p.Field, p.Tags = splitCPUKey(rest)
+		return p
+	}
+
+	if tagName, hasTag := tagByMeasurement[p.Measurement]; hasTag {
+		// the object name goes after the first dot and may contain dots itself,
+		// totals like "net.total_in_B_per_s" don't have it
+		fieldAndObject := strings.SplitN(rest, ".", 2)
+		p.Field = fieldAndObject[0]
+		if len(fieldAndObject) == 2 {
+			p.Tags[tagName] = fieldAndObject[1]
+		}
+		return p
+	}
+
+	p.Field = strings.Replace(rest, ".", "_", -1)
+	return p
+}
+
+// splitCPUKey handles "util.<type>.<minutes>.<core|total>" and "load.avg.<minutes>"
+func splitCPUKey(key string) (string, map[string]string) {
+	parts := strings.Split(key, ".")
+	switch {
+	case len(parts) == 4 && parts[0] == "util":
+		return "util_percent", map[string]string{
+			"type":        parts[1],
+			"avg_minutes": parts[2],
+			"core":        parts[3],
+		}
+	case len(parts) == 3 && parts[0] == "load" && parts[1] == "avg":
+		return "load_avg", map[string]string{
+			"avg_minutes": parts[2],
+		}
+	}
+
+	return strings.Replace(key, ".", "_", -1), map[string]string{}
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+func sortPoints(points []Point) {
+	sort.SliceStable(points, func(i, j int) bool {
+		if points[i].Measurement != points[j].Measurement {
+			return points[i].Measurement < points[j].Measurement
+		}
+		if points[i].Field != points[j].Field {
+			return points[i].Field < points[j].Field
+		}
+		return TagsString(points[i].Tags) < TagsString(points[j].Tags)
+	})
+}
+
+// TagsString returns tags as a sorted k=v list to be used for grouping and ordering
+func TagsString(tags map[string]string) string {
+	keys := SortedTagKeys(tags)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+tags[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// SortedTagKeys returns tag names in lexical order
+func SortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var graphiteInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_\-]`)
+
+// GraphitePath returns the dot separated metric path of the point:
+// <prefix>.<host>.<measurement>[.<tag values sorted by tag name>].<field>
+// e.g. cagent.myhost.fs.var_lib.free_B for the field free_B of the fs measurement with the mountpoint /var/lib
+func GraphitePath(prefix, host string, p Point) string {
+	var segments []string
+	if prefix != "" {
+		segments = append(segments, prefix)
+	}
+	if host != "" {
+		segments = append(segments, GraphiteSegment(host))
+	}
+	segments = append(segments, GraphiteSegment(p.Measurement))
+
+	for _, k := range SortedTagKeys(p.Tags) {
+		segments = append(segments, GraphiteSegment(p.Tags[k]))
+	}
+
+	segments = append(segments, GraphiteSegment(p.Field))
+	return strings.Join(segments, ".")
+}
+
+// GraphiteSegment makes a string safe to be used as a single element of a Graphite path.
+// Dots, slashes and other special characters are replaced with "_", the "/" mountpoint becomes "root"
+func GraphiteSegment(s string) string {
+	if s == "/" {
+		return "root"
+	}
+
+	s = strings.Trim(s, "/")
+	if s == "" {
+		return "_"
+	}
+
+	return graphiteInvalidChars.ReplaceAllString(s, "_")
+}
+
+// WriteGraphite writes points in the Graphite plaintext protocol: "<path> <value> <timestamp>\n"
+func WriteGraphite(w io.Writer, points []Point, prefix, host string, timestamp int64) error {
+	bw := bufio.NewWriter(w)
+	ts := strconv.FormatInt(timestamp, 10)
+
+	for _, p := range points {
+		bw.WriteString(GraphitePath(prefix, host, p))
+		bw.WriteByte(' ')
+		bw.WriteString(strconv.FormatFloat(p.Value, 'f', -1, 64))
+		bw.WriteByte(' ')
+		bw.WriteString(ts)
+		bw.WriteByte('\n')
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxKeyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// WriteInfluxLineProtocol writes points in the InfluxDB line protocol.
+// Points with the same measurement and tags are written as a single line with multiple fields,
+// timestamp is in the precision expected by the receiver, e.g. seconds for precision=s
+func WriteInfluxLineProtocol(w io.Writer, points []Point, extraTags map[string]string, timestamp int64) error {
+	bw := bufio.NewWriter(w)
+
+	type series struct {
+		measurement string
+		tags        map[string]string
+		fields      []Point
+	}
+
+	var order []string
+	seriesByKey := make(map[string]*series)
+	for _, p := range points {
+		tags := mergeTags(p.Tags, extraTags)
+		key := p.Measurement + "," + TagsString(tags)
+		s, exists := seriesByKey[key]
+		if !exists {
+			s = &series{measurement: p.Measurement, tags: tags}
+			seriesByKey[key] = s
+			order = append(order, key)
+		}
+		s.fields = append(s.fields, p)
+	}
+
+	ts := strconv.FormatInt(timestamp, 10)
+	for _, key := range order {
+		s := seriesByKey[key]
+		bw.WriteString(influxMeasurementEscaper.Replace(s.measurement))
+		for _, k := range SortedTagKeys(s.tags) {
+			if s.tags[k] == "" {
+				// empty tag values are not allowed by the line protocol
+				continue
+			}
+			bw.WriteByte(',')
+			bw.WriteString(influxKeyEscaper.Replace(k))
+			bw.WriteByte('=')
+			bw.WriteString(influxKeyEscaper.Replace(s.tags[k]))
+		}
+
+		for i, f := range s.fields {
+			if i == 0 {
+				bw.WriteByte(' ')
+			} else {
+				bw.WriteByte(',')
+			}
+			bw.WriteString(influxKeyEscaper.Replace(f.Field))
+			bw.WriteByte('=')
+			bw.WriteString(strconv.FormatFloat(f.Value, 'f', -1, 64))
+		}
+
+		bw.WriteByte(' ')
+		bw.WriteString(ts)
+		bw.WriteByte('\n')
+	}
+
+	return bw.Flush()
+}
+
+func mergeTags(tags, extraTags map[string]string) map[string]string {
+	if len(extraTags) == 0 {
+		return tags
+	}
+
+	merged := make(map[string]string, len(tags)+len(extraTags))
+	for k, v := range extraTags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return merged
+}
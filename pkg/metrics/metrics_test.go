@@ -0,0 +1,121 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/monitoring"
+)
+
+func TestFlatten(t *testing.T) {
+	m := common.MeasurementsMap{
+		"fs.free_B./":              float64(1024),
+		"fs.free_percent./var/lib": 12.5,
+		"fs.total_read_B_per_s":    0.0,
+		"net.in_B_per_s.eth0.100":  100,
+		"cpu.util.idle.1.total":    97.5,
+		"cpu.load.avg.5":           0.25,
+		"cpu.util.user.1.0":        nil,
+		"mem.total_B":              uint64(2048),
+		"system.fqdn":              "host.example.com",
+		"cagent.success":           1,
+		"operation_mode":           "full",
+	}
+
+	points := Flatten(m)
+	assert.Equal(t, []Point{
+		{Measurement: "cagent", Field: "success", Tags: map[string]string{}, Value: 1},
+		{Measurement: "cpu", Field: "load_avg", Tags: map[string]string{"avg_minutes": "5"}, Value: 0.25},
+		{Measurement: "cpu", Field: "util_percent", Tags: map[string]string{"type": "idle", "avg_minutes": "1", "core": "total"}, Value: 97.5},
+		{Measurement: "fs", Field: "free_B", Tags: map[string]string{"mountpoint": "/"}, Value: 1024},
+		{Measurement: "fs", Field: "free_percent", Tags: map[string]string{"mountpoint": "/var/lib"}, Value: 12.5},
+		{Measurement: "fs", Field: "total_read_B_per_s", Tags: map[string]string{}, Value: 0},
+		{Measurement: "mem", Field: "total_B", Tags: map[string]string{}, Value: 2048},
+		{Measurement: "net", Field: "in_B_per_s", Tags: map[string]string{"interface": "eth0.100"}, Value: 100},
+	}, points)
+}
+
+func TestWritePrometheus(t *testing.T) {
+	m := common.MeasurementsMap{
+		"fs.free_B./":          float64(1024),
+		"fs.free_B./mnt/\"x\"": float64(10),
+		"net.in_B_per_s.eth0":  100,
+	}
+
+	buf := &bytes.Buffer{}
+	err := WritePrometheus(buf, Flatten(m))
+	assert.NoError(t, err)
+
+	expected := `# HELP cagent_fs_free_bytes cagent measurement fs.free_B
+# TYPE cagent_fs_free_bytes gauge
+cagent_fs_free_bytes{mountpoint="/"} 1024
+cagent_fs_free_bytes{mountpoint="/mnt/\"x\""} 10
+# HELP cagent_net_in_bytes_per_second cagent measurement net.in_B_per_s
+# TYPE cagent_net_in_bytes_per_second gauge
+cagent_net_in_bytes_per_second{interface="eth0"} 100
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestFlattenModules(t *testing.T) {
+	m := common.MeasurementsMap{
+		"modules": []*monitoring.ModuleReport{
+			{
+				Name:     "mysql",
+				Alerts:   []monitoring.Alert{"down"},
+				Warnings: []monitoring.Warning{},
+				Measurements: map[string]interface{}{
+					"connections": 5,
+					"innodb":      map[string]interface{}{"reads_per_s": 1.5, "status": "ok"},
+				},
+			},
+		},
+	}
+
+	points := Flatten(m)
+	tags := map[string]string{"name": "mysql"}
+	assert.Equal(t, []Point{
+		{Measurement: "module", Field: "alerts", Tags: tags, Value: 1},
+		{Measurement: "module", Field: "connections", Tags: tags, Value: 5},
+		{Measurement: "module", Field: "innodb_reads_per_s", Tags: tags, Value: 1.5},
+		{Measurement: "module", Field: "warnings", Tags: tags, Value: 0},
+	}, points)
+}
+
+func TestWriteInfluxLineProtocol(t *testing.T) {
+	m := common.MeasurementsMap{
+		"fs.free_B./var lib":       float64(1024),
+		"fs.free_percent./var lib": 12.5,
+		"mem.total_B":              uint64(2048),
+	}
+
+	buf := &bytes.Buffer{}
+	err := WriteInfluxLineProtocol(buf, Flatten(m), map[string]string{"host": "h1"}, 1600000000)
+	assert.NoError(t, err)
+
+	expected := `fs,host=h1,mountpoint=/var\ lib free_B=1024,free_percent=12.5 1600000000
+mem,host=h1 total_B=2048 1600000000
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestWriteGraphite(t *testing.T) {
+	m := common.MeasurementsMap{
+		"fs.free_B./":         float64(1024),
+		"fs.free_B./var/lib":  float64(10),
+		"net.in_B_per_s.eth0": 100,
+	}
+
+	buf := &bytes.Buffer{}
+	err := WriteGraphite(buf, Flatten(m), "cagent", "host.example.com", 1600000000)
+	assert.NoError(t, err)
+
+	expected := `cagent.host_example_com.fs.root.free_B 1024 1600000000
+cagent.host_example_com.fs.var_lib.free_B 10 1600000000
+cagent.host_example_com.net.eth0.in_B_per_s 100 1600000000
+`
+	assert.Equal(t, expected, buf.String())
+}
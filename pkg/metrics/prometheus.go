@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const prometheusNamespace = "cagent"
+
+var prometheusInvalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// unit suffixes used in cagent keys and their Prometheus base unit replacements
+var prometheusUnitSuffixes = []struct {
+	suffix      string
+	replacement string
+}{
+	{"_B_per_s", "_bytes_per_second"},
+	{"_per_s", "_per_second"},
+	{"_B", "_bytes"},
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// PrometheusName returns the metric name of the point following the Prometheus naming conventions,
+// e.g. cagent_fs_free_bytes for the field free_B of the fs measurement
+func PrometheusName(p Point) string {
+	field := p.Field
+	for _, u := range prometheusUnitSuffixes {
+		if strings.HasSuffix(field, u.suffix) {
+			field = strings.TrimSuffix(field, u.suffix) + u.replacement
+			break
+		}
+	}
+
+	name := prometheusNamespace + "_" + p.Measurement + "_" + field
+	return prometheusInvalidNameChars.ReplaceAllString(name, "_")
+}
+
+// WritePrometheus writes points in the Prometheus text exposition format.
+// All values are exposed as gauges: cagent reports current values and already calculated rates, not counters
+func WritePrometheus(w io.Writer, points []Point) error {
+	bw := bufio.NewWriter(w)
+
+	var lastName string
+	for _, p := range points {
+		name := PrometheusName(p)
+		if name != lastName {
+			fmt.Fprintf(bw, "# HELP %s cagent measurement %s.%s\n", name, p.Measurement, p.Field)
+			fmt.Fprintf(bw, "# TYPE %s gauge\n", name)
+			lastName = name
+		}
+
+		bw.WriteString(name)
+		if len(p.Tags) > 0 {
+			bw.WriteByte('{')
+			for i, k := range SortedTagKeys(p.Tags) {
+				if i > 0 {
+					bw.WriteByte(',')
+				}
+				fmt.Fprintf(bw, `%s="%s"`, prometheusInvalidNameChars.ReplaceAllString(k, "_"), prometheusLabelEscaper.Replace(p.Tags[k]))
+			}
+			bw.WriteByte('}')
+		}
+		bw.WriteByte(' ')
+		bw.WriteString(strconv.FormatFloat(p.Value, 'g', -1, 64))
+		bw.WriteByte('\n')
+	}
+
+	return bw.Flush()
+}
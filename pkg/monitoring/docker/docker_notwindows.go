@@ -3,6 +3,7 @@
 package docker
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -28,7 +29,7 @@ var dockerIsAvailable bool
 var dockerAvailabilityLastRequestedAt *time.Time
 
 // isDockerAvailable maintains a simple cache to prevent executing shell commands too often
-func isDockerAvailable() bool {
+func isDockerAvailable(ctx context.Context) bool {
 	now := time.Now()
 	if dockerAvailabilityLastRequestedAt != nil &&
 		now.Sub(*dockerAvailabilityLastRequestedAt) < dockerAvailabilityCheckCacheExpiration {
@@ -44,7 +45,7 @@ func isDockerAvailable() bool {
 			dockerPrefix = "sudo "
 		}
 
-		_, err := common.RunCommandWithTimeout(cmdExecTimeout, "/bin/sh", "-c", dockerPrefix+"docker info")
+		_, err := common.RunCommandWithTimeoutContext(ctx, cmdExecTimeout, "/bin/sh", "-c", dockerPrefix+"docker info")
 		if err != nil {
 			log.WithError(err).Debug("while executing 'docker info' to check if docker is available")
 		}
@@ -81,13 +82,13 @@ func containerStatusToState(status string) string {
 	return "unknown"
 }
 
-// ListContainers returns the parsed output of 'docker ps' command
-func ListContainers() (map[string]interface{}, error) {
-	if !isDockerAvailable() {
+// ListContainers returns the parsed output of 'docker ps' command. The command is killed when ctx is done
+func ListContainers(ctx context.Context) (map[string]interface{}, error) {
+	if !isDockerAvailable(ctx) {
 		return nil, ErrorDockerNotAvailable
 	}
 
-	out, err := common.RunCommandWithTimeout(cmdExecTimeout, "/bin/sh", "-c", "sudo docker ps -a --format \"{{ json . }}\"")
+	out, err := common.RunCommandWithTimeoutContext(ctx, cmdExecTimeout, "/bin/sh", "-c", "sudo docker ps -a --format \"{{ json . }}\"")
 	if err != nil {
 		if ee, ok := err.(*exec.ExitError); ok {
 			err = errors.New(ee.Error() + ": " + string(ee.Stderr))
@@ -127,7 +128,7 @@ func ListContainers() (map[string]interface{}, error) {
 
 // ContainerNameByID returns the name of a container identified by its id
 func ContainerNameByID(id string) (string, error) {
-	if !isDockerAvailable() {
+	if !isDockerAvailable(context.Background()) {
 		return "", ErrorDockerNotAvailable
 	}
 
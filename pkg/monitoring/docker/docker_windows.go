@@ -2,7 +2,9 @@
 
 package docker
 
-func ListContainers() (map[string]interface{}, error) {
+import "context"
+
+func ListContainers(_ context.Context) (map[string]interface{}, error) {
 	return nil, ErrorNotImplementedForOS
 }
 
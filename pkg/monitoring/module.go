@@ -25,6 +25,9 @@ type ModuleReport struct {
 	Warnings        []Warning              `json:"warnings"`
 	Message         string                 `json:"message,omitempty"`
 	Measurements    map[string]interface{} `json:"measurements,omitempty"`
+
+	// keys of the alerts and the warnings added with a key, by message
+	keys map[string]string
 }
 
 func (r *ModuleReport) AddAlert(alert string) {
@@ -35,6 +38,34 @@ func (r *ModuleReport) AddWarning(warn string) {
 	r.Warnings = append(r.Warnings, Warning(warn))
 }
 
+// AddAlertWithKey adds an alert whose message changes between the runs, e.g. because it contains a current value.
+// The key identifies the alert across the runs instead of its message
+func (r *ModuleReport) AddAlertWithKey(key, alert string) {
+	r.setKey(alert, key)
+	r.AddAlert(alert)
+}
+
+// AddWarningWithKey adds a warning identified across the runs by the key, see AddAlertWithKey
+func (r *ModuleReport) AddWarningWithKey(key, warn string) {
+	r.setKey(warn, key)
+	r.AddWarning(warn)
+}
+
+// Key returns the key of the alert or the warning message, the message itself if it was added without a key
+func (r *ModuleReport) Key(message string) string {
+	if key, exists := r.keys[message]; exists {
+		return key
+	}
+	return message
+}
+
+func (r *ModuleReport) setKey(message, key string) {
+	if r.keys == nil {
+		r.keys = make(map[string]string)
+	}
+	r.keys[message] = key
+}
+
 func NewReport(name string, t time.Time, cmd string) ModuleReport {
 	return ModuleReport{
 		Name:            name,
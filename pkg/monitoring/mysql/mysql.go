@@ -133,14 +133,14 @@ func (r *Mysql) Run() ([]*monitoring.ModuleReport, error) {
 
 	client, err := r.getClient()
 	if err != nil {
-		report.AddAlert(err.Error())
+		report.AddAlertWithKey("connect", err.Error())
 		return []*monitoring.ModuleReport{&report}, nil
 	}
 
 	statusTime := time.Now()
 	status, err := getStatus(client)
 	if err != nil {
-		report.AddAlert(fmt.Sprintf("failed to get status: %s", err.Error()))
+		report.AddAlertWithKey("status", fmt.Sprintf("failed to get status: %s", err.Error()))
 		return []*monitoring.ModuleReport{&report}, nil
 	}
 
@@ -89,7 +89,7 @@ func (r *RAID) Run() ([]*monitoring.ModuleReport, error) {
 
 		failedDevs := raidInfo.GetFailedDevices()
 		if len(failedDevs) > 0 {
-			report.AddAlert(fmt.Sprintf(
+			report.AddAlertWithKey(raidName+" failing devices", fmt.Sprintf(
 				"Raid %s degraded. Devices failing: %s.",
 				raidName,
 				strings.Join(failedDevs, ", "),
@@ -100,7 +100,7 @@ func (r *RAID) Run() ([]*monitoring.ModuleReport, error) {
 		detectedDeviceStatusesCount := len(raidInfo.Active) + len(raidInfo.Inactive)
 		numberOfMissingDevs := detectedDeviceStatusesCount - len(raidInfo.Devices)
 		if numberOfMissingDevs > 0 {
-			report.AddAlert(fmt.Sprintf("Raid %s degraded. Missing %d devices.", raidName, numberOfMissingDevs))
+			report.AddAlertWithKey(raidName+" missing devices", fmt.Sprintf("Raid %s degraded. Missing %d devices.", raidName, numberOfMissingDevs))
 			status = raidStatusDegraded
 		}
 
@@ -5,6 +5,7 @@ package services
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -41,8 +42,8 @@ type OpenRCService struct {
 }
 
 // systemdUnitFilesState provides the map of unit files states
-func systemdUnitFilesState() (map[string]string, error) {
-	cmd := exec.Command("systemctl",
+func systemdUnitFilesState(ctx context.Context) (map[string]string, error) {
+	cmd := exec.CommandContext(ctx, "systemctl",
 		"--type=service", // show only services(ignore .mount, .target, .path, .socket etc.)
 		"--all",          // show loaded but inactive services too
 		"--no-pager",     // disable results pagination
@@ -106,14 +107,14 @@ func systemdUnitFilesState() (map[string]string, error) {
 }
 
 // tryListSystemdServices list Systemd services via systemctl
-func tryListSystemdServices(autostartOnly bool) ([]SystemdService, error) {
+func tryListSystemdServices(ctx context.Context, autostartOnly bool) ([]SystemdService, error) {
 	// get the map of unit files states to merge it with unit states
-	unitFilesStateByName, err := systemdUnitFilesState()
+	unitFilesStateByName, err := systemdUnitFilesState(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	cmd := exec.Command("systemctl",
+	cmd := exec.CommandContext(ctx, "systemctl",
 		"--type=service", // show only services(ignore .mount, .target, .path, .socket etc.)
 		"--all",          // show loaded but inactive services too
 		"--no-pager",     // disable results pagination
@@ -193,8 +194,8 @@ func tryListSystemdServices(autostartOnly bool) ([]SystemdService, error) {
 }
 
 // tryListOpenRCServices list OpenRC services via `rc-status` command
-func tryListOpenRCServices() ([]OpenRCService, error) {
-	cmd := exec.Command("rc-status", "-qq", "--nocolor", "-a", "--servicelist")
+func tryListOpenRCServices(ctx context.Context) ([]OpenRCService, error) {
+	cmd := exec.CommandContext(ctx, "rc-status", "-qq", "--nocolor", "-a", "--servicelist")
 
 	setPathEnvVar(cmd)
 	var outb bytes.Buffer
@@ -229,8 +230,8 @@ func tryListOpenRCServices() ([]OpenRCService, error) {
 var sysVinitServiceRE = regexp.MustCompile(`^\s+\[\s+([\+\-\?]])\s+\]\s+(.*)$`)
 
 // tryListSysVinitServices list SysVinit services via `service --status-all`
-func tryListSysVinitServices() ([]SysVService, error) {
-	cmd := exec.Command("service",
+func tryListSysVinitServices(ctx context.Context) ([]SysVService, error) {
+	cmd := exec.CommandContext(ctx, "service",
 		"--status-all",
 	)
 
@@ -274,8 +275,8 @@ func tryListSysVinitServices() ([]SysVService, error) {
 }
 
 // ListUpstartServices list upstart services via `initctl list`. Returns []SysVService because Upstart is compatible with SysVInit and has the same details
-func ListUpstartServices() ([]SysVService, error) {
-	cmd := exec.Command("initctl",
+func ListUpstartServices(ctx context.Context) ([]SysVService, error) {
+	cmd := exec.CommandContext(ctx, "initctl",
 		"list",
 	)
 	setPathEnvVar(cmd)
@@ -334,11 +335,11 @@ func isOpenRC() bool {
 	return false
 }
 
-func isUpstart() bool {
+func isUpstart(ctx context.Context) bool {
 	if _, err := os.Stat("/sbin/upstart-udev-bridge"); err == nil {
 		return true
 	}
-	cmd := exec.Command("initctl", "--version")
+	cmd := exec.CommandContext(ctx, "initctl", "--version")
 	setPathEnvVar(cmd)
 	if out, err := cmd.Output(); err == nil {
 		if strings.Contains(string(out), "initctl (upstart") {
@@ -353,10 +354,10 @@ func setPathEnvVar(cmd *exec.Cmd) {
 	cmd.Env = append(cmd.Env, "PATH="+os.Getenv("PATH"))
 }
 
-func listSystemdServices(autostartOnly bool) ([]map[string]string, error) {
+func listSystemdServices(ctx context.Context, autostartOnly bool) ([]map[string]string, error) {
 	var servicesList []map[string]string
 
-	services, err := tryListSystemdServices(autostartOnly)
+	services, err := tryListSystemdServices(ctx, autostartOnly)
 	if err != nil {
 		return []map[string]string{}, err
 	}
@@ -376,10 +377,10 @@ func listSystemdServices(autostartOnly bool) ([]map[string]string, error) {
 	return servicesList, nil
 }
 
-func listOpenRCServices() ([]map[string]string, error) {
+func listOpenRCServices(ctx context.Context) ([]map[string]string, error) {
 	var servicesList []map[string]string
 
-	services, err := tryListOpenRCServices()
+	services, err := tryListOpenRCServices(ctx)
 	if err != nil {
 		return []map[string]string{}, err
 	}
@@ -396,8 +397,8 @@ func listOpenRCServices() ([]map[string]string, error) {
 	return servicesList, nil
 }
 
-func listSysVAndUpstartServicesCombined() []map[string]string {
-	sysVServices, err := tryListSysVinitServices()
+func listSysVAndUpstartServicesCombined(ctx context.Context) []map[string]string {
+	sysVServices, err := tryListSysVinitServices(ctx)
 	if err != nil {
 		// return map[string]map[string]string{}, err
 		// in case of error lets try to query other
@@ -417,8 +418,8 @@ func listSysVAndUpstartServicesCombined() []map[string]string {
 	}
 
 	// if we detect Upstart also add Upstart services to map
-	if isUpstart() {
-		upstartServices, err := ListUpstartServices()
+	if isUpstart(ctx) {
+		upstartServices, err := ListUpstartServices(ctx)
 		if err != nil {
 			if strings.Contains(err.Error(), "dbus") {
 				common.LogOncef(log.InfoLevel, "[Services] Upstart: monitoring of service might be incomplete due to missing dbus. Try to install the dbus package.")
@@ -450,8 +451,8 @@ func listSysVAndUpstartServicesCombined() []map[string]string {
 	return servicesList
 }
 
-// ListServices detect the linux system manager and parse/combine results
-func ListServices(autostartOnly bool) (map[string]interface{}, error) {
+// ListServices detect the linux system manager and parse/combine results. The commands are killed when ctx is done
+func ListServices(ctx context.Context, autostartOnly bool) (map[string]interface{}, error) {
 	if runtime.GOOS != "linux" {
 		return nil, ErrorNotImplementedForOS
 	}
@@ -461,7 +462,7 @@ func ListServices(autostartOnly bool) (map[string]interface{}, error) {
 
 	// first try to get Systemd services
 	if isSystemd() {
-		servicesList, err = listSystemdServices(autostartOnly)
+		servicesList, err = listSystemdServices(ctx, autostartOnly)
 		if err != nil {
 			log.WithError(err).Error("[Services] Systemd appears running but failed to list a services")
 		} else {
@@ -470,7 +471,7 @@ func ListServices(autostartOnly bool) (map[string]interface{}, error) {
 	}
 
 	if isOpenRC() {
-		servicesList, err = listOpenRCServices()
+		servicesList, err = listOpenRCServices(ctx)
 		if err != nil {
 			log.WithError(err).Error("[Services] error while trying to list open-rc services")
 		} else {
@@ -479,7 +480,7 @@ func ListServices(autostartOnly bool) (map[string]interface{}, error) {
 	}
 
 	// in case we failed to get systemd services, try to get services from SysV and Upstart
-	servicesList = listSysVAndUpstartServicesCombined()
+	servicesList = listSysVAndUpstartServicesCombined(ctx)
 
 	return map[string]interface{}{"list": servicesList}, nil
 }
@@ -3,6 +3,7 @@
 package services
 
 import (
+	"context"
 	"syscall"
 	"unsafe"
 
@@ -41,7 +42,7 @@ var serviceStateToStringMap = map[uint32]string{
 	windows.SERVICE_PAUSED:           "paused",
 }
 
-func ListServices(autoStartOnly bool) (map[string]interface{}, error) {
+func ListServices(_ context.Context, autoStartOnly bool) (map[string]interface{}, error) {
 	svcManager, err := mgr.Connect()
 	if err != nil {
 		return nil, err
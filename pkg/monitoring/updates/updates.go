@@ -4,6 +4,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -19,17 +20,45 @@ type Watcher struct {
 
 	lastError     error
 	interruptChan chan struct{}
+	refreshChan   chan struct{}
 }
 
+// watcherLock guards watcher, it's used by the collectors, the Hub actions and the shutdown concurrently
+var watcherLock sync.Mutex
 var watcher *Watcher
 
 func Shutdown() {
-	if watcher != nil {
-		watcher.Shutdown()
+	watcherLock.Lock()
+	w := watcher
+	watcher = nil
+	watcherLock.Unlock()
+
+	if w != nil {
+		w.Shutdown()
+	}
+}
+
+// Refresh makes the running watcher check for updates immediately. It returns false if there is no watcher
+func Refresh() bool {
+	watcherLock.Lock()
+	defer watcherLock.Unlock()
+
+	if watcher == nil {
+		return false
+	}
+
+	select {
+	case watcher.refreshChan <- struct{}{}:
+	default:
+		// a refresh is already pending
 	}
+	return true
 }
 
 func GetWatcher(fetchTimeout, checkInterval uint32) *Watcher {
+	watcherLock.Lock()
+	defer watcherLock.Unlock()
+
 	if watcher != nil {
 		return watcher
 	}
@@ -39,6 +68,7 @@ func GetWatcher(fetchTimeout, checkInterval uint32) *Watcher {
 		checkInterval:   time.Duration(int64(time.Second) * int64(checkInterval)),
 		lastFetchedInfo: nil,
 		interruptChan:   make(chan struct{}),
+		refreshChan:     make(chan struct{}, 1),
 	}
 	go watcher.Run()
 	return watcher
@@ -68,6 +98,8 @@ func (w *Watcher) Run() {
 		select {
 		case <-w.interruptChan:
 			return
+		case <-w.refreshChan:
+			continue
 		case <-time.After(w.checkInterval):
 			continue
 		}
@@ -3,11 +3,33 @@
 package updates
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+func TestRefreshAndShutdown(t *testing.T) {
+	assert.False(t, Refresh())
+
+	watcherLock.Lock()
+	watcher = &Watcher{interruptChan: make(chan struct{}, 1), refreshChan: make(chan struct{}, 1)}
+	watcherLock.Unlock()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Refresh()
+		}()
+	}
+	Shutdown()
+	wg.Wait()
+
+	assert.False(t, Refresh())
+}
+
 func TestTryParseMajorVersion(t *testing.T) {
 	const invalidVal = -1
 	var testMap = map[string]int{
@@ -0,0 +1,265 @@
+// Package notifier sends local alerts to a webhook, e.g. a chat system or a ticketing endpoint, without the Hub
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Statuses of the notifications
+const (
+	StatusFiring   = "firing"
+	StatusResolved = "resolved"
+)
+
+// DefaultBodyTemplate is used if no body template is configured
+const DefaultBodyTemplate = `{"status": {{json .Status}}, "severity": {{json .Severity}}, "source": {{json .Source}}, ` +
+	`"host": {{json .Hostname}}, "message": {{json .Message}}, "timestamp": {{.Timestamp.Unix}}}`
+
+// queueSize is the number of notifications waiting to be sent, further ones are dropped
+const queueSize = 100
+
+// Alert is a condition reported by a module, a rule or a failed job
+type Alert struct {
+	// Key identifies the alert for the deduplication, it's not sent
+	Key      string
+	Source   string
+	Severity string
+	Message  string
+}
+
+// Notification is the data passed to the body template
+type Notification struct {
+	Alert
+	Status   string
+	Hostname string
+	// Timestamp is the time the alert fired or resolved
+	Timestamp time.Time
+	// FiredAt is the time the alert fired, also set for the resolved notifications
+	FiredAt time.Time
+}
+
+// Config of the webhook
+type Config struct {
+	URL           string
+	Method        string
+	Headers       map[string]string
+	BodyTemplate  string
+	Timeout       time.Duration
+	Retries       int
+	RetryInterval time.Duration
+}
+
+type activeAlert struct {
+	Alert
+	scope   string
+	firedAt time.Time
+}
+
+// Webhook sends a notification when an alert fires and when it is resolved. An alert that is still active is not sent again.
+// The active alerts are kept in memory only, they are sent again after a restart
+type Webhook struct {
+	cfg      Config
+	body     *template.Template
+	client   *http.Client
+	logger   *logrus.Logger
+	hostname string
+
+	mu     sync.Mutex
+	active map[string]activeAlert
+
+	queue   chan Notification
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// ParseBodyTemplate parses the body template. The function json encodes a value, e.g. {{json .Message}}
+func ParseBodyTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultBodyTemplate
+	}
+
+	return template.New("body").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+// NewWebhook creates the webhook and starts sending the notifications
+func NewWebhook(cfg Config, logger *logrus.Logger) (*Webhook, error) {
+	body, err := ParseBodyTemplate(cfg.BodyTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Method == "" {
+		cfg.Method = http.MethodPost
+	}
+
+	hostname, _ := os.Hostname()
+
+	w := &Webhook{
+		cfg:      cfg,
+		body:     body,
+		client:   &http.Client{Timeout: cfg.Timeout, Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		logger:   logger,
+		hostname: hostname,
+		active:   make(map[string]activeAlert),
+		queue:    make(chan Notification, queueSize),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go w.run()
+
+	return w, nil
+}
+
+// Sync fires the alerts of the scope that are not active yet and resolves the active ones that are missing in current
+func (w *Webhook) Sync(scope string, current []Alert) {
+	keys := make(map[string]bool, len(current))
+	for _, a := range current {
+		keys[a.Key] = true
+		w.fire(scope, a)
+	}
+
+	w.mu.Lock()
+	var resolved []string
+	for key, a := range w.active {
+		if a.scope == scope && !keys[key] {
+			resolved = append(resolved, key)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, key := range resolved {
+		w.Resolve(key)
+	}
+}
+
+// Fire sends the alert unless it's already active
+func (w *Webhook) Fire(a Alert) {
+	w.fire("", a)
+}
+
+func (w *Webhook) fire(scope string, a Alert) {
+	now := time.Now()
+
+	w.mu.Lock()
+	if _, exists := w.active[a.Key]; exists {
+		w.mu.Unlock()
+		return
+	}
+	w.active[a.Key] = activeAlert{Alert: a, scope: scope, firedAt: now}
+	w.mu.Unlock()
+
+	w.enqueue(Notification{Alert: a, Status: StatusFiring, Hostname: w.hostname, Timestamp: now, FiredAt: now})
+}
+
+// Resolve sends the resolved notification if the alert is active
+func (w *Webhook) Resolve(key string) {
+	w.mu.Lock()
+	a, exists := w.active[key]
+	delete(w.active, key)
+	w.mu.Unlock()
+
+	if exists {
+		w.enqueue(Notification{Alert: a.Alert, Status: StatusResolved, Hostname: w.hostname, Timestamp: time.Now(), FiredAt: a.firedAt})
+	}
+}
+
+// Shutdown stops sending, queued notifications are dropped
+func (w *Webhook) Shutdown() {
+	close(w.stop)
+	<-w.stopped
+}
+
+func (w *Webhook) enqueue(n Notification) {
+	select {
+	case w.queue <- n:
+	default:
+		w.logger.Warnf("webhook: queue is full, dropping the %s notification: %s", n.Status, n.Message)
+	}
+}
+
+func (w *Webhook) run() {
+	defer close(w.stopped)
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case n := <-w.queue:
+			w.sendWithRetries(n)
+		}
+	}
+}
+
+func (w *Webhook) sendWithRetries(n Notification) {
+	for attempt := 0; ; attempt++ {
+		err := w.send(n)
+		if err == nil {
+			return
+		}
+
+		if attempt >= w.cfg.Retries {
+			w.logger.WithError(err).Errorf("webhook: failed to send the %s notification, giving up: %s", n.Status, n.Message)
+			return
+		}
+
+		w.logger.WithError(err).Warnf("webhook: failed to send the %s notification, retrying in %v", n.Status, w.cfg.RetryInterval)
+		select {
+		case <-w.stop:
+			return
+		case <-time.After(w.cfg.RetryInterval):
+		}
+	}
+}
+
+func (w *Webhook) send(n Notification) error {
+	var body bytes.Buffer
+	if err := w.body.Execute(&body, n); err != nil {
+		return fmt.Errorf("failed to render the body: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-w.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequest(w.cfg.Method, w.cfg.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %s", w.cfg.URL, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhook(t *testing.T) {
+	received := make(chan map[string]interface{}, 10)
+	failures := 1
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("X-Token"))
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var body map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		received <- body
+	}))
+	defer endpoint.Close()
+
+	w, err := NewWebhook(Config{
+		URL:           endpoint.URL,
+		Headers:       map[string]string{"X-Token": "secret"},
+		Timeout:       time.Second,
+		Retries:       1,
+		RetryInterval: time.Millisecond,
+	}, logrus.StandardLogger())
+	assert.NoError(t, err)
+	defer w.Shutdown()
+
+	next := func() map[string]interface{} {
+		select {
+		case body := <-received:
+			return body
+		case <-time.After(5 * time.Second):
+			t.Fatal("no notification received")
+			return nil
+		}
+	}
+
+	degraded := Alert{Key: "raid|md0", Source: "raid", Severity: "alert", Message: `Raid md0 "degraded"`}
+	w.Sync("modules", []Alert{degraded})
+	body := next()
+	assert.Equal(t, "firing", body["status"])
+	assert.Equal(t, "raid", body["source"])
+	assert.Equal(t, `Raid md0 "degraded"`, body["message"])
+
+	// an active alert is not sent again
+	w.Sync("modules", []Alert{degraded})
+	w.Sync("rules", nil)
+	w.Sync("modules", nil)
+	body = next()
+	assert.Equal(t, "resolved", body["status"])
+	assert.Equal(t, `Raid md0 "degraded"`, body["message"])
+	assert.Len(t, received, 0)
+}
+
+func TestParseBodyTemplate(t *testing.T) {
+	_, err := ParseBodyTemplate("")
+	assert.NoError(t, err)
+
+	_, err = ParseBodyTemplate(`{"text": {{json .Message}`)
+	assert.Error(t, err)
+}
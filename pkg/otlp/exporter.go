@@ -0,0 +1,71 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Exporter sends OTLP messages to a collector using OTLP/HTTP with the binary protobuf encoding
+type Exporter struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+}
+
+// NewExporter creates the exporter for the base endpoint of the collector, e.g. http://localhost:4318.
+// The signal paths /v1/metrics and /v1/logs are appended to it
+func NewExporter(endpoint string, headers map[string]string, timeout time.Duration) *Exporter {
+	return &Exporter{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		headers:  headers,
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+func (e *Exporter) ExportMetrics(ctx context.Context, body []byte) error {
+	return e.post(ctx, MetricsPath, body)
+}
+
+func (e *Exporter) ExportLogs(ctx context.Context, body []byte) error {
+	return e.post(ctx, LogsPath, body)
+}
+
+func (e *Exporter) CloseIdleConnections() {
+	e.client.CloseIdleConnections()
+}
+
+func (e *Exporter) post(ctx context.Context, path string, body []byte) error {
+	url := e.endpoint + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to POST to %s", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
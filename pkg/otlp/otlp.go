@@ -0,0 +1,190 @@
+// Package otlp encodes measurements as OpenTelemetry OTLP protobuf messages and sends them using OTLP/HTTP.
+// Messages are encoded directly on the wire format to avoid depending on the generated OTLP proto packages,
+// only the fields used by cagent are written
+package otlp
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/securez-one/cagent/pkg/metrics"
+)
+
+const (
+	ScopeName = "cagent"
+
+	MetricsPath = "/v1/metrics"
+	LogsPath    = "/v1/logs"
+)
+
+// Severity is the OTLP SeverityNumber of a log record
+type Severity int
+
+const (
+	SeverityInfo  Severity = 9
+	SeverityWarn  Severity = 13
+	SeverityError Severity = 17
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "INFO"
+	case SeverityWarn:
+		return "WARN"
+	case SeverityError:
+		return "ERROR"
+	}
+	return ""
+}
+
+// LogRecord is a single OTLP log record with a string body
+type LogRecord struct {
+	Time       time.Time
+	Severity   Severity
+	Body       string
+	Attributes map[string]string
+}
+
+// unit suffixes used in cagent keys and their UCUM units
+var unitSuffixes = []struct {
+	suffix string
+	unit   string
+}{
+	{"_B_per_s", "By/s"},
+	{"_per_s", "1/s"},
+	{"_B", "By"},
+	{"_percent", "%"},
+}
+
+// MetricName returns the name of the OTLP metric for the point, e.g. cagent.fs.free_B
+func MetricName(p metrics.Point) string {
+	return ScopeName + "." + p.Measurement + "." + p.Field
+}
+
+// MetricUnit returns the UCUM unit derived from the field suffix or an empty string if unknown
+func MetricUnit(p metrics.Point) string {
+	for _, u := range unitSuffixes {
+		if strings.HasSuffix(p.Field, u.suffix) {
+			return u.unit
+		}
+	}
+	return ""
+}
+
+// EncodeMetricsRequest returns ExportMetricsServiceRequest with every point as a data point of a gauge.
+// Points are expected to be sorted like metrics.Flatten does, so the data points of one metric are adjacent
+func EncodeMetricsRequest(resource map[string]string, version string, points []metrics.Point, ts time.Time) []byte {
+	var scopeMetrics []byte
+	scopeMetrics = appendMessage(scopeMetrics, 1, encodeScope(version))
+
+	for i := 0; i < len(points); {
+		name := MetricName(points[i])
+
+		var gauge []byte
+		j := i
+		for ; j < len(points) && MetricName(points[j]) == name; j++ {
+			gauge = appendMessage(gauge, 1, encodeNumberDataPoint(points[j], ts))
+		}
+
+		var metric []byte
+		metric = appendString(metric, 1, name)
+		if unit := MetricUnit(points[i]); unit != "" {
+			metric = appendString(metric, 3, unit)
+		}
+		metric = appendMessage(metric, 5, gauge)
+
+		scopeMetrics = appendMessage(scopeMetrics, 2, metric)
+		i = j
+	}
+
+	var resourceMetrics []byte
+	resourceMetrics = appendMessage(resourceMetrics, 1, encodeResource(resource))
+	resourceMetrics = appendMessage(resourceMetrics, 2, scopeMetrics)
+
+	return appendMessage(nil, 1, resourceMetrics)
+}
+
+// EncodeLogsRequest returns ExportLogsServiceRequest containing the records
+func EncodeLogsRequest(resource map[string]string, version string, records []LogRecord) []byte {
+	var scopeLogs []byte
+	scopeLogs = appendMessage(scopeLogs, 1, encodeScope(version))
+
+	for _, r := range records {
+		var record []byte
+		record = appendFixed64(record, 1, uint64(r.Time.UnixNano()))
+		record = protowire.AppendTag(record, 2, protowire.VarintType)
+		record = protowire.AppendVarint(record, uint64(r.Severity))
+		record = appendString(record, 3, r.Severity.String())
+		record = appendMessage(record, 5, encodeStringValue(r.Body))
+		record = appendAttributes(record, 6, r.Attributes)
+		scopeLogs = appendMessage(scopeLogs, 2, record)
+	}
+
+	var resourceLogs []byte
+	resourceLogs = appendMessage(resourceLogs, 1, encodeResource(resource))
+	resourceLogs = appendMessage(resourceLogs, 2, scopeLogs)
+
+	return appendMessage(nil, 1, resourceLogs)
+}
+
+func encodeResource(attributes map[string]string) []byte {
+	return appendAttributes(nil, 1, attributes)
+}
+
+func encodeScope(version string) []byte {
+	var b []byte
+	b = appendString(b, 1, ScopeName)
+	if version != "" {
+		b = appendString(b, 2, version)
+	}
+	return b
+}
+
+func encodeNumberDataPoint(p metrics.Point, ts time.Time) []byte {
+	var b []byte
+	b = appendFixed64(b, 3, uint64(ts.UnixNano()))
+	b = appendFixed64(b, 4, math.Float64bits(p.Value))
+	b = appendAttributes(b, 7, p.Tags)
+	return b
+}
+
+// appendAttributes appends KeyValue messages sorted by key to keep the output stable
+func appendAttributes(b []byte, num protowire.Number, attributes map[string]string) []byte {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		var kv []byte
+		kv = appendString(kv, 1, k)
+		kv = appendMessage(kv, 2, encodeStringValue(attributes[k]))
+		b = appendMessage(b, num, kv)
+	}
+	return b
+}
+
+func encodeStringValue(s string) []byte {
+	return appendString(nil, 1, s)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+func appendFixed64(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
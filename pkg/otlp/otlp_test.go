@@ -0,0 +1,163 @@
+package otlp
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/securez-one/cagent/pkg/metrics"
+)
+
+// field is a decoded protobuf field, nested messages are decoded on demand with fields()
+type field struct {
+	num   protowire.Number
+	bytes []byte
+	value uint64
+}
+
+func fields(t *testing.T, b []byte) []field {
+	var result []field
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.True(t, n > 0, "invalid tag")
+		b = b[n:]
+
+		f := field{num: num}
+		switch typ {
+		case protowire.BytesType:
+			f.bytes, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			f.value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.value, n = protowire.ConsumeFixed64(b)
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		require.True(t, n > 0, "invalid value of the field %d", num)
+		b = b[n:]
+		result = append(result, f)
+	}
+	return result
+}
+
+func get(t *testing.T, b []byte, num protowire.Number) []field {
+	var result []field
+	for _, f := range fields(t, b) {
+		if f.num == num {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+func one(t *testing.T, b []byte, num protowire.Number) field {
+	f := get(t, b, num)
+	require.Len(t, f, 1, "field %d", num)
+	return f[0]
+}
+
+func attributes(t *testing.T, b []byte, num protowire.Number) map[string]string {
+	result := map[string]string{}
+	for _, kv := range get(t, b, num) {
+		key := string(one(t, kv.bytes, 1).bytes)
+		value := one(t, one(t, kv.bytes, 2).bytes, 1)
+		result[key] = string(value.bytes)
+	}
+	return result
+}
+
+// receiver is a stand-in for the OTLP/HTTP receiver of a collector that records the request bodies by path
+type receiver struct {
+	mu     sync.Mutex
+	bodies map[string][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/x-protobuf" || req.Header.Get("X-Api-Key") != "secret" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	b, _ := ioutil.ReadAll(req.Body)
+	r.mu.Lock()
+	r.bodies[req.URL.Path] = b
+	r.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestExporter(t *testing.T) {
+	rcv := &receiver{bodies: map[string][]byte{}}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	resource := map[string]string{"host.name": "host.example.com", "os.type": "linux"}
+	ts := time.Unix(1600000000, 0)
+	points := []metrics.Point{
+		{Measurement: "fs", Field: "free_B", Tags: map[string]string{"mountpoint": "/"}, Value: 1024},
+		{Measurement: "fs", Field: "free_B", Tags: map[string]string{"mountpoint": "/var"}, Value: 10},
+		{Measurement: "net", Field: "in_B_per_s", Tags: map[string]string{"interface": "eth0"}, Value: 1.5},
+	}
+
+	exporter := NewExporter(srv.URL+"/", map[string]string{"X-Api-Key": "secret"}, 5*time.Second)
+	err := exporter.ExportMetrics(context.Background(), EncodeMetricsRequest(resource, "1.0.0", points, ts))
+	require.NoError(t, err)
+	err = exporter.ExportLogs(context.Background(), EncodeLogsRequest(resource, "1.0.0", []LogRecord{
+		{Time: ts, Severity: SeverityError, Body: "mysql is down", Attributes: map[string]string{"module": "mysql"}},
+	}))
+	require.NoError(t, err)
+
+	t.Run("metrics", func(t *testing.T) {
+		resourceMetrics := one(t, rcv.bodies[MetricsPath], 1).bytes
+		assert.Equal(t, resource, attributes(t, one(t, resourceMetrics, 1).bytes, 1))
+
+		scopeMetrics := one(t, resourceMetrics, 2).bytes
+		scope := one(t, scopeMetrics, 1).bytes
+		assert.Equal(t, "cagent", string(one(t, scope, 1).bytes))
+		assert.Equal(t, "1.0.0", string(one(t, scope, 2).bytes))
+
+		ms := get(t, scopeMetrics, 2)
+		require.Len(t, ms, 2)
+
+		assert.Equal(t, "cagent.fs.free_B", string(one(t, ms[0].bytes, 1).bytes))
+		assert.Equal(t, "By", string(one(t, ms[0].bytes, 3).bytes))
+		dataPoints := get(t, one(t, ms[0].bytes, 5).bytes, 1)
+		require.Len(t, dataPoints, 2)
+		assert.Equal(t, uint64(ts.UnixNano()), one(t, dataPoints[0].bytes, 3).value)
+		assert.Equal(t, float64(1024), math.Float64frombits(one(t, dataPoints[0].bytes, 4).value))
+		assert.Equal(t, map[string]string{"mountpoint": "/"}, attributes(t, dataPoints[0].bytes, 7))
+		assert.Equal(t, float64(10), math.Float64frombits(one(t, dataPoints[1].bytes, 4).value))
+
+		assert.Equal(t, "cagent.net.in_B_per_s", string(one(t, ms[1].bytes, 1).bytes))
+		assert.Equal(t, "By/s", string(one(t, ms[1].bytes, 3).bytes))
+	})
+
+	t.Run("logs", func(t *testing.T) {
+		resourceLogs := one(t, rcv.bodies[LogsPath], 1).bytes
+		assert.Equal(t, resource, attributes(t, one(t, resourceLogs, 1).bytes, 1))
+
+		records := get(t, one(t, resourceLogs, 2).bytes, 2)
+		require.Len(t, records, 1)
+		record := records[0].bytes
+		assert.Equal(t, uint64(ts.UnixNano()), one(t, record, 1).value)
+		assert.Equal(t, uint64(SeverityError), one(t, record, 2).value)
+		assert.Equal(t, "ERROR", string(one(t, record, 3).bytes))
+		assert.Equal(t, "mysql is down", string(one(t, one(t, record, 5).bytes, 1).bytes))
+		assert.Equal(t, map[string]string{"module": "mysql"}, attributes(t, record, 6))
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		exporter := NewExporter(srv.URL, nil, 5*time.Second)
+		err := exporter.ExportMetrics(context.Background(), EncodeMetricsRequest(resource, "", points, ts))
+		assert.Error(t, err)
+	})
+}
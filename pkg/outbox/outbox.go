@@ -0,0 +1,251 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	outboxDirPermissions   = 0755
+	outboxEntryPermissions = 0600
+	jsonExtension          = "json"
+)
+
+// Limits bounds the size of the outbox. Zero values mean no limit
+type Limits struct {
+	MaxEntries int
+	MaxBytes   int64
+	MaxAge     time.Duration
+}
+
+// Entry describes a single payload stored in the outbox
+type Entry struct {
+	ID        string
+	Timestamp int64
+	Size      int64
+	path      string
+}
+
+// Outbox persists payloads that could not be delivered, so they can be replayed later in the same order
+type Outbox struct {
+	dirPath string
+	limits  Limits
+	logger  *logrus.Logger
+
+	mu  sync.Mutex
+	seq uint64
+}
+
+// New creates a new object to manage the outbox
+// dirPath must be absolute path
+func New(dirPath string, limits Limits, logger *logrus.Logger) *Outbox {
+	return &Outbox{
+		dirPath: dirPath,
+		limits:  limits,
+		logger:  logger,
+	}
+}
+
+// Put stores JSON-encoded payload under the given Unix timestamp and enforces the outbox limits afterwards
+func (o *Outbox) Put(timestamp int64, payload interface{}) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	err := o.ensureDirExists()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "while encoding outbox entry")
+	}
+
+	o.seq++
+	id := fmt.Sprintf("%020d_%020d_%06d", timestamp, time.Now().UnixNano(), o.seq%1000000)
+	filePath := o.getFilePath(id)
+
+	// write into a temporary file first, so a partially written entry is never replayed
+	tmpPath := filePath + ".tmp"
+	err = ioutil.WriteFile(tmpPath, b, outboxEntryPermissions)
+	if err != nil {
+		return errors.Wrapf(err, "can not write outbox entry %s", tmpPath)
+	}
+
+	err = os.Rename(tmpPath, filePath)
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return errors.Wrapf(err, "can not finalize outbox entry %s", filePath)
+	}
+
+	return o.enforceLimits()
+}
+
+// Entries returns all stored entries sorted from the oldest to the newest.
+// Entries exceeding the max age are removed before listing
+func (o *Outbox) Entries() ([]Entry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	err := o.enforceLimits()
+	if err != nil {
+		return nil, err
+	}
+
+	return o.list()
+}
+
+// Read decodes the payload of the entry into v
+func (o *Outbox) Read(e Entry, v interface{}) error {
+	b, err := ioutil.ReadFile(e.path)
+	if err != nil {
+		return errors.Wrapf(err, "while reading outbox entry %s", e.path)
+	}
+
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return errors.Wrapf(err, "while decoding outbox entry %s", e.path)
+	}
+	return nil
+}
+
+// Remove deletes the entry from the outbox. It is not an error if the entry is already gone
+func (o *Outbox) Remove(e Entry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return removeFile(e.path)
+}
+
+// Len returns the number of entries currently stored
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entries, err := o.list()
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
+
+func (o *Outbox) list() ([]Entry, error) {
+	pattern := filepath.Join(o.dirPath, "*."+jsonExtension)
+	fileNames, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(fileNames))
+	for _, f := range fileNames {
+		id := strings.TrimSuffix(filepath.Base(f), "."+jsonExtension)
+		parts := strings.SplitN(id, "_", 2)
+		ts, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			o.logger.Warnf("outbox: skipping unexpected file %s", f)
+			continue
+		}
+
+		info, err := os.Stat(f)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "while reading outbox entry %s", f)
+		}
+
+		entries = append(entries, Entry{
+			ID:        id,
+			Timestamp: ts,
+			Size:      info.Size(),
+			path:      f,
+		})
+	}
+
+	// ids are zero-padded, so the lexical order is the chronological one
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID < entries[j].ID
+	})
+
+	return entries, nil
+}
+
+func (o *Outbox) enforceLimits() error {
+	entries, err := o.list()
+	if err != nil {
+		return err
+	}
+
+	var totalSize int64
+	for _, e := range entries {
+		totalSize += e.Size
+	}
+
+	now := time.Now()
+	var dropped int
+	for len(entries) > 0 {
+		oldest := entries[0]
+		tooOld := o.limits.MaxAge > 0 && now.Sub(time.Unix(oldest.Timestamp, 0)) > o.limits.MaxAge
+		tooMany := o.limits.MaxEntries > 0 && len(entries) > o.limits.MaxEntries
+		tooBig := o.limits.MaxBytes > 0 && totalSize > o.limits.MaxBytes
+		if !tooOld && !tooMany && !tooBig {
+			break
+		}
+
+		err = removeFile(oldest.path)
+		if err != nil {
+			return err
+		}
+
+		totalSize -= oldest.Size
+		entries = entries[1:]
+		dropped++
+	}
+
+	if dropped > 0 {
+		o.logger.Warnf("outbox: dropped %d oldest entries to stay within the configured limits", dropped)
+	}
+
+	return nil
+}
+
+func (o *Outbox) ensureDirExists() error {
+	_, err := os.Stat(o.dirPath)
+	if os.IsNotExist(err) {
+		err = os.MkdirAll(o.dirPath, outboxDirPermissions)
+		if err != nil {
+			return errors.Wrapf(
+				err,
+				"could not create outbox dir %s. Please check you have enough rights or try create the dir manually",
+				o.dirPath,
+			)
+		}
+	} else if err != nil {
+		err = errors.Wrapf(err, "while checking outbox dir %s exists", o.dirPath)
+	}
+	return err
+}
+
+func (o *Outbox) getFilePath(id string) string {
+	return filepath.Join(o.dirPath, id+"."+jsonExtension)
+}
+
+// removeFile ignores error if file already deleted or not exists
+func removeFile(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "while removing %s", path)
+	}
+	return nil
+}
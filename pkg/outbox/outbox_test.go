@@ -0,0 +1,103 @@
+package outbox
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type samplePayload struct {
+	Timestamp int64 `json:"timestamp"`
+	Value     int   `json:"value"`
+}
+
+func helperCreateOutbox(t *testing.T, limits Limits) (*Outbox, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "outbox")
+	assert.NoError(t, err)
+
+	return New(dir, limits, logrus.StandardLogger()), func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	t.Run("replay-in-order", func(t *testing.T) {
+		o, cleanup := helperCreateOutbox(t, Limits{})
+		defer cleanup()
+
+		now := time.Now().Unix()
+		for i := 3; i > 0; i-- {
+			assert.NoError(t, o.Put(now-int64(i), samplePayload{now - int64(i), i}))
+		}
+
+		entries, err := o.Entries()
+		assert.NoError(t, err)
+		assert.Len(t, entries, 3)
+
+		for i, e := range entries {
+			var p samplePayload
+			assert.NoError(t, o.Read(e, &p))
+			assert.Equal(t, 3-i, p.Value)
+			assert.Equal(t, e.Timestamp, p.Timestamp)
+			assert.NoError(t, o.Remove(e))
+		}
+		assert.Equal(t, 0, o.Len())
+	})
+
+	t.Run("max-entries", func(t *testing.T) {
+		o, cleanup := helperCreateOutbox(t, Limits{MaxEntries: 2})
+		defer cleanup()
+
+		now := time.Now().Unix()
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, o.Put(now+int64(i), samplePayload{now + int64(i), i}))
+		}
+
+		entries, err := o.Entries()
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2)
+
+		var p samplePayload
+		assert.NoError(t, o.Read(entries[0], &p))
+		assert.Equal(t, 3, p.Value)
+	})
+
+	t.Run("max-bytes", func(t *testing.T) {
+		o, cleanup := helperCreateOutbox(t, Limits{MaxBytes: 100})
+		defer cleanup()
+
+		now := time.Now().Unix()
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, o.Put(now+int64(i), samplePayload{now + int64(i), i}))
+		}
+
+		entries, err := o.Entries()
+		assert.NoError(t, err)
+
+		var total int64
+		for _, e := range entries {
+			total += e.Size
+		}
+		assert.True(t, total <= 100)
+		assert.True(t, len(entries) > 0)
+	})
+
+	t.Run("max-age", func(t *testing.T) {
+		o, cleanup := helperCreateOutbox(t, Limits{MaxAge: time.Hour})
+		defer cleanup()
+
+		now := time.Now()
+		assert.NoError(t, o.Put(now.Add(-2*time.Hour).Unix(), samplePayload{Value: 1}))
+		assert.NoError(t, o.Put(now.Unix(), samplePayload{Value: 2}))
+
+		entries, err := o.Entries()
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+}
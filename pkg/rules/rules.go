@@ -0,0 +1,255 @@
+// Package rules evaluates threshold rules over the collected measurements and reports the matching ones as alerts or warnings
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/Knetic/govaluate.v3"
+
+	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/events"
+	"github.com/securez-one/cagent/pkg/monitoring"
+)
+
+// ReportName is the name of the module report holding the results of the rules
+const ReportName = "rules"
+
+const (
+	SeverityAlert   = "alert"
+	SeverityWarning = "warning"
+)
+
+// keyPattern matches measurement keys containing dots outside of quoted strings and brackets.
+// The alternatives for the strings and the bracketed names only skip them
+var keyPattern = regexp.MustCompile(`'[^']*'|"[^"]*"|\[[^\]]*\]|[A-Za-z_][A-Za-z0-9_]*(?:\.[^\s()\[\]<>=!&|,'"]+)+`)
+
+// Rule is a [[rules]] entry of the config
+type Rule struct {
+	Name       string `toml:"name" comment:"Unique name of the rule, used in the messages"`
+	Expression string `toml:"expression" comment:"Condition over the measurement keys, e.g. fs.free_percent./var < 10 && mem.free_percent < 5"`
+	ForRuns    int    `toml:"for_runs" comment:"Number of consecutive collections the expression must be true before the rule fires, default 1"`
+	Severity   string `toml:"severity" comment:"alert or warning, default alert"`
+	Message    string `toml:"message" comment:"Text of the alert or warning, default is the expression and the current values"`
+}
+
+// Validate checks the rule and sets the defaults
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is missing")
+	}
+
+	if _, err := compile(r.Expression); err != nil {
+		return fmt.Errorf("rule %s: invalid expression: %s", r.Name, err.Error())
+	}
+
+	if r.ForRuns < 0 {
+		return fmt.Errorf("rule %s: for_runs must be positive", r.Name)
+	} else if r.ForRuns == 0 {
+		r.ForRuns = 1
+	}
+
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityAlert
+	case SeverityAlert, SeverityWarning:
+	default:
+		return fmt.Errorf("rule %s: severity must be '%s' or '%s'", r.Name, SeverityAlert, SeverityWarning)
+	}
+
+	return nil
+}
+
+// ValidateAll validates each rule and checks the names are unique
+func ValidateAll(rules []Rule) error {
+	names := make(map[string]bool, len(rules))
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return err
+		}
+		if names[rules[i].Name] {
+			return fmt.Errorf("rule %s: name is not unique", rules[i].Name)
+		}
+		names[rules[i].Name] = true
+	}
+
+	return nil
+}
+
+// compile parses the expression. Measurement keys with dots are put in brackets as govaluate would read them as numbers otherwise
+func compile(expression string) (*govaluate.EvaluableExpression, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, fmt.Errorf("expression is missing")
+	}
+
+	escaped := keyPattern.ReplaceAllStringFunc(expression, func(s string) string {
+		if strings.ContainsAny(s[:1], `'"[`) {
+			return s
+		}
+		return "[" + s + "]"
+	})
+
+	return govaluate.NewEvaluableExpression(escaped)
+}
+
+type ruleState struct {
+	Rule
+	expression *govaluate.EvaluableExpression
+	// matches counts the consecutive collections the expression was true
+	matches int
+	firing  bool
+	// message is the alert or warning of the last evaluation while firing
+	message string
+}
+
+// Firing describes a rule that fires
+type Firing struct {
+	Name     string
+	Severity string
+	Message  string
+}
+
+// Engine keeps the state of the rules between the collections. It is not safe for concurrent use
+type Engine struct {
+	rules []*ruleState
+}
+
+// NewEngine compiles the validated rules
+func NewEngine(rules []Rule) (*Engine, error) {
+	e := &Engine{}
+	for _, r := range rules {
+		expression, err := compile(r.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %s", r.Name, err.Error())
+		}
+		e.rules = append(e.rules, &ruleState{Rule: r, expression: expression})
+	}
+
+	return e, nil
+}
+
+// Evaluate evaluates the rules over the measurements.
+// The report lists the firing rules as alerts or warnings and the rules that stopped firing in this collection in the message.
+// Rules referring to missing measurements keep their state
+func (e *Engine) Evaluate(measurements common.MeasurementsMap, now time.Time) (*monitoring.ModuleReport, error) {
+	report := monitoring.NewReport(ReportName, now, "")
+	var errs common.ErrorCollector
+	var recovered []string
+
+	for _, r := range e.rules {
+		params, missing := parameters(r.expression.Vars(), measurements)
+		if missing {
+			continue
+		}
+
+		result, err := r.expression.Evaluate(params)
+		if err != nil {
+			errs.Add(fmt.Errorf("rule %s: %s", r.Name, err.Error()))
+			continue
+		}
+		matched, isBool := result.(bool)
+		if !isBool {
+			errs.Add(fmt.Errorf("rule %s: the expression returns %v instead of true or false", r.Name, result))
+			continue
+		}
+
+		if !matched {
+			r.matches = 0
+			if r.firing {
+				r.firing = false
+				msg := fmt.Sprintf("%s recovered: %s", r.Name, describe(r, params))
+				recovered = append(recovered, msg)
+				events.Emit(events.Event{Source: ReportName, Key: r.Name, Severity: events.SeverityRecovered, Message: msg})
+			}
+			continue
+		}
+
+		r.matches++
+		if r.matches < r.ForRuns && !r.firing {
+			continue
+		}
+
+		msg := r.Message
+		if msg == "" {
+			msg = describe(r, params)
+		}
+		msg = r.Name + ": " + msg
+
+		if !r.firing {
+			r.firing = true
+			events.Emit(events.Event{Source: ReportName, Key: r.Name, Severity: r.Severity, Message: msg})
+		}
+		r.message = msg
+		if r.Severity == SeverityWarning {
+			report.AddWarning(msg)
+		} else {
+			report.AddAlert(msg)
+		}
+	}
+
+	report.Message = strings.Join(recovered, "; ")
+
+	return &report, errs.Combine()
+}
+
+// Firing returns the rules that fired in the last evaluation
+func (e *Engine) Firing() []Firing {
+	var firing []Firing
+	for _, r := range e.rules {
+		if r.firing {
+			firing = append(firing, Firing{Name: r.Name, Severity: r.Severity, Message: r.message})
+		}
+	}
+
+	return firing
+}
+
+// parameters returns the values of the keys used by the expression, numbers are converted to float64 to compare them
+func parameters(keys []string, measurements common.MeasurementsMap) (map[string]interface{}, bool) {
+	params := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		value, exists := measurements[key]
+		if !exists || value == nil {
+			return nil, true
+		}
+
+		switch v := value.(type) {
+		case int:
+			value = float64(v)
+		case int32:
+			value = float64(v)
+		case int64:
+			value = float64(v)
+		case uint:
+			value = float64(v)
+		case uint32:
+			value = float64(v)
+		case uint64:
+			value = float64(v)
+		case float32:
+			value = float64(v)
+		}
+		params[key] = value
+	}
+
+	return params, false
+}
+
+// describe returns the expression along with the current values, e.g. "fs.free_percent./var < 10 (fs.free_percent./var = 5.2)"
+func describe(r *ruleState, params map[string]interface{}) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, fmt.Sprintf("%s = %v", key, params[key]))
+	}
+
+	return fmt.Sprintf("%s (%s)", r.Expression, strings.Join(values, ", "))
+}
@@ -0,0 +1,66 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/monitoring"
+)
+
+func TestValidateAll(t *testing.T) {
+	rules := []Rule{{Name: "var", Expression: "fs.free_percent./var < 10"}}
+	assert.NoError(t, ValidateAll(rules))
+	assert.Equal(t, 1, rules[0].ForRuns)
+	assert.Equal(t, SeverityAlert, rules[0].Severity)
+
+	assert.Error(t, ValidateAll([]Rule{{Expression: "mem.free_percent < 10"}}))
+	assert.Error(t, ValidateAll([]Rule{{Name: "broken", Expression: "mem.free_percent <"}}))
+	assert.Error(t, ValidateAll([]Rule{{Name: "severity", Expression: "mem.free_percent < 10", Severity: "critical"}}))
+	assert.Error(t, ValidateAll([]Rule{
+		{Name: "twice", Expression: "mem.free_percent < 10"},
+		{Name: "twice", Expression: "swap.free_percent < 10"},
+	}))
+}
+
+func TestEngineEvaluate(t *testing.T) {
+	rules := []Rule{
+		{Name: "var", Expression: "fs.free_percent./var < 10 && [net.in_B_per_s.Local Area Connection] > 0", ForRuns: 2},
+		{Name: "mem", Expression: "mem.free_percent < 5", Severity: SeverityWarning, Message: "low memory"},
+		{Name: "missing", Expression: "docker.count > 0"},
+	}
+	assert.NoError(t, ValidateAll(rules))
+
+	e, err := NewEngine(rules)
+	assert.NoError(t, err)
+
+	m := common.MeasurementsMap{
+		"fs.free_percent./var":                 5.5,
+		"net.in_B_per_s.Local Area Connection": 100,
+		"mem.free_percent":                     3,
+	}
+
+	r, err := e.Evaluate(m, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, ReportName, r.Name)
+	assert.Empty(t, r.Alerts)
+	assert.Equal(t, []monitoring.Warning{"mem: low memory"}, r.Warnings)
+
+	r, err = e.Evaluate(m, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, []monitoring.Alert{
+		"var: fs.free_percent./var < 10 && [net.in_B_per_s.Local Area Connection] > 0 (fs.free_percent./var = 5.5, net.in_B_per_s.Local Area Connection = 100)",
+	}, r.Alerts)
+
+	m["fs.free_percent./var"] = 50.0
+	r, err = e.Evaluate(m, time.Now())
+	assert.NoError(t, err)
+	assert.Empty(t, r.Alerts)
+	assert.Contains(t, r.Message, "var recovered")
+
+	r, err = e.Evaluate(common.MeasurementsMap{"mem.free_percent": "unknown"}, time.Now())
+	assert.Error(t, err)
+	assert.Empty(t, r.Message)
+}
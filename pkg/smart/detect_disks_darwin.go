@@ -3,6 +3,7 @@
 package smart
 
 import (
+	"context"
 	"bufio"
 	"bytes"
 	"os/exec"
@@ -10,8 +11,8 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func (sm *SMART) detectDisks() (*bytes.Buffer, error) {
-	cmd := exec.Command("/bin/sh", "-c", `diskutil list | grep "^/dev/" | grep -v synthesized | grep -v external | grep -v "disk image"`)
+func (sm *SMART) detectDisks(ctx context.Context) (*bytes.Buffer, error) {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", `diskutil list | grep "^/dev/" | grep -v synthesized | grep -v external | grep -v "disk image"`)
 
 	buf := &bytes.Buffer{}
 	cmd.Stdout = bufio.NewWriter(buf)
@@ -3,6 +3,7 @@
 package smart
 
 import (
+	"context"
 	"bufio"
 	"bytes"
 	"fmt"
@@ -11,8 +12,8 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func (sm *SMART) detectDisks() (*bytes.Buffer, error) {
-	cmd := exec.Command("/bin/sh", "-c", fmt.Sprintf("sudo %s --scan", sm.smartctl))
+func (sm *SMART) detectDisks(ctx context.Context) (*bytes.Buffer, error) {
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", fmt.Sprintf("sudo %s --scan", sm.smartctl))
 
 	buf := &bytes.Buffer{}
 	cmd.Stdout = bufio.NewWriter(buf)
@@ -3,6 +3,7 @@
 package smart
 
 import (
+	"context"
 	"bufio"
 	"bytes"
 	"os/exec"
@@ -10,8 +11,8 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-func (sm *SMART) detectDisks() (*bytes.Buffer, error) {
-	cmd := exec.Command("cmd", "/c", sm.smartctl, "--scan")
+func (sm *SMART) detectDisks(ctx context.Context) (*bytes.Buffer, error) {
+	cmd := exec.CommandContext(ctx, "cmd", "/c", sm.smartctl, "--scan")
 
 	buf := &bytes.Buffer{}
 	cmd.Stdout = bufio.NewWriter(buf)
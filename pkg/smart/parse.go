@@ -1,6 +1,7 @@
 package smart
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os/exec"
@@ -153,9 +154,9 @@ type parseResult struct {
 
 var smartctlVersionRegexp = regexp.MustCompile(`^smartctl\s(\d.\d)\s(\w|\W)+$`)
 
-// Parse detect hardware disks and parse their S.M.A.R.T
-func (sm *SMART) Parse() (common.MeasurementsMap, []error) {
-	rawDisksOutput, err := sm.detectDisks()
+// Parse detect hardware disks and parse their S.M.A.R.T. The smartctl processes are killed when ctx is done
+func (sm *SMART) Parse(ctx context.Context) (common.MeasurementsMap, []error) {
+	rawDisksOutput, err := sm.detectDisks(ctx)
 	if err != nil {
 		return nil, []error{err}
 	}
@@ -167,7 +168,7 @@ func (sm *SMART) Parse() (common.MeasurementsMap, []error) {
 
 	var errs []error
 	var jsonOutput []string
-	if jsonOutput, err = sm.smartCtlRun(disks); err != nil {
+	if jsonOutput, err = sm.smartCtlRun(ctx, disks); err != nil {
 		errs = append(errs, err)
 	}
 
@@ -176,12 +177,15 @@ func (sm *SMART) Parse() (common.MeasurementsMap, []error) {
 	return result, append(errs, parseErrors...)
 }
 
-func (sm *SMART) smartCtlRun(disks []string) ([]string, error) {
+func (sm *SMART) smartCtlRun(ctx context.Context, disks []string) ([]string, error) {
 	var result []string
 	var errStr string
 
 	for _, disk := range disks {
-		cmd := sm.smartctlPrepare(disk)
+		if ctx.Err() != nil {
+			return result, errors.Wrap(ctx.Err(), "smartctl")
+		}
+		cmd := sm.smartctlPrepare(ctx, disk)
 
 		var err error
 		var output []byte
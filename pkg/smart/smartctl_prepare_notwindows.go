@@ -3,12 +3,13 @@
 package smart
 
 import (
+	"context"
 	"fmt"
 	"os/exec"
 	"runtime"
 )
 
-func (sm *SMART) smartctlPrepare(disk string) *exec.Cmd {
+func (sm *SMART) smartctlPrepare(ctx context.Context, disk string) *exec.Cmd {
 	var smartctlPrefix string
 
 	// on linux smartctl should be invoked with sudo rights
@@ -16,5 +17,5 @@ func (sm *SMART) smartctlPrepare(disk string) *exec.Cmd {
 		smartctlPrefix = "sudo "
 	}
 
-	return exec.Command("/bin/sh", "-c", fmt.Sprintf("%s%s -j -a %s", smartctlPrefix, sm.smartctl, disk))
+	return exec.CommandContext(ctx, "/bin/sh", "-c", fmt.Sprintf("%s%s -j -a %s", smartctlPrefix, sm.smartctl, disk))
 }
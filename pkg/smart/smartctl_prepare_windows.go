@@ -3,9 +3,10 @@
 package smart
 
 import (
+	"context"
 	"os/exec"
 )
 
-func (sm *SMART) smartctlPrepare(disk string) *exec.Cmd {
-	return exec.Command("cmd", "/c", sm.smartctl, "-j", "-a", disk)
+func (sm *SMART) smartctlPrepare(ctx context.Context, disk string) *exec.Cmd {
+	return exec.CommandContext(ctx, "cmd", "/c", sm.smartctl, "-j", "-a", disk)
 }
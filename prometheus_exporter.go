@@ -0,0 +1,70 @@
+package cagent
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/securez-one/cagent/pkg/metrics"
+)
+
+const prometheusExporterShutdownTimeout = 5 * time.Second
+
+// startPrometheusExporter serves the measurements of the last collection in the Prometheus text format.
+// It doesn't collect anything itself, so the values are as fresh as the last run of collectMeasurements
+func (ca *Cagent) startPrometheusExporter() (stop func()) {
+	cfg := ca.Config.PrometheusExporter
+	if cfg.Listen == "" {
+		return func() {}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(cfg.Path, ca.servePrometheusMetrics)
+
+	listener, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		log.WithError(err).Errorf("prometheus exporter: could not listen on %s", cfg.Listen)
+		return func() {}
+	}
+
+	srv := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	go func() {
+		log.Infof("prometheus exporter: serving metrics on http://%s%s", listener.Addr(), cfg.Path)
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Error("prometheus exporter: server stopped")
+		}
+	}()
+
+	return func() {
+		ctx, cancelFn := context.WithTimeout(context.Background(), prometheusExporterShutdownTimeout)
+		defer cancelFn()
+		_ = srv.Shutdown(ctx)
+	}
+}
+
+func (ca *Cagent) servePrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	result := ca.lastResult()
+	if result == nil {
+		http.Error(w, "no measurements collected yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	err := metrics.WritePrometheus(w, metrics.Flatten(result.Measurements))
+	if err != nil {
+		log.WithError(err).Debug("prometheus exporter: failed to write response")
+	}
+}
@@ -0,0 +1,226 @@
+package cagent
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ReloadConfig reads and validates the config file again and swaps it with the active config.
+// Watchers whose settings didn't change keep their state, e.g. CPU averaging windows and IO counters.
+// If the file is invalid, the error is returned and the active config is kept
+func (ca *Cagent) ReloadConfig() error {
+	if _, err := os.Stat(ca.ConfigLocation); err != nil {
+		// HandleAllConfigSetup would create a new default config instead of the missing one
+		return errors.Wrap(err, "config file is not accessible")
+	}
+
+	newCfg, err := HandleAllConfigSetup(ca.ConfigLocation)
+	if err != nil {
+		return err
+	}
+
+	ca.swapConfig(newCfg)
+
+	log.Infof("config reloaded from %s", ca.ConfigLocation)
+	return nil
+}
+
+// swapConfig replaces the active config once the current collection is finished.
+// Deliveries in progress keep the config and the parts they were started with
+func (ca *Cagent) swapConfig(newCfg *Config) {
+	ca.reloadLock.Lock()
+	defer ca.reloadLock.Unlock()
+
+	oldCfg := ca.Config
+	ca.Config = newCfg
+	ca.applyConfigChanges(oldCfg, newCfg)
+	ca.status.setActiveConfig(newCfg, ca.outbox)
+}
+
+// applyConfigChanges rebuilds only the parts of the agent affected by the changed settings
+func (ca *Cagent) applyConfigChanges(oldCfg, newCfg *Config) {
+	if oldCfg.LogLevel != newCfg.LogLevel {
+		ca.SetLogLevel(newCfg.LogLevel)
+	}
+
+	if !reflect.DeepEqual(cpuSettings(oldCfg), cpuSettings(newCfg)) {
+		log.Info("config reload: CPU settings changed, restarting the CPU watcher")
+		if ca.cpuWatcher != nil {
+			ca.cpuWatcher.Shutdown()
+			ca.cpuWatcher = nil
+		}
+		if ca.cpuUtilisationAnalyser != nil {
+			ca.cpuUtilisationAnalyser.Shutdown()
+			ca.cpuUtilisationAnalyser = nil
+		}
+	}
+
+	if !reflect.DeepEqual(fsSettings(oldCfg), fsSettings(newCfg)) {
+		log.Info("config reload: file system settings changed, restarting the file system watcher")
+		ca.fsWatcher = nil
+	}
+
+	if !reflect.DeepEqual(netSettings(oldCfg), netSettings(newCfg)) {
+		log.Info("config reload: network settings changed, restarting the network watcher")
+		ca.netWatcher = nil
+	}
+
+	if oldCfg.SMARTMonitoring != newCfg.SMARTMonitoring || oldCfg.SMARTCtl != newCfg.SMARTCtl {
+		ca.initSMART()
+	}
+
+	if !reflect.DeepEqual(moduleSettings(oldCfg), moduleSettings(newCfg)) {
+		modules = nil
+	}
+
+	hubClientChanged := !reflect.DeepEqual(hubClientSettings(oldCfg), hubClientSettings(newCfg))
+	if hubClientChanged {
+		ca.hubClient = nil
+		ca.hubClientOnce = sync.Once{}
+	}
+
+	hubChanged := hubClientChanged || !reflect.DeepEqual(hubDestinationSettings(oldCfg), hubDestinationSettings(newCfg))
+	if hubChanged {
+		ca.initHubDestinations()
+	}
+
+	if !reflect.DeepEqual(oldCfg.Sinks, newCfg.Sinks) {
+		ca.closeSinks()
+		ca.initSinks()
+	}
+
+	if !reflect.DeepEqual(oldCfg.Outbox, newCfg.Outbox) {
+		ca.initOutbox()
+	}
+
+	if !reflect.DeepEqual(oldCfg.DeltaEncoding, newCfg.DeltaEncoding) {
+		ca.initDeltaEncoder()
+	}
+
+	if !reflect.DeepEqual(oldCfg.Events, newCfg.Events) || hubChanged {
+		ca.initEvents()
+	}
+
+	if !reflect.DeepEqual(oldCfg.AlertState, newCfg.AlertState) {
+		ca.initAlertState()
+	}
+
+	if !reflect.DeepEqual(oldCfg.WebhookNotifier, newCfg.WebhookNotifier) {
+		ca.initWebhookNotifier()
+	}
+
+	if !reflect.DeepEqual(oldCfg.Rules, newCfg.Rules) {
+		ca.initRulesEngine()
+	}
+
+	if !reflect.DeepEqual(oldCfg.CollectorIntervals, newCfg.CollectorIntervals) || oldCfg.CollectorCacheMode != newCfg.CollectorCacheMode {
+		ca.resetCollectorCache()
+	}
+
+	for name, changed := range map[string]bool{
+		"operation_mode":          oldCfg.OperationMode != newCfg.OperationMode,
+		"pid":                     oldCfg.PidFile != newCfg.PidFile,
+		"log":                     oldCfg.LogFile != newCfg.LogFile,
+		"log_syslog":              oldCfg.LogSyslog != newCfg.LogSyslog,
+		"[prometheus_exporter]":   !reflect.DeepEqual(oldCfg.PrometheusExporter, newCfg.PrometheusExporter),
+		"[status_api]":            !reflect.DeepEqual(oldCfg.StatusAPI, newCfg.StatusAPI),
+		"[system_updates_checks]": !reflect.DeepEqual(oldCfg.SystemUpdatesChecks, newCfg.SystemUpdatesChecks),
+		"[self_update]":           !reflect.DeepEqual(oldCfg.Updates, newCfg.Updates),
+		"[host_identity]":         !reflect.DeepEqual(oldCfg.HostIdentity, newCfg.HostIdentity),
+	} {
+		if changed {
+			log.Warnf("config reload: changes of %s take effect after restart", name)
+		}
+	}
+}
+
+func cpuSettings(cfg *Config) []interface{} {
+	return []interface{}{cfg.CPUMonitoring, cfg.CPULoadDataGather, cfg.CPUUtilDataGather, cfg.CPUUtilTypes, cfg.CPUUtilisationAnalysis}
+}
+
+func fsSettings(cfg *Config) []interface{} {
+	return []interface{}{cfg.FSTypeInclude, cfg.FSPathExclude, cfg.FSPathExcludeRecurse, cfg.FSMetrics, cfg.FSIdentifyMountpointsByDevice}
+}
+
+func netSettings(cfg *Config) []interface{} {
+	return []interface{}{cfg.NetInterfaceExclude, cfg.NetInterfaceExcludeRegex, cfg.NetInterfaceExcludeDisconnected, cfg.NetInterfaceExcludeLoopback, cfg.NetMetrics, cfg.NetInterfaceMaxSpeed}
+}
+
+func moduleSettings(cfg *Config) []interface{} {
+	return []interface{}{cfg.StorCLI, cfg.SoftwareRAIDMonitoring, cfg.MysqlMonitoring}
+}
+
+func hubDestinationSettings(cfg *Config) []interface{} {
+	return []interface{}{cfg.HubURL, cfg.HubURLs, cfg.HubUser, cfg.HubPassword, cfg.HubDestinations, cfg.HubGzip,
+		cfg.OnHTTP5xxRetryInterval, cfg.Interval, cfg.Outbox}
+}
+
+func hubClientSettings(cfg *Config) []interface{} {
+	return []interface{}{cfg.HubProxy, cfg.HubProxyUser, cfg.HubProxyPassword, cfg.HubRequestTimeout, cfg.HubTLS()}
+}
+
+// WatchConfig reloads the config on SIGHUP and when the config file is modified.
+// The file is checked every config_watch_interval seconds, 0 disables the check
+func (ca *Cagent) WatchConfig(interrupt chan struct{}) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGHUP)
+	defer signal.Stop(sigc)
+
+	lastModTime := configModTime(ca.ConfigLocation)
+
+	var checkChan <-chan time.Time
+	if ca.Config.ConfigWatchInterval > 0 {
+		ticker := time.NewTicker(secToDuration(ca.Config.ConfigWatchInterval))
+		defer ticker.Stop()
+		checkChan = ticker.C
+	}
+
+	for {
+		select {
+		case <-interrupt:
+			return
+		case <-sigc:
+			log.Info("SIGHUP received, reloading the config")
+		case <-checkChan:
+			modTime := configModTime(ca.ConfigLocation)
+			if modTime.IsZero() || modTime.Equal(lastModTime) {
+				continue
+			}
+			log.Infof("%s or its drop-ins were modified, reloading the config", ca.ConfigLocation)
+		}
+
+		lastModTime = configModTime(ca.ConfigLocation)
+		if err := ca.ReloadConfig(); err != nil {
+			log.WithError(err).Error("config reload failed, keeping the previous config")
+		}
+	}
+}
+
+// configModTime returns the latest modification time of the config file, the conf.d directory and the drop-ins in it.
+// The modification time of the directory changes when a drop-in is added or removed
+func configModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	latest := info.ModTime()
+
+	paths := []string{filepath.Join(filepath.Dir(path), configDropInDir)}
+	dropIns, _ := ConfigDropInFiles(path)
+	paths = append(paths, dropIns...)
+	for _, p := range paths {
+		if info, err := os.Stat(p); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest
+}
@@ -0,0 +1,220 @@
+package cagent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/troian/toml"
+)
+
+// remoteConfigAllowedKeys lists the config keys the Hub may set. Nested keys of the listed sections are allowed too.
+// Credentials, paths, binaries executed by cagent and the settings of the Hub connection must never be listed here
+var remoteConfigAllowedKeys = []string{
+	"interval",
+	"heartbeat",
+	"collector_timeout",
+	"collector_timeouts",
+	"collector_intervals",
+	"collector_cache_mode",
+	"cpu_load_data_gathering_mode",
+	"cpu_utilisation_gathering_mode",
+	"cpu_utilisation_types",
+	"cpu_utilisation_analysis",
+	"fs_type_include",
+	"fs_path_exclude",
+	"fs_path_exclude_recurse",
+	"fs_metrics",
+	"fs_identify_mountpoints_by_device",
+	"net_interface_exclude",
+	"net_interface_exclude_regex",
+	"net_interface_exclude_disconnected",
+	"net_interface_exclude_loopback",
+	"net_metrics",
+	"net_interface_max_speed",
+	"system_fields",
+	"virtual_machines_stat",
+	"hardware_inventory",
+	"discover_autostarting_services_only",
+	"temperature_monitoring",
+	"software_raid_monitoring",
+	"smart_monitoring",
+	"mem_monitoring",
+	"cpu_monitoring",
+	"fs_monitoring",
+	"net_monitoring",
+	"process_monitoring",
+	"self_telemetry",
+	"docker_monitoring.enabled",
+	"mysql_monitoring.enabled",
+	"system_updates_checks.enabled",
+}
+
+// RemoteConfigOverlay is a part of the config in TOML format sent by the Hub, e.g.
+//
+//	{"remote_config": {"version": "42", "toml": "fs_path_exclude = ['/mnt/*']\n[docker_monitoring]\nenabled = false"}}
+//
+// A new version replaces the previous overlay completely, an empty overlay removes the remote settings
+type RemoteConfigOverlay struct {
+	Version string `json:"version"`
+	TOML    string `json:"toml"`
+}
+
+// remoteConfigError is returned by loadConfig if the remote config overlay or the config it results in is invalid
+type remoteConfigError struct {
+	err error
+}
+
+func (e remoteConfigError) Error() string {
+	return fmt.Sprintf("remote config: %s", e.err.Error())
+}
+
+// checkKeys returns an error if the overlay sets any key that is not allowed to be set remotely
+func (o *RemoteConfigOverlay) checkKeys() error {
+	meta, err := toml.Decode(o.TOML, &map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+
+	var denied []string
+	for _, key := range meta.Keys() {
+		if !isRemoteConfigKeyAllowed(key.String(), meta.Type(key...) == "Hash") {
+			denied = append(denied, key.String())
+		}
+	}
+
+	if len(denied) > 0 {
+		return fmt.Errorf("not allowed to be set remotely: %s", strings.Join(denied, ", "))
+	}
+
+	return nil
+}
+
+func isRemoteConfigKeyAllowed(key string, isTable bool) bool {
+	for _, allowed := range remoteConfigAllowedKeys {
+		if key == allowed || strings.HasPrefix(key, allowed+".") {
+			return true
+		}
+
+		// the table holding an allowed key, e.g. [docker_monitoring] for docker_monitoring.enabled
+		if isTable && strings.HasPrefix(allowed, key+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyRemoteConfig applies the overlay or the cached one if overlay is nil
+func (cfg *Config) applyRemoteConfig(overlay *RemoteConfigOverlay) error {
+	if overlay == nil {
+		var err error
+		overlay, err = readRemoteConfigCache(cfg.RemoteConfig.CacheFile)
+		if err != nil || overlay == nil {
+			return err
+		}
+	}
+
+	if err := overlay.checkKeys(); err != nil {
+		return err
+	}
+
+	meta, err := toml.Decode(overlay.TOML, cfg)
+	if err != nil {
+		return err
+	}
+
+	cfg.setSources(fmt.Sprintf("remote config version %s", overlay.Version), meta)
+	cfg.remoteConfigVersion = overlay.Version
+
+	return nil
+}
+
+// RemoteConfigVersion returns the version of the applied remote config overlay, empty if there is none
+func (cfg *Config) RemoteConfigVersion() string {
+	return cfg.remoteConfigVersion
+}
+
+func readRemoteConfigCache(path string) (*RemoteConfigOverlay, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var overlay RemoteConfigOverlay
+	if err = json.Unmarshal(data, &overlay); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err.Error())
+	}
+
+	return &overlay, nil
+}
+
+func writeRemoteConfigCache(path string, overlay *RemoteConfigOverlay) error {
+	data, err := json.Marshal(overlay)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// write a temp file first to not leave a broken cache behind
+	tmpPath := path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// handleRemoteConfig applies a new overlay in the background as the config can't be swapped during the collection or the heartbeat
+func (ca *Cagent) handleRemoteConfig(overlay *RemoteConfigOverlay) {
+	if !ca.Config.RemoteConfig.Enabled {
+		return
+	}
+
+	rejectedVersion, _ := ca.status.remoteConfigRejected()
+	if overlay.Version == ca.Config.remoteConfigVersion || overlay.Version == rejectedVersion {
+		return
+	}
+
+	go ca.applyRemoteConfigOverlay(overlay)
+}
+
+func (ca *Cagent) applyRemoteConfigOverlay(overlay *RemoteConfigOverlay) {
+	// the Hub sends the overlay with every response until it is applied
+	ca.remoteConfigLock.Lock()
+	defer ca.remoteConfigLock.Unlock()
+
+	ca.reloadLock.RLock()
+	currentVersion := ca.Config.remoteConfigVersion
+	cacheFile := ca.Config.RemoteConfig.CacheFile
+	ca.reloadLock.RUnlock()
+
+	if overlay.Version == currentVersion {
+		return
+	}
+
+	newCfg, err := loadConfig(ca.ConfigLocation, overlay)
+	if err == nil {
+		err = writeRemoteConfigCache(cacheFile, overlay)
+	}
+
+	if err != nil {
+		log.WithError(err).Errorf("remote config version %s rejected", overlay.Version)
+		ca.status.setRemoteConfigRejected(overlay.Version, err)
+		return
+	}
+
+	ca.status.setRemoteConfigRejected("", nil)
+	ca.swapConfig(newCfg)
+
+	log.Infof("remote config version %s applied", overlay.Version)
+}
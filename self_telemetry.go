@@ -0,0 +1,82 @@
+package cagent
+
+import (
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/securez-one/cagent/pkg/common"
+)
+
+const selfTelemetryPrefix = "cagent.self."
+
+// selfTelemetry returns the cagent.self.* measurements describing the agent itself.
+// collectorDurations holds the wall time of the collectors that ran in this collection.
+// The payload size, the Hub latency and the status code refer to the previous delivery, the counters are totals since the start
+func (ca *Cagent) selfTelemetry(collectorDurations map[string]time.Duration) common.MeasurementsMap {
+	m := common.MeasurementsMap{}
+
+	for name, d := range collectorDurations {
+		m["collectors."+name+".duration_ms"] = d.Milliseconds()
+	}
+
+	commandsCount, commandsDuration := common.CommandsStats()
+	m["commands.count"] = commandsCount
+	m["commands.duration_ms"] = commandsDuration.Milliseconds()
+
+	ca.status.mu.RLock()
+	m["payload.size_B"] = ca.status.payloadSize
+	m["payload.gzip_size_B"] = ca.status.payloadGzipSize
+	m["hub.latency_ms"] = ca.status.hub.LastLatencyMs
+	m["hub.status_code"] = ca.status.hub.LastStatusCode
+	m["hub.success"] = boolToInt(ca.status.hub.succeeded())
+	for name, dest := range ca.status.hubDestinations {
+		prefix := "hub_destinations." + name + "."
+		m[prefix+"success"] = boolToInt(dest.succeeded())
+		m[prefix+"url"] = dest.URL
+		m[prefix+"latency_ms"] = dest.LastLatencyMs
+		m[prefix+"status_code"] = dest.LastStatusCode
+		m[prefix+"consecutive_failures"] = dest.ConsecutiveFailures
+		m[prefix+"delivered"] = dest.Delivered
+		m[prefix+"dropped"] = dest.Dropped
+	}
+	m["retries.current"] = ca.status.retry.Retries
+	m["retries.total"] = ca.status.retry.TotalRetries
+	ca.status.mu.RUnlock()
+
+	m["hub.url"] = ca.hub().activeURL()
+
+	m["goroutines"] = runtime.NumGoroutine()
+
+	if p, err := process.NewProcess(int32(os.Getpid())); err != nil {
+		log.WithError(err).Debug("self telemetry: failed to read the own process")
+	} else {
+		addProcessUsage(p, m)
+	}
+
+	return common.MeasurementsMap{}.AddWithPrefix(selfTelemetryPrefix, m)
+}
+
+func addProcessUsage(p *process.Process, m common.MeasurementsMap) {
+	if mem, err := p.MemoryInfo(); err != nil {
+		log.WithError(err).Debug("self telemetry: failed to read the memory usage")
+	} else {
+		m["mem.rss_B"] = mem.RSS
+	}
+
+	if times, err := p.Times(); err != nil {
+		log.WithError(err).Debug("self telemetry: failed to read the CPU time")
+	} else {
+		m["cpu.time_s"] = common.RoundToTwoDecimalPlaces(times.User + times.System)
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
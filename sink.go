@@ -0,0 +1,191 @@
+package cagent
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/securez-one/cagent/pkg/common"
+)
+
+const unixSocketSinkTimeout = 10 * time.Second
+
+// Sink is a destination for the collected results
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, result *Result) error
+	Close() error
+}
+
+type configuredSink struct {
+	Sink
+	onError string
+}
+
+func (ca *Cagent) initSinks() {
+	cfg := ca.Config.Sinks
+
+	if cfg.Hub.Enabled {
+		ca.sinks = append(ca.sinks, configuredSink{&hubSink{ca: ca}, cfg.Hub.OnError})
+	}
+
+	if cfg.File.Enabled {
+		s := newFileSink(cfg.File.Path, int64(cfg.File.MaxSizeMB*1024*1024), cfg.File.MaxBackups)
+		ca.sinks = append(ca.sinks, configuredSink{s, cfg.File.OnError})
+	}
+
+	if cfg.Stdout.Enabled {
+		ca.sinks = append(ca.sinks, configuredSink{&stdoutSink{}, cfg.Stdout.OnError})
+	}
+
+	if cfg.UnixSocket.Enabled {
+		ca.sinks = append(ca.sinks, configuredSink{&unixSocketSink{path: cfg.UnixSocket.Path}, cfg.UnixSocket.OnError})
+	}
+
+	if cfg.InfluxDB.Enabled {
+		ca.sinks = append(ca.sinks, configuredSink{newInfluxDBSink(cfg.InfluxDB), cfg.InfluxDB.OnError})
+	}
+
+	if cfg.Graphite.Enabled {
+		ca.sinks = append(ca.sinks, configuredSink{newGraphiteSink(cfg.Graphite), cfg.Graphite.OnError})
+	}
+
+	if cfg.OTLP.Enabled {
+		ca.sinks = append(ca.sinks, configuredSink{newOTLPSink(ca, cfg.OTLP), cfg.OTLP.OnError})
+	}
+}
+
+// writeToSinks delivers the result to every enabled sink.
+// Sinks that already accepted the result are skipped, so a retry after a Hub error doesn't produce duplicates.
+// Errors of the Hub sink are returned unchanged to let the caller apply the Hub retry logic.
+func (ca *Cagent) writeToSinks(d *delivery, result *Result) error {
+	var hubErr error
+	var errs common.ErrorCollector
+
+	for _, s := range d.sinks {
+		if result.isDeliveredTo(s.Name()) {
+			continue
+		}
+
+		ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Minute)
+		err := s.Write(ctx, result)
+		cancelFn()
+		if err == nil {
+			result.markDeliveredTo(s.Name())
+			continue
+		}
+
+		if s.onError == SinkOnErrorLog {
+			log.WithError(err).Errorf("failed to write results to the %s sink", s.Name())
+			continue
+		}
+
+		if _, isHub := s.Sink.(*hubSink); isHub {
+			hubErr = err
+			continue
+		}
+
+		errs.Add(errors.Wrapf(err, "failed to write results to the %s sink", s.Name()))
+	}
+
+	if hubErr != nil {
+		if errs.HasErrors() {
+			log.WithError(errs.Combine()).Error()
+		}
+		return hubErr
+	}
+
+	return errs.Combine()
+}
+
+func (ca *Cagent) closeSinks() {
+	for _, s := range ca.sinks {
+		if err := s.Close(); err != nil {
+			log.WithError(err).Warnf("failed to close the %s sink", s.Name())
+		}
+	}
+	ca.sinks = nil
+}
+
+type hubSink struct {
+	ca *Cagent
+}
+
+func (s *hubSink) Name() string {
+	return "hub"
+}
+
+func (s *hubSink) Write(ctx context.Context, result *Result) error {
+	d := s.ca.deliverySnapshot()
+	if d.cfg.Logs.HubFile != "" {
+		s.ca.prettyPrintMeasurementsToFile(result.Measurements, d.cfg.Logs.HubFile)
+	}
+
+	err := s.ca.postResultToHub(ctx, d, result)
+	if err != nil {
+		if cause := errors.Cause(err); cause == ErrHubTooManyRequests || cause == ErrHubServerError || cause == ErrHubUnauthorized {
+			return err
+		}
+		err = errors.Wrap(err, "failed to POST measurement result to Hub")
+	}
+
+	return err
+}
+
+func (s *hubSink) Close() error {
+	return nil
+}
+
+type stdoutSink struct{}
+
+func (s *stdoutSink) Name() string {
+	return "stdout"
+}
+
+func (s *stdoutSink) Write(_ context.Context, result *Result) error {
+	err := json.NewEncoder(os.Stdout).Encode(result)
+	if err != nil {
+		return errors.Wrap(err, "failed to JSON encode measurement result")
+	}
+	return nil
+}
+
+func (s *stdoutSink) Close() error {
+	return nil
+}
+
+type unixSocketSink struct {
+	path string
+}
+
+func (s *unixSocketSink) Name() string {
+	return "unix_socket"
+}
+
+func (s *unixSocketSink) Write(ctx context.Context, result *Result) error {
+	var d net.Dialer
+	ctx, cancelFn := context.WithTimeout(ctx, unixSocketSinkTimeout)
+	defer cancelFn()
+
+	conn, err := d.DialContext(ctx, "unix", s.path)
+	if err != nil {
+		return errors.Wrapf(err, "could not connect to %s", s.path)
+	}
+	defer conn.Close()
+
+	_ = conn.SetWriteDeadline(time.Now().Add(unixSocketSinkTimeout))
+	err = json.NewEncoder(conn).Encode(result)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write measurement result to %s", s.path)
+	}
+	return nil
+}
+
+func (s *unixSocketSink) Close() error {
+	return nil
+}
@@ -0,0 +1,141 @@
+package cagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// fileSink appends results as JSON lines and rotates the file once it grows over maxSize:
+// path -> path.1 -> path.2 ... -> path.<maxBackups>
+type fileSink struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func newFileSink(path string, maxSize int64, maxBackups int) *fileSink {
+	return &fileSink{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+}
+
+func (s *fileSink) Name() string {
+	return "file"
+}
+
+func (s *fileSink) Write(_ context.Context, result *Result) error {
+	b, err := json.Marshal(result)
+	if err != nil {
+		return errors.Wrap(err, "failed to JSON encode measurement result")
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file != nil && s.maxSize > 0 && s.size+int64(len(b)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(b)
+	s.size += int64(n)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write to %s", s.path)
+	}
+	return nil
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *fileSink) open() error {
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "failed to create the dir %s", dir)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", s.path)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrapf(err, "failed to stat %s", s.path)
+	}
+
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *fileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return errors.Wrapf(err, "failed to close %s", s.path)
+	}
+	s.file = nil
+
+	if s.maxBackups == 0 {
+		return removeIfExists(s.path)
+	}
+
+	if err := removeIfExists(s.backupPath(s.maxBackups)); err != nil {
+		return err
+	}
+
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		err := os.Rename(s.backupPath(i), s.backupPath(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to rotate %s", s.backupPath(i))
+		}
+	}
+
+	err := os.Rename(s.path, s.backupPath(1))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to rotate %s", s.path)
+	}
+	return nil
+}
+
+func (s *fileSink) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d", s.path, n)
+}
+
+func removeIfExists(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove %s", path)
+	}
+	return nil
+}
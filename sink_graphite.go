@@ -0,0 +1,68 @@
+package cagent
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/securez-one/cagent/pkg/metrics"
+)
+
+const graphiteSinkTimeout = 10 * time.Second
+
+// graphiteSink writes the numeric measurements to Carbon using the Graphite plaintext protocol over TCP.
+// A new connection is established for each result as results are sent once per interval
+type graphiteSink struct {
+	cfg      GraphiteSinkConfig
+	hostname string
+}
+
+func newGraphiteSink(cfg GraphiteSinkConfig) *graphiteSink {
+	hostname, _ := os.Hostname()
+	return &graphiteSink{
+		cfg:      cfg,
+		hostname: hostname,
+	}
+}
+
+func (s *graphiteSink) Name() string {
+	return "graphite"
+}
+
+func (s *graphiteSink) Write(ctx context.Context, result *Result) error {
+	points := metrics.Flatten(result.Measurements)
+	if len(points) == 0 {
+		return nil
+	}
+
+	buf := &bytes.Buffer{}
+	err := metrics.WriteGraphite(buf, points, s.cfg.Prefix, s.hostname, result.Timestamp)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode measurements")
+	}
+
+	var d net.Dialer
+	ctx, cancelFn := context.WithTimeout(ctx, graphiteSinkTimeout)
+	defer cancelFn()
+
+	conn, err := d.DialContext(ctx, "tcp", s.cfg.Address)
+	if err != nil {
+		return errors.Wrapf(err, "could not connect to %s", s.cfg.Address)
+	}
+	defer conn.Close()
+
+	_ = conn.SetWriteDeadline(time.Now().Add(graphiteSinkTimeout))
+	_, err = buf.WriteTo(conn)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write measurements to %s", s.cfg.Address)
+	}
+	return nil
+}
+
+func (s *graphiteSink) Close() error {
+	return nil
+}
@@ -0,0 +1,102 @@
+package cagent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/securez-one/cagent/pkg/metrics"
+)
+
+// influxDBSink writes the numeric measurements to the InfluxDB HTTP write API using the line protocol.
+// Both /write of InfluxDB 1.x and /api/v2/write of InfluxDB 2.x are supported depending on the configured URL
+type influxDBSink struct {
+	cfg      InfluxDBSinkConfig
+	hostname string
+	client   *http.Client
+}
+
+func newInfluxDBSink(cfg InfluxDBSinkConfig) *influxDBSink {
+	hostname, _ := os.Hostname()
+	return &influxDBSink{
+		cfg:      cfg,
+		hostname: hostname,
+		client: &http.Client{
+			Timeout: time.Duration(cfg.Timeout) * time.Second,
+		},
+	}
+}
+
+func (s *influxDBSink) Name() string {
+	return "influxdb"
+}
+
+func (s *influxDBSink) Write(ctx context.Context, result *Result) error {
+	points := metrics.Flatten(result.Measurements)
+	if len(points) == 0 {
+		return nil
+	}
+
+	var extraTags map[string]string
+	if s.hostname != "" {
+		extraTags = map[string]string{"host": s.hostname}
+	}
+
+	buf := &bytes.Buffer{}
+	err := metrics.WriteInfluxLineProtocol(buf, points, extraTags, result.Timestamp)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode measurements")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.writeURL(), buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+s.cfg.Token)
+	} else if s.cfg.User != "" {
+		req.SetBasicAuth(s.cfg.User, s.cfg.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to POST to %s", s.cfg.URL)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s responded with %s: %s", s.cfg.URL, resp.Status, bytes.TrimSpace(body))
+	}
+
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	return nil
+}
+
+// writeURL adds the precision param to the configured URL, the timestamps of results are in seconds
+func (s *influxDBSink) writeURL() string {
+	u, err := url.Parse(s.cfg.URL)
+	if err != nil {
+		// already checked by the config validation
+		return s.cfg.URL
+	}
+
+	q := u.Query()
+	q.Set("precision", "s")
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func (s *influxDBSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
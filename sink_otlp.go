@@ -0,0 +1,133 @@
+package cagent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/metrics"
+	"github.com/securez-one/cagent/pkg/monitoring"
+	"github.com/securez-one/cagent/pkg/otlp"
+)
+
+// resource attributes of OTLP messages mapped from the fields of HostInfoResults
+var otlpResourceAttributeBySystemField = map[string]string{
+	"fqdn":      "host.name",
+	"os_family": "os.family",
+	"os_kernel": "os.type",
+	"os_arch":   "host.arch",
+}
+
+// otlpSink exports the numeric measurements as OTLP gauges
+// and the alerts and warnings of module reports as OTLP log records
+type otlpSink struct {
+	ca       *Cagent
+	exporter *otlp.Exporter
+
+	hostInfoOnce sync.Once
+	hostInfo     common.MeasurementsMap
+}
+
+func newOTLPSink(ca *Cagent, cfg OTLPSinkConfig) *otlpSink {
+	return &otlpSink{
+		ca:       ca,
+		exporter: otlp.NewExporter(cfg.Endpoint, cfg.Headers, time.Duration(cfg.Timeout)*time.Second),
+	}
+}
+
+func (s *otlpSink) Name() string {
+	return "otlp"
+}
+
+func (s *otlpSink) Write(ctx context.Context, result *Result) error {
+	ts := time.Unix(result.Timestamp, 0)
+	resource := s.resourceAttributes(result.Measurements)
+
+	var points []metrics.Point
+	for _, p := range metrics.Flatten(result.Measurements) {
+		// module measurements are exported as log records
+		if p.Measurement != "module" {
+			points = append(points, p)
+		}
+	}
+
+	if len(points) > 0 {
+		err := s.exporter.ExportMetrics(ctx, otlp.EncodeMetricsRequest(resource, Version, points, ts))
+		if err != nil {
+			return errors.Wrap(err, "failed to export metrics")
+		}
+	}
+
+	records := moduleReportsToLogRecords(result.Measurements, ts)
+	if len(records) > 0 {
+		err := s.exporter.ExportLogs(ctx, otlp.EncodeLogsRequest(resource, Version, records))
+		if err != nil {
+			return errors.Wrap(err, "failed to export logs")
+		}
+	}
+
+	return nil
+}
+
+func (s *otlpSink) Close() error {
+	s.exporter.CloseIdleConnections()
+	return nil
+}
+
+// resourceAttributes takes the host info from the result if it was collected,
+// otherwise HostInfoResults is called once, e.g. in the minimal operation mode
+func (s *otlpSink) resourceAttributes(measurements common.MeasurementsMap) map[string]string {
+	attributes := map[string]string{
+		"service.name":    "cagent",
+		"service.version": Version,
+	}
+
+	for field, attribute := range otlpResourceAttributeBySystemField {
+		if v, ok := measurements["system."+field].(string); ok && v != "" {
+			attributes[attribute] = v
+			continue
+		}
+
+		if v, ok := s.cachedHostInfo()[field].(string); ok && v != "" {
+			attributes[attribute] = v
+		}
+	}
+
+	return attributes
+}
+
+func (s *otlpSink) cachedHostInfo() common.MeasurementsMap {
+	s.hostInfoOnce.Do(func() {
+		// errors are already logged by HostInfoResults, partial results are still usable
+		s.hostInfo, _ = s.ca.HostInfoResults()
+	})
+	return s.hostInfo
+}
+
+func moduleReportsToLogRecords(measurements common.MeasurementsMap, ts time.Time) []otlp.LogRecord {
+	reports, _ := measurements["modules"].([]*monitoring.ModuleReport)
+
+	var records []otlp.LogRecord
+	for _, r := range reports {
+		if r == nil {
+			continue
+		}
+
+		attributes := map[string]string{"module": r.Name}
+		if r.Message != "" {
+			attributes["message"] = r.Message
+		}
+
+		for _, a := range r.Alerts {
+			records = append(records, otlp.LogRecord{Time: ts, Severity: otlp.SeverityError, Body: string(a), Attributes: attributes})
+		}
+		for _, w := range r.Warnings {
+			records = append(records, otlp.LogRecord{Time: ts, Severity: otlp.SeverityWarn, Body: string(w), Attributes: attributes})
+		}
+	}
+
+	return records
+}
@@ -0,0 +1,108 @@
+package cagent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/securez-one/cagent/pkg/common"
+)
+
+func countLines(t *testing.T, path string) int {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines++
+	}
+	require.NoError(t, scanner.Err())
+	return lines
+}
+
+func TestFileSinkRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "results.jsonl")
+
+	result := newResult(common.MeasurementsMap{"key": 1})
+	line, err := json.Marshal(result)
+	require.NoError(t, err)
+
+	// two results fit into a file, two backups are kept
+	s := newFileSink(path, int64(2*(len(line)+1)), 2)
+	defer s.Close()
+	for i := 0; i < 7; i++ {
+		require.NoError(t, s.Write(context.Background(), result))
+	}
+
+	assert.Equal(t, 1, countLines(t, path))
+	assert.Equal(t, 2, countLines(t, path+".1"))
+	assert.Equal(t, 2, countLines(t, path+".2"))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "the oldest backup is removed")
+
+	// without backups the file starts over
+	path = filepath.Join(dir, "nobackups.jsonl")
+	s = newFileSink(path, int64(2*(len(line)+1)), 0)
+	defer s.Close()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.Write(context.Background(), result))
+	}
+
+	assert.Equal(t, 1, countLines(t, path))
+	_, err = os.Stat(path + ".1")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWriteToSinksKeepsHubErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "results.jsonl")
+
+	hubStatus := http.StatusInternalServerError
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(hubStatus)
+	}))
+	defer hub.Close()
+
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+	ca.Config.HubURL = hub.URL
+	ca.closeSinks()
+	ca.sinks = []configuredSink{
+		{&hubSink{ca: ca}, SinkOnErrorFail},
+		{&unixSocketSink{path: filepath.Join(dir, "missing.sock")}, SinkOnErrorFail},
+		{newFileSink(path, 0, 0), SinkOnErrorFail},
+	}
+
+	// the Hub error decides about the retry and the outbox, not the failing socket
+	result := newResult(common.MeasurementsMap{"key": 1})
+	err = ca.writeToSinks(ca.deliverySnapshot(), result)
+	assert.Equal(t, ErrHubServerError, errors.Cause(err))
+	assert.True(t, isHubUnreachable(err))
+
+	// the retry doesn't write the result to the file again
+	hubStatus = http.StatusOK
+	err = ca.writeToSinks(ca.deliverySnapshot(), result)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unix_socket")
+		assert.False(t, isHubUnreachable(err), "the result is not stored in the outbox")
+	}
+	assert.True(t, result.isDeliveredTo("hub"))
+	assert.Equal(t, 1, countLines(t, path))
+}
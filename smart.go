@@ -1,14 +1,16 @@
 package cagent
 
 import (
+	"context"
 	"strings"
 
 	"github.com/securez-one/cagent/pkg/common"
+	"github.com/securez-one/cagent/pkg/smart"
 )
 
-func (ca *Cagent) getSMARTMeasurements() common.MeasurementsMap {
-	if ca.smart != nil {
-		res, errs := ca.smart.Parse()
+func getSMARTMeasurements(ctx context.Context, sm *smart.SMART) common.MeasurementsMap {
+	if sm != nil {
+		res, errs := sm.Parse(ctx)
 
 		if len(errs) > 0 {
 			var errStr []string
@@ -0,0 +1,274 @@
+package cagent
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/securez-one/cagent/pkg/outbox"
+)
+
+// Status describes the state of the running agent as returned by the status API
+type Status struct {
+	Version       string             `json:"version"`
+	OperationMode string             `json:"operation_mode"`
+	StartedAt     time.Time          `json:"started_at"`
+	UptimeSeconds int64              `json:"uptime_s"`
+	Collection    CollectionStatus   `json:"last_collection"`
+	Hub           HubStatus          `json:"hub"`
+	Retry         RetryStatus        `json:"retry"`
+	OutboxEntries int                `json:"outbox_entries"`
+	RemoteConfig  RemoteConfigStatus `json:"remote_config"`
+
+	HubDestinations map[string]HubDestinationStatus `json:"hub_destinations,omitempty"`
+}
+
+// CollectionStatus describes the last run of collectMeasurements
+type CollectionStatus struct {
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DurationMs int64      `json:"duration_ms"`
+	Errors     []string   `json:"errors"`
+}
+
+// HubStatus describes the last request made to the Hub, either measurements or heartbeat
+type HubStatus struct {
+	LastRequestAt  *time.Time `json:"last_request_at,omitempty"`
+	LastSuccessAt  *time.Time `json:"last_success_at,omitempty"`
+	LastStatusCode int        `json:"last_status_code"`
+	LastLatencyMs  int64      `json:"last_latency_ms"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+// HubDestinationStatus describes the deliveries to a [[hub_destinations]] entry
+type HubDestinationStatus struct {
+	HubStatus
+	// URL is the URL of the last request
+	URL string `json:"url"`
+	// ConsecutiveFailures counts the failed attempts since the last successful delivery
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	NextAttemptAt       *time.Time `json:"next_attempt_at,omitempty"`
+	Delivered           int64      `json:"delivered"`
+	// Dropped counts the results refused by the destination or replaced by a newer one before they were delivered
+	Dropped int64 `json:"dropped"`
+}
+
+// succeeded returns true if the last request was successful
+func (h HubStatus) succeeded() bool {
+	return h.LastRequestAt != nil && h.LastSuccessAt != nil && h.LastSuccessAt.Equal(*h.LastRequestAt)
+}
+
+// RetryStatus describes the retry state of the main loop after a failed delivery
+type RetryStatus struct {
+	Retries int `json:"retries"`
+	// TotalRetries counts the retries since the start
+	TotalRetries  int        `json:"total_retries"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"`
+}
+
+// RemoteConfigStatus describes the remote config overlay received from the Hub
+type RemoteConfigStatus struct {
+	Version         string `json:"version,omitempty"`
+	RejectedVersion string `json:"rejected_version,omitempty"`
+	LastError       string `json:"last_error,omitempty"`
+}
+
+// agentStatus accumulates the state reported by the status API. It is updated from the Run and RunHeartbeat loops
+type agentStatus struct {
+	mu         sync.RWMutex
+	startedAt  time.Time
+	collection CollectionStatus
+	hub        HubStatus
+	retry      RetryStatus
+
+	remoteConfig RemoteConfigStatus
+
+	hubDestinations map[string]*HubDestinationStatus
+
+	// taken from the active config, Status doesn't hold the reload lock
+	operationMode       string
+	remoteConfigVersion string
+	outbox              *outbox.Outbox
+
+	// sizes of the last measurements payload sent to the Hub before and after gzip compression
+	payloadSize, payloadGzipSize int
+}
+
+func (s *agentStatus) setCollection(started time.Time, errs []error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.collection.FinishedAt = &now
+	s.collection.DurationMs = time.Since(started).Milliseconds()
+	s.collection.Errors = make([]string, 0, len(errs))
+	for _, err := range errs {
+		s.collection.Errors = append(s.collection.Errors, err.Error())
+	}
+}
+
+func (s *agentStatus) setHubRequest(started time.Time, resp *http.Response, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hub.update(started, resp, err)
+}
+
+func (h *HubStatus) update(started time.Time, resp *http.Response, err error) {
+	h.LastRequestAt = &started
+	h.LastLatencyMs = time.Since(started).Milliseconds()
+	h.LastStatusCode = 0
+	h.LastError = ""
+	if resp != nil {
+		h.LastStatusCode = resp.StatusCode
+	}
+
+	switch {
+	case err != nil:
+		h.LastError = err.Error()
+	case resp != nil && resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		h.LastSuccessAt = &started
+	case resp != nil:
+		h.LastError = resp.Status
+	}
+}
+
+func (s *agentStatus) initHubDestination(name, url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hubDestinations == nil {
+		s.hubDestinations = make(map[string]*HubDestinationStatus)
+	}
+	s.hubDestinations[name] = &HubDestinationStatus{URL: url}
+}
+
+// clearHubDestinations removes the destinations, they are added again if they are still configured after a reload
+func (s *agentStatus) clearHubDestinations() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hubDestinations = nil
+}
+
+func (s *agentStatus) setHubDestinationRequest(name, url string, started time.Time, resp *http.Response, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st := s.hubDestinations[name]
+	st.update(started, resp, err)
+	st.URL = url
+	st.NextAttemptAt = nil
+	if st.succeeded() {
+		st.ConsecutiveFailures = 0
+	} else {
+		st.ConsecutiveFailures++
+	}
+}
+
+func (s *agentStatus) setHubDestinationRetry(name string, retryIn time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := time.Now().Add(retryIn)
+	s.hubDestinations[name].NextAttemptAt = &next
+}
+
+func (s *agentStatus) addHubDestinationDelivered(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hubDestinations[name].Delivered++
+}
+
+func (s *agentStatus) addHubDestinationDropped(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hubDestinations[name].Dropped++
+}
+
+func (s *agentStatus) setRetry(retries int, retryIn time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retry.Retries = retries
+	if retries > 0 {
+		s.retry.TotalRetries++
+	}
+	next := time.Now().Add(retryIn)
+	s.retry.NextAttemptAt = &next
+	s.retry.LastError = ""
+	if err != nil {
+		s.retry.LastError = err.Error()
+	}
+}
+
+// setPayloadSize records the size of the serialized measurements, gzippedSize is 0 if hub_gzip is disabled
+func (s *agentStatus) setPayloadSize(size, gzippedSize int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.payloadSize = size
+	s.payloadGzipSize = gzippedSize
+}
+
+// setRemoteConfigRejected records the version of the rejected remote config, empty version resets it
+func (s *agentStatus) setRemoteConfigRejected(version string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remoteConfig.RejectedVersion = version
+	s.remoteConfig.LastError = ""
+	if err != nil {
+		s.remoteConfig.LastError = err.Error()
+	}
+}
+
+// remoteConfigRejected returns the version of the rejected remote config and the reason
+func (s *agentStatus) remoteConfigRejected() (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.remoteConfig.RejectedVersion, s.remoteConfig.LastError
+}
+
+// setActiveConfig is called whenever the config is replaced, with the reload lock held
+func (s *agentStatus) setActiveConfig(cfg *Config, ob *outbox.Outbox) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.operationMode = cfg.OperationMode
+	s.remoteConfigVersion = cfg.RemoteConfigVersion()
+	s.outbox = ob
+}
+
+func (ca *Cagent) Status() Status {
+	ca.status.mu.RLock()
+	defer ca.status.mu.RUnlock()
+
+	st := Status{
+		Version:       Version,
+		OperationMode: ca.status.operationMode,
+		StartedAt:     ca.status.startedAt,
+		UptimeSeconds: int64(time.Since(ca.status.startedAt).Seconds()),
+		Collection:    ca.status.collection,
+		Hub:           ca.status.hub,
+		Retry:         ca.status.retry,
+		RemoteConfig:  ca.status.remoteConfig,
+	}
+	st.RemoteConfig.Version = ca.status.remoteConfigVersion
+
+	for name, dest := range ca.status.hubDestinations {
+		if st.HubDestinations == nil {
+			st.HubDestinations = make(map[string]HubDestinationStatus, len(ca.status.hubDestinations))
+		}
+		st.HubDestinations[name] = *dest
+	}
+
+	if ca.status.outbox != nil {
+		st.OutboxEntries = ca.status.outbox.Len()
+	}
+
+	return st
+}
@@ -0,0 +1,155 @@
+package cagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	statusAPIUnixPrefix      = "unix:"
+	statusAPIShutdownTimeout = 5 * time.Second
+	statusAPIClientTimeout   = 10 * time.Second
+)
+
+// startStatusAPI serves the read-only status API on the loopback interface or a unix socket:
+//
+//	GET /status - Status of the agent
+//	GET /result - last collected Result
+func (ca *Cagent) startStatusAPI() (stop func()) {
+	listen := ca.Config.StatusAPI.Listen
+	if listen == "" {
+		return func() {}
+	}
+
+	network, address := statusAPIAddress(listen)
+	if network == "unix" {
+		// remove the socket left by a previous run
+		_ = os.Remove(address)
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		log.WithError(err).Errorf("status API: could not listen on %s", listen)
+		return func() {}
+	}
+
+	if network == "unix" {
+		// only the owner and the group are allowed to query the agent
+		if err := os.Chmod(address, 0660); err != nil {
+			log.WithError(err).Warnf("status API: could not set permissions of %s", address)
+		}
+	}
+
+	srv := &http.Server{
+		Handler:      ca.statusAPIHandler(),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	go func() {
+		log.Infof("status API: listening on %s", listen)
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Error("status API: server stopped")
+		}
+	}()
+
+	return func() {
+		ctx, cancelFn := context.WithTimeout(context.Background(), statusAPIShutdownTimeout)
+		defer cancelFn()
+		_ = srv.Shutdown(ctx)
+	}
+}
+
+func (ca *Cagent) statusAPIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", ca.serveStatus)
+	mux.HandleFunc("/result", ca.serveLastResult)
+	return mux
+}
+
+func (ca *Cagent) serveStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeStatusAPIResponse(w, ca.Status())
+}
+
+func (ca *Cagent) serveLastResult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	result := ca.lastResult()
+	if result == nil {
+		http.Error(w, "no measurements collected yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeStatusAPIResponse(w, result)
+}
+
+func writeStatusAPIResponse(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.WithError(err).Debug("status API: failed to write response")
+	}
+}
+
+// QueryStatusAPI requests the path from the status API of the running agent and returns the response body
+func QueryStatusAPI(listen, path string) ([]byte, error) {
+	if listen == "" {
+		return nil, errors.New("the status API is disabled, set [status_api] listen in the config")
+	}
+
+	network, address := statusAPIAddress(listen)
+	url := "http://" + address + path
+
+	transport := &http.Transport{}
+	if network == "unix" {
+		url = "http://unix" + path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", address)
+		}
+	}
+
+	client := &http.Client{Transport: transport, Timeout: statusAPIClientTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not connect to the status API on %s, is cagent running?", listen)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the status API response")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status API responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return body, nil
+}
+
+func statusAPIAddress(listen string) (network, address string) {
+	if strings.HasPrefix(listen, statusAPIUnixPrefix) {
+		return "unix", strings.TrimPrefix(listen, statusAPIUnixPrefix)
+	}
+	return "tcp", listen
+}
@@ -0,0 +1,119 @@
+package cagent
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/securez-one/cagent/pkg/common"
+)
+
+func TestStatusAPI(t *testing.T) {
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+
+	api := httptest.NewServer(ca.statusAPIHandler())
+	defer api.Close()
+
+	get := func(path string, v interface{}) int {
+		resp, err := http.Get(api.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+
+	var result Result
+	assert.Equal(t, http.StatusServiceUnavailable, get("/result", &result), "nothing collected yet")
+
+	started := time.Now().Add(-150 * time.Millisecond)
+	ca.status.setCollection(started, []error{errors.New("cpu: timeout")})
+	ca.status.setHubRequest(started, &http.Response{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable"}, nil)
+	ca.status.setRetry(2, time.Minute, ErrHubServerError)
+	ca.setLastResult(newResult(common.MeasurementsMap{"key": 1}))
+
+	var st Status
+	require.Equal(t, http.StatusOK, get("/status", &st))
+	assert.Equal(t, Version, st.Version)
+	assert.Equal(t, ca.Config.OperationMode, st.OperationMode)
+	assert.True(t, st.UptimeSeconds >= 0)
+	assert.False(t, st.StartedAt.IsZero())
+	assert.Equal(t, []string{"cpu: timeout"}, st.Collection.Errors)
+	assert.NotNil(t, st.Collection.FinishedAt)
+	assert.Equal(t, http.StatusServiceUnavailable, st.Hub.LastStatusCode)
+	assert.True(t, st.Hub.LastLatencyMs >= 150)
+	assert.Equal(t, "503 Service Unavailable", st.Hub.LastError)
+	assert.Nil(t, st.Hub.LastSuccessAt)
+	assert.Equal(t, 2, st.Retry.Retries)
+	assert.NotNil(t, st.Retry.NextAttemptAt)
+	assert.Equal(t, ErrHubServerError.Error(), st.Retry.LastError)
+
+	require.Equal(t, http.StatusOK, get("/result", &result))
+	assert.Equal(t, float64(1), result.Measurements["key"])
+
+	resp, err := http.Post(api.URL+"/status", "application/json", strings.NewReader("{}"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestQueryStatusAPI(t *testing.T) {
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/status" {
+			http.Error(w, "no measurements collected yet", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"version": "1.0.0"}`))
+	}))
+	defer stub.Close()
+	listen := strings.TrimPrefix(stub.URL, "http://")
+
+	body, err := QueryStatusAPI(listen, "/status")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"version": "1.0.0"}`, string(body))
+
+	_, err = QueryStatusAPI(listen, "/result")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "no measurements collected yet")
+	}
+
+	_, err = QueryStatusAPI("", "/status")
+	assert.Error(t, err, "the status API is disabled")
+
+	stub.Close()
+	_, err = QueryStatusAPI(listen, "/status")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is cagent running?")
+	}
+}
+
+func TestQueryStatusAPIUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "status")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ca := helperCreateCagent(t)
+	defer ca.Shutdown()
+	ca.Config.StatusAPI.Listen = statusAPIUnixPrefix + filepath.Join(dir, "status.sock")
+
+	stop := ca.startStatusAPI()
+	defer stop()
+
+	body, err := QueryStatusAPI(ca.Config.StatusAPI.Listen, "/status")
+	require.NoError(t, err)
+	var st Status
+	require.NoError(t, json.Unmarshal(body, &st))
+	assert.Equal(t, Version, st.Version)
+}
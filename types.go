@@ -11,6 +11,24 @@ type Result struct {
 	Timestamp    int64                  `json:"timestamp"`
 	Measurements common.MeasurementsMap `json:"measurements"`
 	Message      interface{}            `json:"message"`
+
+	// ActionResults acknowledge the actions requested by the Hub, they are only sent to the Hub
+	ActionResults []HubActionResult `json:"action_results,omitempty"`
+
+	// names of the sinks that already accepted the result
+	deliveredTo map[string]struct{}
+}
+
+func (r *Result) isDeliveredTo(sink string) bool {
+	_, ok := r.deliveredTo[sink]
+	return ok
+}
+
+func (r *Result) markDeliveredTo(sink string) {
+	if r.deliveredTo == nil {
+		r.deliveredTo = make(map[string]struct{})
+	}
+	r.deliveredTo[sink] = struct{}{}
 }
 
 func floatToIntPercentRoundUP(f float64) int {